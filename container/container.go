@@ -24,7 +24,25 @@ type InputStream chan string
 
 type OutputStream chan string
 
-type ExitStatus chan int
+type ExitStatus chan Exit
+
+// An ExitCause explains why a command terminated abnormally.
+type ExitCause string
+
+const (
+	ExitNormal    ExitCause = ""           // no cause is known
+	ExitOOMKilled ExitCause = "oom_killed" // a process was killed because the container ran out of memory
+	ExitPidsLimit ExitCause = "pids_limit" // a process could not be created because the container reached its process limit
+)
+
+/*
+	An Exit is the return code of a command together with the cause of its abnormal termination, if the
+	return code is non-zero and a cause is known.
+*/
+type Exit struct {
+	Code  int
+	Cause ExitCause
+}
 
 /*
 	A Container is a function which runs a given command with a given input stream and returns an output
@@ -32,6 +50,7 @@ type ExitStatus chan int
 	is returned along with nil for the other return values. If the command can be run, a nil error is
 	returned and the command runs asynchronously to the caller. The caller may write to the input stream
 	and read from the output and error streams. The exit status is available for reading by the caller once
-	the command has returned. The value of the exit status is the return code of the command.
+	the command has returned. The value of the exit status is the return code of the command and any known
+	cause of its abnormal termination.
 */
-type Container func(command string, input InputStream) (output OutputStream, err OutputStream, status ExitStatus, err error)
+type Container func(command string, input InputStream) (output OutputStream, errOutput OutputStream, status ExitStatus, err error)
@@ -0,0 +1,166 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups_test
+
+import (
+	"github.com/cf-guardian/guardian/container"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/cgroups"
+	"github.com/cf-guardian/guardian/runner"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	runner.Child(func(rCtx kernel.ResourceContext) []kernel.ResourceController {
+		return []kernel.ResourceController{cgroups.New()}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	rCtx := createContext(t, cgroups.Limits{Memory: 64 << 20, Pids: 32})
+	c := cgroups.New()
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer func() {
+		if err := c.Teardown(rCtx); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+
+	cg := c.Cgroup()
+	var memory, pids string
+	switch cg.Version {
+	case cgroups.V1:
+		memory = readFile(t, cg.Paths["memory"], "memory.limit_in_bytes")
+		pids = readFile(t, cg.Paths["pids"], "pids.max")
+	case cgroups.V2:
+		memory = readFile(t, cg.Path, "memory.max")
+		pids = readFile(t, cg.Path, "pids.max")
+	default:
+		t.Errorf("Incorrect cgroup %+v", cg)
+	}
+	if memory != "67108864" || pids != "32" {
+		t.Errorf("Incorrect limits: memory %q, pids %q", memory, pids)
+	}
+}
+
+func TestRunInCgroup(t *testing.T) {
+	rCtx := createContext(t, cgroups.Limits{Pids: 32})
+	c, gerr := runner.BuildContainer(rCtx, []kernel.ResourceController{cgroups.New()})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	output, errOutput, status, err := c("cat /proc/self/cgroup", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	out, errOut := drain(output, errOutput)
+	if s := <-status; s.Code != 0 {
+		t.Errorf("Incorrect exit status %+v: %q", s, errOut)
+	}
+	if !strings.Contains(out, "/"+cgroups.DefaultParent+"/"+rCtx.GetId()+"\n") {
+		t.Errorf("Command did not run in the container's cgroup: %q", out)
+	}
+}
+
+func TestStats(t *testing.T) {
+	rCtx := createContext(t, cgroups.Limits{Pids: 32})
+	rcs := []kernel.ResourceController{cgroups.New()}
+	c, gerr := runner.BuildContainer(rCtx, rcs)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	output, errOutput, status, err := c("sleep 1", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	// The command is still running, so its cgroup exists.
+	stats, gerr := runner.Stats(rCtx, rcs)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+	} else if stats[cgroups.StatPidsCurrent] != 1 || stats[cgroups.StatMemoryCurrent] == 0 {
+		t.Errorf("Incorrect stats %v", stats)
+	}
+	drain(output, errOutput)
+	<-status
+}
+
+func TestOOMKill(t *testing.T) {
+	rCtx := createContext(t, cgroups.Limits{Memory: 16 << 20})
+	c, gerr := runner.BuildContainer(rCtx, []kernel.ResourceController{cgroups.New()})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	// tail buffers its input until it sees a newline, which /dev/zero never provides.
+	output, errOutput, status, err := c("tail /dev/zero", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	drain(output, errOutput)
+	if s := <-status; s.Code != 137 || s.Cause != container.ExitOOMKilled {
+		t.Errorf("Incorrect exit status %+v", s)
+	}
+}
+
+func createContext(t *testing.T, limits cgroups.Limits) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig(cgroups.LimitsKey, limits); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func readFile(t *testing.T, dir string, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// drain reads the given output and error streams until they are closed and returns their contents.
+func drain(output container.OutputStream, errOutput container.OutputStream) (string, string) {
+	var out, errOut []string
+	for output != nil || errOutput != nil {
+		select {
+		case s, ok := <-output:
+			if !ok {
+				output = nil
+			} else {
+				out = append(out, s)
+			}
+		case s, ok := <-errOutput:
+			if !ok {
+				errOutput = nil
+			} else {
+				errOut = append(errOut, s)
+			}
+		}
+	}
+	return strings.Join(out, ""), strings.Join(errOut, "")
+}
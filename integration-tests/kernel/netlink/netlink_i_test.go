@@ -0,0 +1,243 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package netlink_test
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/netlink"
+	"github.com/cf-guardian/guardian/kernel/netlink/netlink_linux"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestLoopback(t *testing.T) {
+	defer throwawayNetNS(t)()
+	nl := netlink_linux.New()
+
+	link, err := nl.LinkByName("lo")
+	if err != nil || link.Name != "lo" || link.Index == 0 || link.Up {
+		t.Errorf("Incorrect return values (%+v, %s)", link, err)
+		return
+	}
+	if err = nl.SetLinkUp("lo"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if link, err = nl.LinkByName("lo"); err != nil || !link.Up {
+		t.Errorf("Loopback device not up (%+v, %s)", link, err)
+	}
+	addrs, err := nl.Addresses("lo")
+	if err != nil || !containsAddress(addrs, "127.0.0.1/8") {
+		t.Errorf("Incorrect addresses (%v, %s)", addrs, err)
+	}
+}
+
+func TestLinkNotFound(t *testing.T) {
+	defer throwawayNetNS(t)()
+	nl := netlink_linux.New()
+
+	if _, err := nl.LinkByName("nosuch"); err == nil || !err.(gerror.Gerror).EqualTag(netlink.ErrLinkNotFound) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	if err := nl.DeleteLink("nosuch"); err == nil || !err.(gerror.Gerror).EqualTag(netlink.ErrLinkNotFound) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	if err := nl.SetLinkUp("nosuch"); err == nil || !err.(gerror.Gerror).EqualTag(netlink.ErrLinkNotFound) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	nl := netlink_linux.New()
+	for _, name := range []string{"", "sixteen-chars-xx"} {
+		if err := nl.AddBridge(name); err == nil || !err.(gerror.Gerror).EqualTag(netlink_linux.ErrInvalidName) {
+			t.Errorf("Incorrect error for %q: %s", name, err)
+		}
+	}
+}
+
+func TestVethPairAndBridge(t *testing.T) {
+	defer throwawayNetNS(t)()
+	nl := netlink_linux.New()
+
+	if err := nl.AddVethPair("veth-a", "veth-b", 0); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if err := nl.AddVethPair("veth-a", "veth-c", 0); err == nil {
+		t.Errorf("Duplicate device created")
+	}
+	if err := nl.AddBridge("br-test"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	bridge, err := nl.LinkByName("br-test")
+	if err != nil || bridge.Kind != "bridge" {
+		t.Errorf("Incorrect return values (%+v, %s)", bridge, err)
+		return
+	}
+	if err := nl.SetLinkMaster("veth-a", "br-test"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	link, err := nl.LinkByName("veth-a")
+	if err != nil || link.Kind != "veth" || link.Master != bridge.Index || link.MTU == 0 || link.Up {
+		t.Errorf("Incorrect return values (%+v, %s)", link, err)
+	}
+
+	if err := nl.DeleteLink("veth-b"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if _, err := nl.LinkByName("veth-a"); err == nil || !err.(gerror.Gerror).EqualTag(netlink.ErrLinkNotFound) {
+		t.Errorf("Deleting one end of a pair did not delete the other: %s", err)
+	}
+}
+
+func TestAddressesAndRoutes(t *testing.T) {
+	defer throwawayNetNS(t)()
+	nl := netlink_linux.New()
+
+	if err := nl.AddVethPair("veth-a", "veth-b", 0); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	for _, cidr := range []string{"10.9.8.2/24", "fd00:9:8::2/64"} {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		if err := nl.AddAddress("veth-a", net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+			t.Errorf("AddAddress(%s) failed: %s", cidr, err)
+			return
+		}
+	}
+	addrs, err := nl.Addresses("veth-a")
+	if err != nil || !containsAddress(addrs, "10.9.8.2/24") || !containsAddress(addrs, "fd00:9:8::2/64") {
+		t.Errorf("Incorrect addresses (%v, %s)", addrs, err)
+	}
+
+	for _, name := range []string{"veth-a", "veth-b"} {
+		if err := nl.SetLinkUp(name); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+	}
+	for _, gateway := range []string{"10.9.8.1", "fd00:9:8::1"} {
+		if err := nl.AddDefaultRoute("veth-a", net.ParseIP(gateway)); err != nil {
+			t.Errorf("AddDefaultRoute(%s) failed: %s", gateway, err)
+			return
+		}
+	}
+	routes, err := nl.Routes("veth-a")
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	for _, gateway := range []string{"10.9.8.1", "fd00:9:8::1"} {
+		if !containsDefaultRoute(routes, gateway) {
+			t.Errorf("Default route via %s not found in %v", gateway, routes)
+		}
+	}
+
+	if err := nl.AddAddress("veth-a", net.IPNet{IP: net.IP{1, 2, 3}, Mask: net.CIDRMask(24, 32)}); err == nil ||
+		!err.(gerror.Gerror).EqualTag(netlink_linux.ErrInvalidAddress) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	if err := nl.AddAddress("veth-a", net.IPNet{IP: net.ParseIP("10.9.8.3"), Mask: net.CIDRMask(64, 128)}); err == nil ||
+		!err.(gerror.Gerror).EqualTag(netlink_linux.ErrInvalidAddress) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestVethPeerInOtherNamespace(t *testing.T) {
+	defer throwawayNetNS(t)()
+	nl := netlink_linux.New()
+
+	cmd := exec.Command("sleep", "10")
+	if err := syscall_linux.NewNS().StartInNamespaces(cmd, syscall.NetNS); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	if err := nl.AddVethPair("veth-host", "veth-peer", cmd.Process.Pid); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if _, err := nl.LinkByName("veth-host"); err != nil {
+		t.Errorf("%s", err)
+	}
+	if _, err := nl.LinkByName("veth-peer"); err == nil || !err.(gerror.Gerror).EqualTag(netlink.ErrLinkNotFound) {
+		t.Errorf("Peer device found in the calling thread's namespace: %s", err)
+	}
+}
+
+/*
+	throwawayNetNS moves the calling goroutine's thread into a new network namespace and returns a function
+	which moves it back. The goroutine is locked to the thread until then.
+*/
+func throwawayNetNS(t *testing.T) func() {
+	if os.Geteuid() != 0 {
+		t.Error("Network namespaces require root privileges - run the test as root")
+		panic("Test aborted, must be run as root")
+	}
+	ns := syscall_linux.NewNS()
+	runtime.LockOSThread()
+	orig, err := ns.OpenNS(0, syscall.NetNS)
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("%s", err)
+	}
+	if err := ns.Unshare(syscall.NetNS); err != nil {
+		orig.Close()
+		runtime.UnlockOSThread()
+		t.Fatalf("%s", err)
+	}
+	return func() {
+		defer orig.Close()
+		if err := ns.Setns(orig, syscall.NetNS); err != nil {
+			t.Errorf("%s", err)
+			// Do not return the thread to the pool in the wrong namespace.
+			runtime.Goexit()
+		}
+		runtime.UnlockOSThread()
+	}
+}
+
+func containsAddress(addrs []net.IPNet, cidr string) bool {
+	for _, addr := range addrs {
+		if addr.String() == cidr {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDefaultRoute(routes []netlink.Route, gateway string) bool {
+	for _, route := range routes {
+		if route.Destination == nil && route.Gateway.Equal(net.ParseIP(gateway)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package netns_test
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/container"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/netlink"
+	"github.com/cf-guardian/guardian/kernel/netlink/netlink_linux"
+	"github.com/cf-guardian/guardian/kernel/netns"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"github.com/cf-guardian/guardian/runner"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	trueSyscall "syscall"
+	"testing"
+	"time"
+)
+
+// helperEnv is set to the name of a helper when the test binary is run as a helper process.
+const helperEnv = "GUARDIAN_TEST_NETNS_HELPER"
+
+// serverEnv is set to the address of the runner helper's server when the test binary is run as a container's command.
+const serverEnv = "GUARDIAN_TEST_NETNS_SERVER"
+
+const (
+	hostInterface = "veth-test"
+	bridge        = "br-test"
+	bridgeAddress = "10.9.8.1/24"
+	greeting      = "hello from the container"
+)
+
+var veth = netns.Veth{
+	HostInterface: hostInterface,
+	Bridge:        bridge,
+	Addresses:     []string{"10.9.8.2/24", "fd00:9:8::2/64"},
+	Gateways:      []string{"10.9.8.1", "fd00:9:8::1"},
+}
+
+func init() {
+	runner.Child(func(rCtx kernel.ResourceContext) []kernel.ResourceController {
+		return []kernel.ResourceController{netns.New(netlink_linux.New(), syscall_linux.NewNS())}
+	})
+	switch os.Getenv(helperEnv) {
+	case "":
+	case "runner":
+		os.Exit(report(runnerHelper()))
+	case "container":
+		os.Exit(report(containerHelper()))
+	case "loopback":
+		os.Exit(report(loopbackHelper()))
+	default:
+		os.Exit(1)
+	}
+}
+
+// report writes the given failure, if any, to standard error and returns the helper's exit status.
+func report(err error) int {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+/*
+	runnerHelper runs in a helper process in a throwaway network namespace, which stands for the host's. It
+	creates the bridge with an address, runs the container's command with a virtual ethernet pair attached to
+	the bridge, checks the host's device while the command runs, and accepts a connection from the command.
+*/
+func runnerHelper() error {
+	nl := netlink_linux.New()
+	if err := nl.AddBridge(bridge); err != nil {
+		return err
+	}
+	ip, ipNet, _ := net.ParseCIDR(bridgeAddress)
+	if err := nl.AddAddress(bridge, net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", ip.String()+":0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	executable, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{
+		Process: kernel.ProcessSpec{Env: []string{helperEnv + "=container", serverEnv + "=" + listener.Addr().String()}},
+	})
+	if err := rCtx.SetConfig(netns.ConfigKey, netns.Config{Veth: &veth}); err != nil {
+		return err
+	}
+	c, gerr := runner.BuildContainer(rCtx, []kernel.ResourceController{netns.New(nl, syscall_linux.NewNS())})
+	if gerr != nil {
+		return gerr
+	}
+	input := make(container.InputStream)
+	output, errOutput, status, err := c(executable, input)
+	if err != nil {
+		return err
+	}
+	checkErr := checkHost(nl)
+	received, acceptErr := accept(listener)
+	close(input)
+	_, errOut := drain(output, errOutput)
+	if s := <-status; s.Code != 0 {
+		return fmt.Errorf("Incorrect exit status %+v of container helper: %q", s, errOut)
+	}
+	if checkErr != nil {
+		return checkErr
+	}
+	if acceptErr != nil || received != greeting {
+		return fmt.Errorf("Received %q from the container (%v)", received, acceptErr)
+	}
+	if _, err := nl.LinkByName(hostInterface); err == nil || !err.(gerror.Gerror).EqualTag(netlink.ErrLinkNotFound) {
+		return fmt.Errorf("Host's device not deleted: %v", err)
+	}
+	return nil
+}
+
+// checkHost checks that the host's device is up and attached to the bridge, which is up.
+func checkHost(nl netlink.Netlink) error {
+	br, err := nl.LinkByName(bridge)
+	if err != nil {
+		return err
+	}
+	link, err := nl.LinkByName(hostInterface)
+	if err != nil {
+		return err
+	}
+	if !br.Up || !link.Up || link.Kind != "veth" || link.Master != br.Index {
+		return fmt.Errorf("Incorrect host's device %+v or bridge %+v", link, br)
+	}
+	if _, err := nl.LinkByName(netns.DefaultContainerInterface); err == nil {
+		return fmt.Errorf("Container's device found in the host's network namespace")
+	}
+	return nil
+}
+
+// accept accepts a connection on the given listener and returns what is received on it.
+func accept(listener net.Listener) (string, error) {
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	received, err := ioutil.ReadAll(conn)
+	return string(received), err
+}
+
+/*
+	containerHelper runs as the container's command. It checks the container's network devices, addresses,
+	and routes, sends a greeting to the runner helper's server, and waits until its input is closed.
+*/
+func containerHelper() error {
+	defer ioutil.ReadAll(os.Stdin)
+	nl := netlink_linux.New()
+	lo, err := nl.LinkByName("lo")
+	if err != nil || !lo.Up {
+		return fmt.Errorf("Loopback device not up (%+v, %v)", lo, err)
+	}
+	link, err := nl.LinkByName(netns.DefaultContainerInterface)
+	if err != nil || !link.Up || link.Kind != "veth" {
+		return fmt.Errorf("Container's device not up (%+v, %v)", link, err)
+	}
+	addrs, err := nl.Addresses(netns.DefaultContainerInterface)
+	if err != nil {
+		return err
+	}
+	for _, address := range veth.Addresses {
+		if !containsAddress(addrs, address) {
+			return fmt.Errorf("Address %s not found in %v", address, addrs)
+		}
+	}
+	routes, err := nl.Routes(netns.DefaultContainerInterface)
+	if err != nil {
+		return err
+	}
+	for _, gateway := range veth.Gateways {
+		if !containsDefaultRoute(routes, gateway) {
+			return fmt.Errorf("Default route via %s not found in %v", gateway, routes)
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", os.Getenv(serverEnv), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(greeting))
+	return err
+}
+
+// loopbackHelper runs as the command of a container without a virtual ethernet pair and checks its network devices.
+func loopbackHelper() error {
+	nl := netlink_linux.New()
+	if lo, err := nl.LinkByName("lo"); err != nil || !lo.Up {
+		return fmt.Errorf("Loopback device not up (%+v, %v)", lo, err)
+	}
+	if _, err := nl.LinkByName(netns.DefaultContainerInterface); err == nil {
+		return fmt.Errorf("Unexpected network device %q", netns.DefaultContainerInterface)
+	}
+	return nil
+}
+
+func TestContainerNetwork(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Error("Network namespaces require root privileges - run the test as root")
+		panic("Test aborted, must be run as root")
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), helperEnv+"=runner")
+	cmd.SysProcAttr = &trueSyscall.SysProcAttr{Cloneflags: trueSyscall.CLONE_NEWNET}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Runner helper failed with %s: %s", err, out)
+	}
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	executable, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{
+		Process: kernel.ProcessSpec{Env: []string{helperEnv + "=loopback"}},
+	})
+	c, gerr := runner.BuildContainer(rCtx, []kernel.ResourceController{netns.New(netlink_linux.New(), syscall_linux.NewNS())})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	output, errOutput, status, err := c(executable, nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	_, errOut := drain(output, errOutput)
+	if s := <-status; s.Code != 0 {
+		t.Errorf("Incorrect exit status %+v of loopback helper: %q", s, errOut)
+	}
+}
+
+// drain reads the given output and error streams until they are closed and returns their contents.
+func drain(output container.OutputStream, errOutput container.OutputStream) (string, string) {
+	var out, errOut []string
+	for output != nil || errOutput != nil {
+		select {
+		case s, ok := <-output:
+			if !ok {
+				output = nil
+			} else {
+				out = append(out, s)
+			}
+		case s, ok := <-errOutput:
+			if !ok {
+				errOutput = nil
+			} else {
+				errOut = append(errOut, s)
+			}
+		}
+	}
+	return strings.Join(out, ""), strings.Join(errOut, "")
+}
+
+func containsAddress(addrs []net.IPNet, cidr string) bool {
+	for _, addr := range addrs {
+		if addr.String() == cidr {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDefaultRoute(routes []netlink.Route, gateway string) bool {
+	for _, route := range routes {
+		if route.Destination == nil && route.Gateway.Equal(net.ParseIP(gateway)) {
+			return true
+		}
+	}
+	return false
+}
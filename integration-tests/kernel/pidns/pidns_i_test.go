@@ -0,0 +1,139 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package pidns_test
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/container"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/pidns"
+	"github.com/cf-guardian/guardian/runner"
+	"os"
+	"os/signal"
+	"strings"
+	trueSyscall "syscall"
+	"testing"
+	"time"
+)
+
+// helperEnv is set to the name of a helper when the test binary is run as a container's command.
+const helperEnv = "GUARDIAN_TEST_PIDNS_HELPER"
+
+func init() {
+	runner.Child(func(rCtx kernel.ResourceContext) []kernel.ResourceController {
+		return []kernel.ResourceController{pidns.New()}
+	})
+	switch os.Getenv(helperEnv) {
+	case "":
+	case "pid":
+		os.Exit(pidHelper())
+	case "signal":
+		os.Exit(signalHelper())
+	case "kill":
+		trueSyscall.Kill(os.Getpid(), trueSyscall.SIGKILL)
+		os.Exit(1)
+	default:
+		os.Exit(1)
+	}
+}
+
+/*
+	pidHelper checks that it is a child of the init process of a new PID namespace. Its own process id is
+	not checked as the init process may have created other processes first.
+*/
+func pidHelper() int {
+	if ppid := os.Getppid(); ppid != 1 {
+		fmt.Fprintf(os.Stderr, "Parent process id %d\n", ppid)
+		return 1
+	}
+	return 0
+}
+
+// signalHelper sends SIGTERM to the init process and exits with status 7 once the signal has been forwarded.
+func signalHelper() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, trueSyscall.SIGTERM)
+	if err := trueSyscall.Kill(1, trueSyscall.SIGTERM); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	select {
+	case <-signals:
+		return 7
+	case <-time.After(5 * time.Second):
+		fmt.Fprintf(os.Stderr, "Signal was not forwarded\n")
+		return 1
+	}
+}
+
+func TestPIDNamespace(t *testing.T) {
+	testHelper(t, "pid", 0)
+}
+
+func TestSignalForwarding(t *testing.T) {
+	testHelper(t, "signal", 7)
+}
+
+func TestKilled(t *testing.T) {
+	testHelper(t, "kill", 128+int(trueSyscall.SIGKILL))
+}
+
+func testHelper(t *testing.T, helper string, expected int) {
+	executable, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{
+		Process: kernel.ProcessSpec{Env: []string{helperEnv + "=" + helper}},
+	})
+	c, gerr := runner.BuildContainer(rCtx, []kernel.ResourceController{pidns.New()})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	output, errOutput, status, err := c(executable, nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	_, errOut := drain(output, errOutput)
+	if s := <-status; s.Code != expected {
+		t.Errorf("Incorrect exit status %+v of %s helper: %q", s, helper, errOut)
+	}
+}
+
+// drain reads the given output and error streams until they are closed and returns their contents.
+func drain(output container.OutputStream, errOutput container.OutputStream) (string, string) {
+	var out, errOut []string
+	for output != nil || errOutput != nil {
+		select {
+		case s, ok := <-output:
+			if !ok {
+				output = nil
+			} else {
+				out = append(out, s)
+			}
+		case s, ok := <-errOutput:
+			if !ok {
+				errOutput = nil
+			} else {
+				errOut = append(errOut, s)
+			}
+		}
+	}
+	return strings.Join(out, ""), strings.Join(errOut, "")
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs_test
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/kernel/rootfs"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"github.com/cf-guardian/guardian/test_support"
+	"os"
+	"os/exec"
+	"path/filepath"
+	trueSyscall "syscall"
+	"testing"
+)
+
+// enterRootEnv is set to the root filesystem to enter when the test binary is run as a helper process.
+const enterRootEnv = "GUARDIAN_TEST_ENTER_ROOT"
+
+const enterMarker = "enter.marker"
+
+func init() {
+	if root := os.Getenv(enterRootEnv); root != "" {
+		os.Exit(enterHelper(root))
+	}
+}
+
+/*
+	enterHelper runs in a helper process in a new mount namespace. It enters the given root filesystem and
+	checks that it is the root directory.
+*/
+func enterHelper(root string) int {
+	sc, err := syscall_linux.NewFS()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if gerr := rootfs.Enter(sc, root); gerr != nil {
+		fmt.Fprintf(os.Stderr, "Enter failed: %s\n", gerr)
+		return 1
+	}
+	if _, err := os.Stat("/" + enterMarker); err != nil {
+		fmt.Fprintf(os.Stderr, "Marker not found in root directory: %s\n", err)
+		return 1
+	}
+	if _, err := os.Stat(root); err == nil {
+		fmt.Fprintf(os.Stderr, "Old root %q still visible\n", root)
+		return 1
+	}
+	if wd, err := os.Getwd(); err != nil || wd != "/" {
+		fmt.Fprintf(os.Stderr, "Incorrect working directory (%q, %v)\n", wd, err)
+		return 1
+	}
+	return 0
+}
+
+func TestEnter(t *testing.T) {
+	testEnter(t, rootfs.BindMountStrategy)
+	testEnter(t, rootfs.OverlayStrategy)
+}
+
+func testEnter(t *testing.T, strategy rootfs.Strategy) {
+	syscallFS, futils := setup(t)
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, gerr := rootfs.NewRootFSWithOptions(syscallFS, futils, tempDir, rootfs.Options{Strategy: strategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	test_support.CreateFile(prototypeDir, enterMarker)
+	root, gerr := rfs.Generate(prototypeDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), enterRootEnv+"="+root)
+	cmd.SysProcAttr = &trueSyscall.SysProcAttr{Cloneflags: trueSyscall.CLONE_NEWNS}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Helper process failed with %s: %s", err, out)
+	}
+
+	// The helper's mounts were private and so the root filesystem is unaffected.
+	if !test_support.FileExists(filepath.Join(root, enterMarker)) {
+		t.Errorf("Root filesystem %q damaged by helper process", root)
+	}
+	if gerr = rfs.Remove(root); gerr != nil {
+		t.Errorf("%s", gerr)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/cf-guardian/guardian/test_support"
 	"os"
 	"path/filepath"
+	trueSyscall "syscall"
 	"testing"
 )
 
@@ -72,7 +73,7 @@ func TestGenerateMissingRootSubdir(t *testing.T) {
 	tempDir := test_support.CreateTempDir()
 	defer test_support.CleanupDirs(t, tempDir)
 
-	rfs, gerr := rootfs.NewRootFS(syscallFS, futils, tempDir)
+	rfs, gerr := rootfs.NewRootFSWithOptions(syscallFS, futils, tempDir, rootfs.Options{Strategy: rootfs.BindMountStrategy})
 	if gerr != nil {
 		t.Errorf("%s", gerr)
 		return
@@ -81,19 +82,27 @@ func TestGenerateMissingRootSubdir(t *testing.T) {
 	os.Remove(filepath.Join(prototypeDir, `home`))
 
 	_, gerr = rfs.Generate(prototypeDir)
-	if gerr == nil || !gerr.EqualTag(rootfs.ErrRootSubdirMissing){
+	if gerr == nil || !gerr.EqualTag(rootfs.ErrRootSubdirMissing) {
 		t.Errorf("Incorrect error %s", gerr)
 		return
 	}
 }
 
-func TestGenerate(t *testing.T) {
+func TestGenerateBindMount(t *testing.T) {
+	testGenerate(t, rootfs.BindMountStrategy)
+}
+
+func TestGenerateOverlay(t *testing.T) {
+	testGenerate(t, rootfs.OverlayStrategy)
+}
+
+func testGenerate(t *testing.T, strategy rootfs.Strategy) {
 	syscallFS, futils := setup(t)
 
 	tempDir := test_support.CreateTempDir()
 	defer test_support.CleanupDirs(t, tempDir)
 
-	rfs, gerr := rootfs.NewRootFS(syscallFS, futils, tempDir)
+	rfs, gerr := rootfs.NewRootFSWithOptions(syscallFS, futils, tempDir, rootfs.Options{Strategy: strategy})
 	if gerr != nil {
 		t.Errorf("%s", gerr)
 		return
@@ -112,7 +121,7 @@ func TestGenerate(t *testing.T) {
 		return
 	}
 
-	checkRootFS(root, prototypeDir, t)
+	checkRootFS(root, prototypeDir, strategy, t)
 
 	// Check home directory copied and writeable.
 	homePath := filepath.Join(root, "home", "test.home")
@@ -140,6 +149,11 @@ func TestGenerate(t *testing.T) {
 		return
 	}
 
+	if rwLayers, _ := filepath.Glob(filepath.Join(tempDir, "tmp-rootfs-*")); len(rwLayers) != 0 {
+		t.Errorf("read-write layers %v were not removed", rwLayers)
+		return
+	}
+
 	err = os.RemoveAll(tempDir)
 	if err != nil {
 		t.Errorf("Error removing test directory %s", err)
@@ -147,11 +161,176 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
-func checkRootFS(root string, prototypeDir string, t *testing.T) {
+func TestGenerateWithDirs(t *testing.T) {
+	testGenerateWithDirs(t, rootfs.BindMountStrategy)
+	testGenerateWithDirs(t, rootfs.OverlayStrategy)
+}
+
+func testGenerateWithDirs(t *testing.T, strategy rootfs.Strategy) {
+	syscallFS, futils := setup(t)
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, gerr := rootfs.NewRootFSWithOptions(syscallFS, futils, tempDir, rootfs.Options{Strategy: strategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	os.Remove(filepath.Join(prototypeDir, `home`))
+	test_support.CreateDir(prototypeDir, "opt")
+	test_support.CreateFile(filepath.Join(prototypeDir, "opt"), "test.opt")
+	test_support.CreateDir(prototypeDir, "run")
+	test_support.CreateFile(filepath.Join(prototypeDir, "run"), "test.run")
+
+	dirs := append(rootfs.DefaultDirs(),
+		rootfs.RwDir{Path: "opt", Policy: rootfs.CopyUp},
+		rootfs.RwDir{Path: "run", Policy: rootfs.EmptyTmp},
+		rootfs.RwDir{Path: "srv", Policy: rootfs.CopyUp | rootfs.SkipIfMissing})
+	for i := range dirs {
+		if dirs[i].Path == "home" {
+			dirs[i].Policy |= rootfs.SkipIfMissing
+		}
+	}
+
+	root, gerr := rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{Dirs: dirs})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	optPath := filepath.Join(root, "opt", "test.opt")
+	if err := os.Remove(optPath); err != nil {
+		t.Errorf("Failed to delete file from opt directory of root %s: %s", root, err)
+	}
+	if test_support.FileExists(filepath.Join(root, "run", "test.run")) {
+		t.Errorf("run/test.run should not exist in generated root filesystem")
+	}
+	if _, err := test_support.TestCreateFile(t, filepath.Join(root, "run"), "test.write"); err != nil {
+		t.Errorf("Failed to create file in run directory of root %s: %s", root, err)
+	}
+
+	gerr = rfs.Remove(root)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+	}
+}
+
+func TestGenerateShiftIds(t *testing.T) {
+	testGenerateShiftIds(t, rootfs.BindMountStrategy)
+	testGenerateShiftIds(t, rootfs.OverlayStrategy)
+}
+
+func testGenerateShiftIds(t *testing.T, strategy rootfs.Strategy) {
+	syscallFS, futils := setup(t)
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, gerr := rootfs.NewRootFSWithOptions(syscallFS, futils, tempDir, rootfs.Options{Strategy: strategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	passwd := test_support.CreateFile(filepath.Join(prototypeDir, "etc"), "passwd")
+	if err := os.Chown(passwd, 7, 8); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	root, gerr := rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{
+		Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+		Gids: []syscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 65536}},
+	})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	defer func() {
+		if gerr := rfs.Remove(root); gerr != nil {
+			t.Errorf("%s", gerr)
+		}
+	}()
+
+	for path, expected := range map[string][2]uint32{
+		filepath.Join(root, "etc"):           {100000, 200000},
+		filepath.Join(root, "etc", "passwd"): {100007, 200008},
+		filepath.Join(root, "tmp"):           {100000, 200000},
+	} {
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		st := info.Sys().(*trueSyscall.Stat_t)
+		if st.Uid != expected[0] || st.Gid != expected[1] {
+			t.Errorf("%q is owned by (%d, %d) with strategy %v, expected %v", path, st.Uid, st.Gid, strategy, expected)
+		}
+	}
+	if info, err := os.Lstat(passwd); err != nil || info.Sys().(*trueSyscall.Stat_t).Uid != 7 {
+		t.Errorf("Owner of the prototype's file was changed (%v)", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	syscallFS, futils := setup(t)
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, gerr := rootfs.NewRootFS(syscallFS, futils, tempDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	root, gerr := rfs.Generate(prototypeDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	// Simulate a restart which left an orphaned directory behind.
+	orphan := test_support.CreateDir(tempDir, "mnt-orphan")
+	rfs, gerr = rootfs.NewRootFS(syscallFS, futils, tempDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	adopted, gerr := rfs.Recover()
+	if gerr != nil || len(adopted) != 1 || adopted[0].Root != root {
+		t.Errorf("Incorrect return values (%v, %s)", adopted, gerr)
+	}
+	if test_support.FileExists(orphan) {
+		t.Errorf("orphan %s was not removed", orphan)
+	}
+
+	gerr = rfs.Remove(root)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 0 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+	}
+}
+
+func checkRootFS(root string, prototypeDir string, strategy rootfs.Strategy, t *testing.T) {
 	_, err := test_support.TestCreateFile(t, root, "test.root")
-	if err == nil {
+	if strategy == rootfs.BindMountStrategy && err == nil {
 		t.Errorf("Created file in read-only section of root %s", root)
 	}
+	if strategy == rootfs.OverlayStrategy {
+		if err != nil {
+			t.Errorf("Failed to create file in root %s: %s", root, err)
+		}
+		if test_support.FileExists(filepath.Join(prototypeDir, "test.root")) {
+			t.Errorf("Creating a file in root %s modified the prototype", root)
+		}
+	}
 	path, err := test_support.TestCreateFile(t, filepath.Join(root, "tmp"), "test.write")
 	if err != nil {
 		t.Errorf("Failed to create file in tmp directory of root %s: %s", root, err)
@@ -0,0 +1,173 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_test
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	trueSyscall "syscall"
+	"testing"
+)
+
+func TestNamespaceId(t *testing.T) {
+	ns := syscall_linux.NewNS()
+	for _, namespace := range []syscall.Namespaces{syscall.MountNS, syscall.UTSNS, syscall.IPCNS,
+		syscall.PIDNS, syscall.NetNS, syscall.UserNS} {
+		id, err := ns.NamespaceId(os.Getpid(), namespace)
+		if err != nil || id == "" {
+			t.Errorf("NamespaceId(%s) returned (%q, %s)", namespace, id, err)
+		}
+	}
+
+	_, err := ns.NamespaceId(os.Getpid(), syscall.MountNS|syscall.UTSNS)
+	if err == nil || !err.(gerror.Gerror).EqualTag(syscall_linux.ErrNamespaceType) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestNamespaceIdOfCallingProcess(t *testing.T) {
+	ns := syscall_linux.NewNS()
+	self, err := ns.NamespaceId(0, syscall.NetNS)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if id, _ := ns.NamespaceId(os.Getpid(), syscall.NetNS); id != self {
+		t.Errorf("Namespace of calling process was %q, expected %q", self, id)
+	}
+}
+
+func TestStartInNamespaces(t *testing.T) {
+	setup(t)
+	ns := syscall_linux.NewNS()
+	cmd := exec.Command("sleep", "10")
+	err := ns.StartInNamespaces(cmd, syscall.UTSNS|syscall.IPCNS|syscall.NetNS)
+	if err != nil {
+		t.Errorf("StartInNamespaces failed: %s", err)
+		return
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	for namespace, expectSame := range map[syscall.Namespaces]bool{
+		syscall.MountNS: true,
+		syscall.UTSNS:   false,
+		syscall.IPCNS:   false,
+		syscall.NetNS:   false,
+	} {
+		parentId, _ := ns.NamespaceId(os.Getpid(), namespace)
+		childId, err := ns.NamespaceId(cmd.Process.Pid, namespace)
+		if err != nil || (parentId == childId) != expectSame {
+			t.Errorf("Child %s namespace %q (error %v), parent namespace %q", namespace, childId, err, parentId)
+		}
+	}
+}
+
+func TestUnshareAndSetns(t *testing.T) {
+	setup(t)
+	ns := syscall_linux.NewNS()
+
+	// Unshare affects only the current thread and so the thread must not be used by other goroutines.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	threadNS := fmt.Sprintf("/proc/self/task/%d/ns/uts", trueSyscall.Gettid())
+
+	orig, err := ns.OpenNS(os.Getpid(), syscall.UTSNS)
+	if err != nil {
+		t.Errorf("OpenNS failed: %s", err)
+		return
+	}
+	defer orig.Close()
+	origId, _ := os.Readlink(threadNS)
+
+	if err = ns.Unshare(syscall.UTSNS); err != nil {
+		t.Errorf("Unshare failed: %s", err)
+		return
+	}
+	if id, _ := os.Readlink(threadNS); id == origId {
+		t.Errorf("Thread still in namespace %q after Unshare", id)
+	}
+
+	if err = ns.Setns(orig, syscall.UTSNS); err != nil {
+		t.Errorf("Setns failed: %s", err)
+		// Do not return the thread to the pool in the wrong namespace.
+		runtime.Goexit()
+	}
+	if id, _ := os.Readlink(threadNS); id != origId {
+		t.Errorf("Thread in namespace %q after Setns, expected %q", id, origId)
+	}
+
+	if err = ns.Setns(orig, syscall.NetNS); err == nil {
+		t.Errorf("Setns into a namespace of the wrong type succeeded")
+	}
+}
+
+func TestSethostname(t *testing.T) {
+	setup(t)
+	ns := syscall_linux.NewNS()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, err := ns.OpenNS(os.Getpid(), syscall.UTSNS)
+	if err != nil {
+		t.Errorf("OpenNS failed: %s", err)
+		return
+	}
+	defer orig.Close()
+	origName, _ := os.Hostname()
+
+	if err = ns.Unshare(syscall.UTSNS); err != nil {
+		t.Errorf("Unshare failed: %s", err)
+		return
+	}
+	if err := ns.Sethostname("test-host"); err != nil {
+		t.Errorf("Sethostname failed: %s", err)
+	}
+	if err := ns.Setdomainname("test-domain"); err != nil {
+		t.Errorf("Setdomainname failed: %s", err)
+	}
+	// The files in /proc/sys/kernel reflect the UTS namespace of the calling thread.
+	if host, domain := readSysctl(t, "hostname"), readSysctl(t, "domainname"); host != "test-host" || domain != "test-domain" {
+		t.Errorf("Host name %q and domain name %q", host, domain)
+	}
+
+	if err = ns.Setns(orig, syscall.UTSNS); err != nil {
+		t.Errorf("Setns failed: %s", err)
+		runtime.Goexit()
+	}
+	if name, _ := os.Hostname(); name != origName {
+		t.Errorf("Host name of the original namespace changed from %q to %q", origName, name)
+	}
+}
+
+func readSysctl(t *testing.T, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join("/proc/sys/kernel", name))
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -17,13 +17,243 @@
 package syscall_test
 
 import (
+	"github.com/cf-guardian/guardian/gerror"
 	"github.com/cf-guardian/guardian/kernel/syscall"
 	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
 	"github.com/cf-guardian/guardian/test_support"
-	"testing"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	trueSyscall "syscall"
+	"testing"
+	"time"
+)
+
+// Flags reported by statfs.
+const (
+	st_rdonly = 1
+	st_noexec = 8
 )
 
+func TestMount(t *testing.T) {
+	sc := setup(t)
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", syscall.MountNoSuid|syscall.MountNoDev|syscall.MountNoExec, "size=1m,mode=700")
+	if err != nil {
+		t.Errorf("Mount failed: %s", err)
+		return
+	}
+
+	var stat trueSyscall.Statfs_t
+	if err = trueSyscall.Statfs(mountPoint, &stat); err != nil {
+		t.Errorf("Statfs failed: %s", err)
+	} else if stat.Flags&st_noexec == 0 {
+		t.Errorf("tmpfs was not mounted noexec: flags %#x", stat.Flags)
+	}
+	test_support.CreateFile(mountPoint, "test.file")
+
+	err = sc.Mount("", mountPoint, "", syscall.MountRemount|syscall.MountReadOnly, "")
+	if err != nil {
+		t.Errorf("Remount failed: %s", err)
+	}
+	if _, err = os.Create(filepath.Join(mountPoint, "another.file")); err == nil {
+		t.Errorf("Remounted tmpfs is not read-only")
+	}
+
+	err = sc.Unmount(mountPoint)
+	if err != nil {
+		t.Errorf("Unmount failed: %s", err)
+	}
+}
+
+func TestMountUnknownFlags(t *testing.T) {
+	sc := setup(t)
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", syscall.MountFlags(1<<31), "")
+	if err == nil || !err.(gerror.Gerror).EqualTag(syscall_linux.ErrUnknownMountFlags) {
+		t.Errorf("Incorrect error %s", err)
+		sc.Unmount(mountPoint)
+	}
+}
+
+func TestSetPropagation(t *testing.T) {
+	sc := setup(t)
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", 0, "size=1m")
+	if err != nil {
+		t.Errorf("Mount failed: %s", err)
+		return
+	}
+	defer sc.Unmount(mountPoint)
+
+	for _, propagation := range []syscall.Propagation{syscall.PropagationShared, syscall.PropagationPrivate,
+		syscall.PropagationUnbindable} {
+		for _, recursive := range []bool{false, true} {
+			if err = sc.SetPropagation(mountPoint, propagation, recursive); err != nil {
+				t.Errorf("SetPropagation(%s, %v) failed: %s", propagation, recursive, err)
+			}
+		}
+	}
+
+	err = sc.SetPropagation(mountPoint, syscall.Propagation(0), false)
+	if err == nil || !err.(gerror.Gerror).EqualTag(syscall_linux.ErrUnknownPropagation) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestMountTable(t *testing.T) {
+	sc := setup(t)
+	mt := syscall_linux.NewMountTable()
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+	subdir := test_support.CreateDir(mountPoint, "subdir")
+
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", syscall.MountNoSuid, "size=1m")
+	if err != nil {
+		t.Errorf("Mount failed: %s", err)
+		return
+	}
+	if isMountPoint, err := mt.IsMountPoint(mountPoint); !isMountPoint || err != nil {
+		t.Errorf("IsMountPoint returned (%v, %s)", isMountPoint, err)
+	}
+	m, err := mt.MountInfo(filepath.Join(mountPoint, "file"))
+	if err != nil || m.MountPoint != mountPoint || m.FsType != "tmpfs" || !contains(m.Options, "nosuid") {
+		t.Errorf("MountInfo returned (%+v, %s)", m, err)
+	}
+	under, err := mt.MountsUnder(mountPoint)
+	if err != nil || len(under) != 1 {
+		t.Errorf("MountsUnder returned (%+v, %s)", under, err)
+	}
+
+	err = sc.Unmount(mountPoint)
+	if err != nil {
+		t.Errorf("Unmount failed: %s", err)
+	}
+	if isMountPoint, err := mt.IsMountPoint(mountPoint); isMountPoint || err != nil {
+		t.Errorf("IsMountPoint returned (%v, %s) after Unmount", isMountPoint, err)
+	}
+	if isMountPoint, err := mt.IsMountPoint(subdir); isMountPoint || err != nil {
+		t.Errorf("IsMountPoint returned (%v, %s) for a directory", isMountPoint, err)
+	}
+}
+
+func TestUnmountBusy(t *testing.T) {
+	sc := setup(t)
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", 0, "size=1m")
+	if err != nil {
+		t.Errorf("Mount failed: %s", err)
+		return
+	}
+	file, err := os.Create(filepath.Join(mountPoint, "test.file"))
+	if err != nil {
+		t.Errorf("%s", err)
+		sc.Unmount(mountPoint)
+		return
+	}
+
+	if err = sc.Unmount(mountPoint); err != trueSyscall.EBUSY {
+		t.Errorf("Incorrect error %s unmounting a busy mount", err)
+	}
+
+	// The mount is busy for the first attempt and is then released.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		file.Close()
+	}()
+	err = sc.UnmountWithOptions(mountPoint, syscall.UnmountOptions{Retries: 10, RetryDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Errorf("UnmountWithOptions failed: %s", err)
+	}
+}
+
+func TestUnmountDetach(t *testing.T) {
+	sc := setup(t)
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", 0, "size=1m")
+	if err != nil {
+		t.Errorf("Mount failed: %s", err)
+		return
+	}
+	file, err := os.Create(filepath.Join(mountPoint, "test.file"))
+	if err != nil {
+		t.Errorf("%s", err)
+		sc.Unmount(mountPoint)
+		return
+	}
+	defer file.Close()
+
+	err = sc.UnmountWithOptions(mountPoint, syscall.UnmountOptions{Flags: syscall.UnmountDetach | syscall.UnmountNoFollow})
+	if err != nil {
+		t.Errorf("UnmountWithOptions failed: %s", err)
+	}
+	if isMountPoint, _ := syscall_linux.NewMountTable().IsMountPoint(mountPoint); isMountPoint {
+		t.Errorf("%q is still mounted", mountPoint)
+	}
+}
+
+func TestUnmountAll(t *testing.T) {
+	sc := setup(t)
+	mt := syscall_linux.NewMountTable()
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+	dir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, dir)
+
+	// Mount a tree of tmpfs filesystems and bind mounts, with one mount stacked on another.
+	err := sc.Mount("tmpfs", mountPoint, "tmpfs", 0, "size=1m")
+	if err != nil {
+		t.Errorf("Mount failed: %s", err)
+		return
+	}
+	defer sc.UnmountAll(mountPoint, syscall.UnmountOptions{Flags: syscall.UnmountDetach})
+	a := test_support.CreateDir(mountPoint, "a")
+	for _, mount := range []func() error{
+		func() error { return sc.Mount("tmpfs", a, "tmpfs", 0, "size=1m") },
+		func() error { return sc.Mount("tmpfs", a, "tmpfs", 0, "size=1m") },
+		func() error { return sc.BindMountReadWrite(dir, test_support.CreateDir(a, "b")) },
+		func() error { return sc.Mount("tmpfs", filepath.Join(a, "b"), "tmpfs", 0, "size=1m") },
+		func() error {
+			return sc.Mount("tmpfs", test_support.CreateDir(filepath.Join(a, "b"), "c"), "tmpfs", 0, "size=1m")
+		},
+	} {
+		if err = mount(); err != nil {
+			t.Errorf("Mount failed: %s", err)
+			return
+		}
+	}
+	if mounts, _ := mt.MountsUnder(mountPoint); len(mounts) != 6 {
+		t.Errorf("Found %d mounts, expected 6: %+v", len(mounts), mounts)
+	}
+
+	err = sc.UnmountAll(mountPoint, syscall.UnmountOptions{})
+	if err != nil {
+		t.Errorf("UnmountAll failed: %s", err)
+	}
+	if mounts, err := mt.MountsUnder(mountPoint); len(mounts) != 0 || err != nil {
+		t.Errorf("MountsUnder returned (%+v, %s) after UnmountAll", mounts, err)
+	}
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
 func TestBindMountReadWrite(t *testing.T) {
 	sc := setup(t)
 	dir := test_support.CreateTempDir()
@@ -48,6 +278,20 @@ func TestBindMountReadOnly(t *testing.T) {
 		t.Errorf("BindMountReadOnly failed: %s", err)
 	}
 
+	var stat trueSyscall.Statfs_t
+	if err = trueSyscall.Statfs(mountPoint, &stat); err != nil {
+		t.Errorf("Statfs failed: %s", err)
+	} else if stat.Flags&st_rdonly == 0 {
+		t.Errorf("Bind mount is not read-only: flags %#x", stat.Flags)
+	}
+	_, err = os.Create(filepath.Join(mountPoint, "test.file"))
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != trueSyscall.EROFS {
+		t.Errorf("Incorrect error %s creating file in read-only bind mount", err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("BindMountReadOnly wrote to %q", dir)
+	}
+
 	err = sc.Unmount(mountPoint)
 	if err != nil {
 		t.Errorf("Unmount failed: %s", err)
@@ -84,6 +328,58 @@ func TestBindMountOverlay(t *testing.T) {
 	}
 }
 
+func TestOverlayMount(t *testing.T) {
+	sc := setup(t)
+	supported, err := sc.FilesystemSupported("overlay")
+	if err != nil {
+		t.Errorf("FilesystemSupported failed: %s", err)
+		return
+	}
+	if !supported {
+		t.Skip("overlay filesystem not supported by the kernel")
+	}
+
+	lower := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, lower)
+	test_support.CreateFile(lower, "lower.file")
+	rwDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, rwDir)
+	upper := test_support.CreateDir(rwDir, "upper")
+	work := test_support.CreateDir(rwDir, "work")
+	mountPoint := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, mountPoint)
+
+	err = sc.OverlayMount(lower, upper, work, mountPoint)
+	if err != nil {
+		t.Errorf("OverlayMount failed: %s", err)
+		return
+	}
+
+	if !test_support.FileExists(filepath.Join(mountPoint, "lower.file")) {
+		t.Errorf("lower.file not visible in overlay")
+	}
+	test_support.CreateFile(mountPoint, "upper.file")
+	if !test_support.FileExists(filepath.Join(upper, "upper.file")) {
+		t.Errorf("upper.file not written to the upper directory")
+	}
+	if test_support.FileExists(filepath.Join(lower, "upper.file")) {
+		t.Errorf("upper.file written to the lower directory")
+	}
+
+	err = sc.Unmount(mountPoint)
+	if err != nil {
+		t.Errorf("Unmount failed: %s", err)
+	}
+}
+
+func TestFilesystemNotSupported(t *testing.T) {
+	sc := setup(t)
+	supported, err := sc.FilesystemSupported("no-such-filesystem")
+	if supported || err != nil {
+		t.Errorf("Incorrect return values (%v, %s)", supported, err)
+	}
+}
+
 func setup(t *testing.T) syscall.SyscallFS {
 	sc, err := syscall_linux.NewFS()
 	if err != nil {
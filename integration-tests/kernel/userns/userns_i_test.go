@@ -0,0 +1,291 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package userns_test
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/cgroups"
+	"github.com/cf-guardian/guardian/kernel/fileutils"
+	"github.com/cf-guardian/guardian/kernel/rootfs"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"github.com/cf-guardian/guardian/kernel/userns"
+	"github.com/cf-guardian/guardian/runner"
+	"github.com/cf-guardian/guardian/test_support"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	trueSyscall "syscall"
+	"testing"
+)
+
+// helperEnv is set to the name of a helper when the test binary is run as a helper process.
+const helperEnv = "GUARDIAN_TEST_USERNS_HELPER"
+
+// strategyEnv is set to the root filesystem strategy which the generate helper uses.
+const strategyEnv = "GUARDIAN_TEST_USERNS_STRATEGY"
+
+// notRootStatus is the exit status of the newfs helper if NewFS fails with ErrNotRoot.
+const notRootStatus = 2
+
+// cap_sys_admin is the number of the Linux capability CAP_SYS_ADMIN.
+const cap_sys_admin = 21
+
+// nobody is the host user and group id of unprivileged helper processes.
+const nobody = 65534
+
+const marker = "userns.marker"
+
+var testMapping = userns.Mapping{
+	Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+	Gids: []syscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+}
+
+func init() {
+	runner.Child(func(rCtx kernel.ResourceContext) []kernel.ResourceController {
+		return []kernel.ResourceController{userns.New(syscall_linux.NewNS()), cgroups.New()}
+	})
+	switch os.Getenv(helperEnv) {
+	case "":
+	case "newfs":
+		os.Exit(newFSHelper())
+	case "generate":
+		strategy, _ := strconv.Atoi(os.Getenv(strategyEnv))
+		os.Exit(generateHelper(os.Args[1], rootfs.Strategy(strategy)))
+	default:
+		os.Exit(1)
+	}
+}
+
+// newFSHelper creates a SyscallFS and exits with notRootStatus if this fails because the process is not privileged.
+func newFSHelper() int {
+	if _, err := syscall_linux.NewFS(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		if err.(gerror.Gerror).EqualTag(syscall_linux.ErrNotRoot) {
+			return notRootStatus
+		}
+		return 1
+	}
+	return 0
+}
+
+/*
+	generateHelper runs as an unprivileged user in user and mount namespaces of its own. It generates a root
+	filesystem from the prototype in the given directory, checks its content, and removes it.
+*/
+func generateHelper(dir string, strategy rootfs.Strategy) int {
+	sc, err := syscall_linux.NewFS()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	rfs, gerr := rootfs.NewRootFSWithOptions(sc, fileutils.New(), dir, rootfs.Options{Strategy: strategy})
+	if gerr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", gerr)
+		return 1
+	}
+	root, gerr := rfs.Generate(filepath.Join(dir, "test-prototype"))
+	if gerr != nil {
+		fmt.Fprintf(os.Stderr, "Generate failed: %s\n", gerr)
+		return 1
+	}
+	if !test_support.FileExists(filepath.Join(root, marker)) {
+		fmt.Fprintf(os.Stderr, "Marker not found in %q\n", root)
+		return 1
+	}
+	if gerr := rfs.Remove(root); gerr != nil {
+		fmt.Fprintf(os.Stderr, "Remove failed: %s\n", gerr)
+		return 1
+	}
+	return 0
+}
+
+func TestRunInUserNamespace(t *testing.T) {
+	out := runCommand(t, "cat /proc/self/uid_map")
+	if fields := strings.Fields(out); strings.Join(fields, " ") != "0 100000 65536" {
+		t.Errorf("Incorrect uid_map %q", out)
+	}
+	out = runCommand(t, "cat /proc/self/gid_map")
+	if fields := strings.Fields(out); strings.Join(fields, " ") != "0 100000 65536" {
+		t.Errorf("Incorrect gid_map %q", out)
+	}
+	// The command runs as root of the user namespace.
+	out = runCommand(t, "cat /proc/self/status")
+	if !strings.Contains(out, "Uid:\t0\t0\t0\t0\n") || !strings.Contains(out, "Gid:\t0\t0\t0\t0\n") {
+		t.Errorf("Incorrect ids in status %q", out)
+	}
+}
+
+func TestRunInCgroup(t *testing.T) {
+	// The command runs in the container's cgroup whichever order the resource controllers are listed in.
+	testRunInCgroup(t, func(ns kernel.ResourceController, cg kernel.ResourceController) []kernel.ResourceController {
+		return []kernel.ResourceController{ns, cg}
+	})
+	testRunInCgroup(t, func(ns kernel.ResourceController, cg kernel.ResourceController) []kernel.ResourceController {
+		return []kernel.ResourceController{cg, ns}
+	})
+}
+
+func testRunInCgroup(t *testing.T, order func(ns kernel.ResourceController, cg kernel.ResourceController) []kernel.ResourceController) {
+	rCtx := createContext(t)
+	if err := rCtx.SetConfig(cgroups.LimitsKey, cgroups.Limits{Pids: 32}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	rcs := order(userns.New(syscall_linux.NewNS()), cgroups.New())
+	out := runContainer(t, rCtx, rcs, "cat /proc/self/cgroup")
+	if !strings.Contains(out, "/"+cgroups.DefaultParent+"/"+rCtx.GetId()+"\n") {
+		t.Errorf("Command did not run in the container's cgroup with resource controllers %v: %q", rcs, out)
+	}
+}
+
+func TestNewFSInUserNamespace(t *testing.T) {
+	// The helper runs as a user other than root of its user namespace but holds CAP_SYS_ADMIN.
+	cmd, helperDir := helperCommand(t, "newfs")
+	defer test_support.CleanupDirs(t, helperDir)
+	cmd.SysProcAttr = &trueSyscall.SysProcAttr{
+		Cloneflags: trueSyscall.CLONE_NEWUSER,
+		UidMappings: []trueSyscall.SysProcIDMap{
+			{ContainerID: 0, HostID: nobody, Size: 1},
+			{ContainerID: 1, HostID: 100000, Size: 1},
+		},
+		GidMappings: []trueSyscall.SysProcIDMap{{ContainerID: 0, HostID: nobody, Size: 1}},
+		Credential:  &trueSyscall.Credential{Uid: 1, Gid: 0, NoSetGroups: true},
+		AmbientCaps: []uintptr{cap_sys_admin},
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Helper process failed with %s: %s", err, out)
+	}
+}
+
+func TestNewFSUnprivileged(t *testing.T) {
+	cmd, helperDir := helperCommand(t, "newfs")
+	defer test_support.CleanupDirs(t, helperDir)
+	cmd.SysProcAttr = &trueSyscall.SysProcAttr{Credential: &trueSyscall.Credential{Uid: nobody, Gid: nobody}}
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Sys().(trueSyscall.WaitStatus).ExitStatus() != notRootStatus {
+		t.Errorf("Helper process returned %v, expected exit status %d: %s", err, notRootStatus, out)
+	}
+}
+
+func TestGenerateRootless(t *testing.T) {
+	testGenerateRootless(t, rootfs.BindMountStrategy)
+	testGenerateRootless(t, rootfs.OverlayStrategy)
+}
+
+func testGenerateRootless(t *testing.T, strategy rootfs.Strategy) {
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+	if err := os.Chmod(tempDir, 0777); err != nil {
+		t.Fatalf("%s", err)
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	test_support.CreateFile(prototypeDir, marker)
+
+	// The helper is root of its user namespace, which maps only the unprivileged user nobody.
+	cmd, helperDir := helperCommand(t, "generate", tempDir)
+	defer test_support.CleanupDirs(t, helperDir)
+	cmd.Env = append(cmd.Env, strategyEnv+"="+strconv.Itoa(int(strategy)))
+	cmd.SysProcAttr = &trueSyscall.SysProcAttr{
+		Cloneflags:                 trueSyscall.CLONE_NEWUSER | trueSyscall.CLONE_NEWNS,
+		UidMappings:                []trueSyscall.SysProcIDMap{{ContainerID: 0, HostID: nobody, Size: 1}},
+		GidMappings:                []trueSyscall.SysProcIDMap{{ContainerID: 0, HostID: nobody, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Credential:                 &trueSyscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true},
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Helper process with strategy %s failed with %s: %s", strategy, err, out)
+	}
+}
+
+func runCommand(t *testing.T, command string) string {
+	return runContainer(t, createContext(t), []kernel.ResourceController{userns.New(syscall_linux.NewNS())}, command)
+}
+
+// runContainer runs the given command in a container with the given resource context and resource controllers.
+func runContainer(t *testing.T, rCtx kernel.ResourceContext, rcs []kernel.ResourceController, command string) string {
+	c, gerr := runner.BuildContainer(rCtx, rcs)
+	if gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	output, errOutput, status, err := c(command, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var out, errOut []string
+	for s := range output {
+		out = append(out, s)
+	}
+	for s := range errOutput {
+		errOut = append(errOut, s)
+	}
+	if s := <-status; s.Code != 0 {
+		t.Errorf("%q exited with status %+v: %q", command, s, strings.Join(errOut, ""))
+	}
+	return strings.Join(out, "")
+}
+
+// createContext creates a resource context with the test mapping.
+func createContext(t *testing.T) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig(userns.MappingKey, testMapping); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+/*
+	helperCommand returns a command which runs the test binary as the given helper with the given arguments,
+	together with a directory to be removed when the command has finished. The binary is copied to the
+	directory, which unprivileged users can read, since the build directory is private.
+*/
+func helperCommand(t *testing.T, helper string, args ...string) (*exec.Cmd, string) {
+	dir := test_support.CreateTempDir()
+	path := filepath.Join(dir, "helper")
+	if err := os.Chmod(dir, 0755); err != nil {
+		test_support.CleanupDirs(t, dir)
+		t.Fatalf("%s", err)
+	}
+	if err := copyFile(path, os.Args[0]); err != nil {
+		test_support.CleanupDirs(t, dir)
+		t.Fatalf("%s", err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), helperEnv+"="+helper)
+	return cmd, dir
+}
+
+func copyFile(dest string, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
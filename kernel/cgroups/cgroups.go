@@ -0,0 +1,399 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package cgroups provides a resource controller which limits the resource usage of a container by
+placing the container's process in a control group of its own.
+*/
+package cgroups
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrorId is used for error ids relating to the cgroups package.
+type ErrorId int
+
+const (
+	ErrLimits            ErrorId = iota // the limits could not be read from the resource context
+	ErrInvalidLimit                     // a limit is out of range
+	ErrCreateCgroup                     // the container's cgroup could not be created
+	ErrEnableControllers                // the cgroup controllers could not be enabled
+	ErrWriteLimit                       // a limit could not be written to the container's cgroup
+	ErrNotInitialised                   // the controller has not successfully initialised
+	ErrJoinCgroup                       // the container's process could not join the container's cgroup
+	ErrRemoveCgroup                     // the container's cgroup could not be removed
+	ErrDetect                           // the cgroup layout of the host could not be determined
+	ErrUnavailable                      // a cgroup controller is not available on the host
+	ErrReadStats                        // the resource usage of the container's cgroup could not be read
+	ErrSubscribe                        // the events of the container's cgroup could not be watched
+)
+
+const (
+	// LimitsKey is the resource context configuration key of the container's Limits.
+	LimitsKey = "cgroups.limits"
+
+	// CgroupKey is the resource context configuration key under which Init publishes the container's Cgroup.
+	CgroupKey = "cgroups.cgroup"
+)
+
+const (
+	DefaultRoot          = "/sys/fs/cgroup"       // the default mount point of the cgroup filesystem
+	DefaultParent        = "guardian"             // the default parent cgroup of containers' cgroups
+	DefaultMountInfoPath = "/proc/self/mountinfo" // the default mount table used to determine the cgroup layout
+	DefaultCgroupsPath   = "/proc/cgroups"        // the default list of version 1 cgroup subsystems
+)
+
+/*
+	DefaultControllers are the cgroup controllers used for containers' cgroups when none are specified.
+	Controllers are named as in version 2 of cgroups.
+*/
+var DefaultControllers = []string{"cpu", "io", "memory", "pids"}
+
+// A Version is a version of cgroups.
+type Version int
+
+const (
+	V1 Version = iota + 1 // a separate hierarchy for each subsystem, or for each group of subsystems
+	V2                    // a single, unified hierarchy
+)
+
+/*
+	A Cgroup identifies the cgroup of a container. A version 2 cgroup is a single directory. A version 1
+	cgroup is a directory in the hierarchy of each subsystem.
+*/
+type Cgroup struct {
+	Version Version           // the version of cgroups
+	Path    string            // the directory of a version 2 cgroup
+	Paths   map[string]string // the directories of a version 1 cgroup keyed by subsystem, such as "memory"
+}
+
+// Dirs returns the distinct directories of the cgroup, in sorted order.
+func (cg Cgroup) Dirs() []string {
+	if cg.Version == V2 {
+		return []string{cg.Path}
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, dir := range cg.Paths {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+const (
+	cgroupDirMode  os.FileMode = 0755
+	cgroupFileMode os.FileMode = 0644
+)
+
+// removeRetries is the number of times removing a cgroup is retried while processes are leaving it.
+const removeRetries = 5
+
+/*
+	Limits are the resource limits of a container. A zero value means that the corresponding resource
+	is not limited.
+*/
+type Limits struct {
+	Memory     uint64    // the maximum memory usage in bytes (memory.max)
+	MemorySwap uint64    // the maximum swap usage in bytes (memory.swap.max)
+	CPUQuota   uint64    // the CPU time in microseconds the container may use in each period (cpu.max)
+	CPUPeriod  uint64    // the period of CPUQuota in microseconds, or zero for DefaultCPUPeriod (cpu.max)
+	CPUWeight  uint64    // the relative CPU weight, from 1 to 10000 (cpu.weight)
+	Pids       uint64    // the maximum number of processes (pids.max)
+	IO         []IOLimit // the IO limits of block devices (io.max)
+}
+
+// DefaultCPUPeriod is the period in microseconds of Limits.CPUQuota when Limits.CPUPeriod is zero.
+const DefaultCPUPeriod = 100000
+
+/*
+	An IOLimit limits the IO of a container to a block device. A zero value means that the corresponding
+	rate is not limited.
+*/
+type IOLimit struct {
+	Major     uint32 // the major device number
+	Minor     uint32 // the minor device number
+	ReadBps   uint64 // the maximum bytes read per second
+	WriteBps  uint64 // the maximum bytes written per second
+	ReadIOps  uint64 // the maximum read operations per second
+	WriteIOps uint64 // the maximum write operations per second
+}
+
+// Options modify the behaviour of a Controller. The zero value gives the default behaviour.
+type Options struct {
+	// Root is the mount point of the cgroup filesystem. If empty, DefaultRoot is used.
+	Root string
+
+	// Parent is the path, relative to Root, of the parent cgroup of containers' cgroups. If empty, DefaultParent is used.
+	Parent string
+
+	// Controllers are the cgroup controllers to use. If nil, DefaultControllers is used.
+	Controllers []string
+
+	// Version is the version of cgroups to use. If zero, the version is determined from the host's cgroup layout.
+	Version Version
+
+	// MountInfoPath is the mount table used to determine the cgroup layout. If empty, DefaultMountInfoPath is used.
+	MountInfoPath string
+
+	// CgroupsPath is the list of version 1 cgroup subsystems. If empty, DefaultCgroupsPath is used.
+	CgroupsPath string
+}
+
+// A backend creates cgroups for a particular version of cgroups.
+type backend interface {
+	// create creates the cgroup with the given name, applies the given limits to it, and returns it.
+	create(name string, limits Limits) (Cgroup, gerror.Gerror)
+}
+
+/*
+	A Controller is a resource controller which creates a cgroup for a container, applies the Limits of the
+	resource context to the cgroup, moves the container's process into the cgroup, and removes the cgroup
+	when the container is torn down. The cgroup is named after the container's handle.
+
+	Unless a version of cgroups is specified, the host's cgroup layout is examined. Version 2 of cgroups
+	is used if a version 2 cgroup filesystem is mounted at the root. Otherwise version 1 is used, including
+	on hosts with a hybrid layout, and the hierarchy of each subsystem is found from the mount table.
+
+	A Controller serves a single container.
+*/
+type Controller struct {
+	opts    Options
+	mu      sync.Mutex // guards cgroup and watches
+	cgroup  Cgroup
+	watches []*watch
+}
+
+var (
+	_ kernel.ResourceDependent = &Controller{}
+	_ kernel.ResourceStarter   = &Controller{}
+	_ kernel.ResourceReleaser  = &Controller{}
+	_ kernel.ResourceReporter  = &Controller{}
+	_ kernel.ResourceNotifier  = &Controller{}
+)
+
+// Creates a new Controller which uses the default cgroup filesystem and parent cgroup.
+func New() *Controller {
+	return NewWithOptions(Options{})
+}
+
+// Creates a new Controller as for New but with the given options.
+func NewWithOptions(opts Options) *Controller {
+	if opts.Root == "" {
+		opts.Root = DefaultRoot
+	}
+	if opts.Parent == "" {
+		opts.Parent = DefaultParent
+	}
+	if opts.Controllers == nil {
+		opts.Controllers = DefaultControllers
+	}
+	if opts.MountInfoPath == "" {
+		opts.MountInfoPath = DefaultMountInfoPath
+	}
+	if opts.CgroupsPath == "" {
+		opts.CgroupsPath = DefaultCgroupsPath
+	}
+	return &Controller{opts: opts}
+}
+
+// Provides returns the capabilities provided by a Controller, namely a cgroup.
+func (c *Controller) Provides() []kernel.Capability {
+	return []kernel.Capability{kernel.CgroupCapability}
+}
+
+// Requires returns nil as a Controller does not require any capabilities.
+func (c *Controller) Requires() []kernel.Capability {
+	return nil
+}
+
+// Follows returns nil as a Controller does not follow any capabilities.
+func (c *Controller) Follows() []kernel.Capability {
+	return nil
+}
+
+/*
+	Init creates the container's cgroup and applies the limits of the resource context to it. The cgroup is
+	published in the resource context.
+*/
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	var limits Limits
+	if _, err := rCtx.GetConfig(LimitsKey, &limits); err != nil {
+		return gerror.NewFromError(ErrLimits, err)
+	}
+	if gerr := validate(limits); gerr != nil {
+		return gerr
+	}
+
+	b, gerr := c.backend()
+	if gerr != nil {
+		return gerr
+	}
+	if glog.V(1) {
+		glog.Infof("Init: creating cgroup %q with limits %+v", rCtx.GetId(), limits)
+	}
+	cgroup, gerr := b.create(rCtx.GetId(), limits)
+	if gerr != nil {
+		return gerr
+	}
+	c.mu.Lock()
+	c.cgroup = cgroup
+	c.mu.Unlock()
+	if err := rCtx.SetConfig(CgroupKey, cgroup); err != nil {
+		c.Teardown(rCtx)
+		return gerror.NewFromError(ErrCreateCgroup, err)
+	}
+	return nil
+}
+
+// backend returns the backend for the configured or detected version of cgroups.
+func (c *Controller) backend() (backend, gerror.Gerror) {
+	version := c.opts.Version
+	var mounts map[string]string
+	if version == 0 || version == V1 {
+		var gerr gerror.Gerror
+		version, mounts, gerr = detect(c.opts.Root, c.opts.MountInfoPath, c.opts.CgroupsPath)
+		if gerr != nil {
+			return nil, gerr
+		}
+		if c.opts.Version == V1 && version != V1 {
+			return nil, gerror.New(ErrDetect, "Version 1 cgroups are not mounted")
+		}
+	}
+	if version == V2 {
+		return &v2{root: c.opts.Root, parent: c.opts.Parent, controllers: c.opts.Controllers}, nil
+	}
+	return newV1(mounts, c.opts.Parent, c.opts.Controllers)
+}
+
+// Cgroup returns the container's cgroup. The cgroup has a zero Version if Init has not succeeded.
+func (c *Controller) Cgroup() Cgroup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cgroup
+}
+
+/*
+	Started moves the container's process, which has the given process id, into the cgroup published in the
+	resource context by Init. The runner writes the process id before the process sets itself up, so the
+	process joins the cgroup before other resource controllers change its root directory or credentials.
+*/
+func (c *Controller) Started(rCtx kernel.ResourceContext, pid int) error {
+	var cgroup Cgroup
+	if ok, err := rCtx.GetConfig(CgroupKey, &cgroup); err != nil || !ok || cgroup.Version == 0 {
+		return gerror.New(ErrNotInitialised, "Cgroup has not been created")
+	}
+	for _, dir := range cgroup.Dirs() {
+		if glog.V(1) {
+			glog.Infof("Started: moving process %d into cgroup %q", pid, dir)
+		}
+		if err := writeFile(dir, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			return gerror.NewFromError(ErrJoinCgroup, err)
+		}
+	}
+	return nil
+}
+
+/*
+	Teardown closes the channels returned by Subscribe and removes the container's cgroup. Since processes
+	may still be leaving the cgroup, removal is retried for a short time if the cgroup is busy.
+*/
+func (c *Controller) Teardown(rCtx kernel.ResourceContext) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cgroup.Version == 0 {
+		return nil
+	}
+	c.stopWatches()
+	for _, dir := range c.cgroup.Dirs() {
+		if glog.V(1) {
+			glog.Infof("Teardown: removing cgroup %q", dir)
+		}
+		if gerr := removeCgroup(dir); gerr != nil {
+			return gerr
+		}
+	}
+	c.cgroup = Cgroup{}
+	return nil
+}
+
+// removeCgroup removes the given cgroup directory, retrying while it is busy.
+func removeCgroup(dir string) gerror.Gerror {
+	delay := 10 * time.Millisecond
+	for i := 0; ; i++ {
+		err := syscall.Rmdir(dir)
+		if err == nil || err == syscall.ENOENT {
+			return nil
+		}
+		if err != syscall.EBUSY || i == removeRetries {
+			return gerror.NewFromError(ErrRemoveCgroup, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// validate checks that the given limits are in range.
+func validate(limits Limits) gerror.Gerror {
+	if limits.CPUWeight > 10000 {
+		return gerror.Newf(ErrInvalidLimit, "CPU weight %d is greater than 10000", limits.CPUWeight)
+	}
+	if limits.CPUQuota != 0 && limits.CPUQuota < 1000 {
+		return gerror.Newf(ErrInvalidLimit, "CPU quota %d is less than 1000 microseconds", limits.CPUQuota)
+	}
+	if limits.CPUPeriod != 0 && (limits.CPUPeriod < 1000 || limits.CPUPeriod > 1000000) {
+		return gerror.Newf(ErrInvalidLimit, "CPU period %d is not between 1000 and 1000000 microseconds", limits.CPUPeriod)
+	}
+	return nil
+}
+
+/*
+	writeFile writes the given value to the given file of the given cgroup. The file is created and truncated
+	if necessary so that a plain directory may stand in for a cgroup.
+*/
+func writeFile(cgroup string, file string, value string) error {
+	return write(cgroup, file, value, os.O_TRUNC)
+}
+
+// appendFile writes the given value as a line at the end of the given file of the given cgroup.
+func appendFile(cgroup string, file string, value string) error {
+	return write(cgroup, file, value+"\n", os.O_APPEND)
+}
+
+func write(cgroup string, file string, value string, flag int) error {
+	f, err := os.OpenFile(filepath.Join(cgroup, file), os.O_WRONLY|os.O_CREATE|flag, cgroupFileMode)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,245 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups_test
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/cgroups"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+
+	rCtx := createContext(t, cgroups.Limits{
+		Memory:     1 << 30,
+		MemorySwap: 1 << 20,
+		CPUQuota:   50000,
+		CPUWeight:  200,
+		Pids:       64,
+		IO: []cgroups.IOLimit{
+			{Major: 8, Minor: 0, ReadBps: 1048576, WriteIOps: 100},
+			{Major: 8, Minor: 16},
+			{Major: 253, Minor: 1, WriteBps: 4096},
+		},
+	})
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	cgroup := filepath.Join(root, cgroups.DefaultParent, "test-id")
+	expected := cgroups.Cgroup{Version: cgroups.V2, Path: cgroup}
+	if cg := c.Cgroup(); !reflect.DeepEqual(cg, expected) {
+		t.Errorf("Incorrect cgroup %+v", cg)
+	}
+	var published cgroups.Cgroup
+	if ok, err := rCtx.GetConfig(cgroups.CgroupKey, &published); !ok || err != nil || !reflect.DeepEqual(published, expected) {
+		t.Errorf("Incorrect published cgroup %+v (%v, %v)", published, ok, err)
+	}
+	checkFile(t, root, "cgroup.subtree_control", "+cpu +io +memory +pids")
+	checkFile(t, filepath.Join(root, cgroups.DefaultParent), "cgroup.subtree_control", "+cpu +io +memory +pids")
+	checkFile(t, cgroup, "memory.max", "1073741824")
+	checkFile(t, cgroup, "memory.swap.max", "1048576")
+	checkFile(t, cgroup, "cpu.max", "50000 100000")
+	checkFile(t, cgroup, "cpu.weight", "200")
+	checkFile(t, cgroup, "pids.max", "64")
+	checkFile(t, cgroup, "io.max", "8:0 rbps=1048576 wiops=100\n253:1 wbps=4096\n")
+}
+
+func TestInitNoLimits(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root, Parent: "a/b", Controllers: []string{"pids"}})
+	if err := c.Init(createContext(t, cgroups.Limits{})); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	checkFile(t, root, "cgroup.subtree_control", "+pids")
+	checkFile(t, filepath.Join(root, "a"), "cgroup.subtree_control", "+pids")
+	checkFile(t, filepath.Join(root, "a", "b"), "cgroup.subtree_control", "+pids")
+	files, err := ioutil.ReadDir(c.Cgroup().Path)
+	if err != nil || len(files) != 0 {
+		t.Errorf("Unexpected files %v (%v) in cgroup", files, err)
+	}
+}
+
+func TestInitCPUPeriod(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	if err := c.Init(createContext(t, cgroups.Limits{CPUQuota: 20000, CPUPeriod: 10000})); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	checkFile(t, c.Cgroup().Path, "cpu.max", "20000 10000")
+}
+
+func TestInitInvalidLimit(t *testing.T) {
+	for _, limits := range []cgroups.Limits{{CPUWeight: 10001}, {CPUQuota: 999}, {CPUQuota: 1000, CPUPeriod: 1000001}} {
+		root := test_support.CreateTempDir()
+		defer test_support.CleanupDirs(t, root)
+
+		c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+		err := c.Init(createContext(t, limits))
+		if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrInvalidLimit) {
+			t.Errorf("Incorrect error %v for limits %+v", err, limits)
+		}
+		if test_support.FileExists(filepath.Join(root, cgroups.DefaultParent)) {
+			t.Errorf("Cgroup created for limits %+v", limits)
+		}
+	}
+}
+
+func TestInitInvalidLimitsConfig(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	rCtx.SetConfig(cgroups.LimitsKey, "not limits")
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: "/no-such-dir"})
+	err := c.Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrLimits) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitCgroupExists(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	os.MkdirAll(filepath.Join(root, cgroups.DefaultParent, "test-id"), 0755)
+
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	err := c.Init(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrCreateCgroup) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	if cg := c.Cgroup(); cg.Version != 0 {
+		t.Errorf("Incorrect cgroup %+v", cg)
+	}
+}
+
+func TestInitEnableControllersFailure(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	test_support.CreateDir(root, "cgroup.subtree_control")
+
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	err := c.Init(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrEnableControllers) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestStarted(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+
+	rCtx := createContext(t, cgroups.Limits{})
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if err := c.Started(rCtx, os.Getpid()); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	checkFile(t, c.Cgroup().Path, "cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+func TestStartedNotInitialised(t *testing.T) {
+	err := cgroups.New().Started(createContext(t, cgroups.Limits{}), os.Getpid())
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrNotInitialised) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+
+	rCtx := createContext(t, cgroups.Limits{Pids: 10})
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	cgroup := c.Cgroup().Path
+
+	// The cgroup filesystem removes a cgroup's files along with the cgroup.
+	if err := os.Remove(filepath.Join(cgroup, "pids.max")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if test_support.FileExists(cgroup) {
+		t.Errorf("Cgroup %q was not removed", cgroup)
+	}
+	if cg := c.Cgroup(); cg.Version != 0 {
+		t.Errorf("Incorrect cgroup %+v after Teardown", cg)
+	}
+	// A second teardown does nothing.
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownFailure(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+
+	rCtx := createContext(t, cgroups.Limits{Pids: 10})
+	c := cgroups.NewWithOptions(cgroups.Options{Version: cgroups.V2, Root: root})
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	err := c.Teardown(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrRemoveCgroup) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func createContext(t *testing.T, limits cgroups.Limits) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{Id: "test-id"})
+	if err := rCtx.SetConfig(cgroups.LimitsKey, limits); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func checkFile(t *testing.T, dir string, name string, expected string) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if string(content) != expected {
+		t.Errorf("%s contained %q, expected %q", filepath.Join(dir, name), content, expected)
+	}
+}
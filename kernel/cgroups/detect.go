@@ -0,0 +1,88 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"bufio"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	detect determines the cgroup layout of the host from the given mount table and list of version 1
+	subsystems. If a version 2 cgroup filesystem is mounted at the given root, V2 is returned. Otherwise V1
+	is returned together with the mount point of the hierarchy of each enabled version 1 subsystem.
+*/
+func detect(root string, mountInfoPath string, cgroupsPath string) (Version, map[string]string, gerror.Gerror) {
+	mounts, err := syscall_linux.NewMountTableFromFile(mountInfoPath).Mounts()
+	if err != nil {
+		return 0, nil, gerror.NewFromError(ErrDetect, err)
+	}
+	root = filepath.Clean(root)
+	for _, m := range mounts {
+		if m.FsType == "cgroup2" && filepath.Clean(m.MountPoint) == root {
+			return V2, nil, nil
+		}
+	}
+
+	enabled, gerr := enabledSubsystems(cgroupsPath)
+	if gerr != nil {
+		return 0, nil, gerr
+	}
+	hierarchies := make(map[string]string)
+	for _, m := range mounts {
+		if m.FsType != "cgroup" {
+			continue
+		}
+		for _, option := range m.SuperOptions {
+			if _, ok := hierarchies[option]; enabled[option] && !ok {
+				hierarchies[option] = m.MountPoint
+			}
+		}
+	}
+	if len(hierarchies) == 0 {
+		return 0, nil, gerror.Newf(ErrDetect, "No cgroup filesystem is mounted at or below %q", root)
+	}
+	return V1, hierarchies, nil
+}
+
+// enabledSubsystems returns the set of enabled version 1 subsystems listed in the given file, such as /proc/cgroups.
+func enabledSubsystems(cgroupsPath string) (map[string]bool, gerror.Gerror) {
+	f, err := os.Open(cgroupsPath)
+	if err != nil {
+		return nil, gerror.NewFromError(ErrDetect, err)
+	}
+	defer f.Close()
+
+	enabled := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line other than the heading is: subsys_name hierarchy num_cgroups enabled
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		enabled[fields[0]] = fields[3] == "1"
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, gerror.NewFromError(ErrDetect, err)
+	}
+	return enabled, nil
+}
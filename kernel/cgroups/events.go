@@ -0,0 +1,261 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// eventTypes are the types of the events reported by a Controller, in the order in which they are sent.
+var eventTypes = []kernel.EventType{kernel.OOMEvent, kernel.OOMKillEvent, kernel.PidsLimitEvent}
+
+/*
+	Subscribe implements kernel.ResourceNotifier and reports OOM events, OOM kills, and failures to create
+	processes because of the pids limit. On a version 2 hierarchy, memory.events and pids.events are watched.
+	On a version 1 hierarchy, OOM events are received by registering an eventfd for memory.oom_control and
+	pids.events is watched.
+*/
+func (c *Controller) Subscribe(rCtx kernel.ResourceContext) (<-chan kernel.ResourceEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cgroup.Version == 0 {
+		return nil, gerror.New(ErrNotInitialised, "Cgroup has not been created")
+	}
+	w, gerr := newWatch(c.cgroup)
+	if gerr != nil {
+		return nil, gerr
+	}
+	c.watches = append(c.watches, w)
+	return w.events, nil
+}
+
+// stopWatches stops the watches of the controller's cgroup, closing their channels. The caller must hold c.mu.
+func (c *Controller) stopWatches() {
+	for _, w := range c.watches {
+		w.close()
+	}
+	c.watches = nil
+}
+
+// A notifier is a file descriptor which becomes readable when the event counts of a cgroup may have changed.
+type notifier struct {
+	file    *os.File
+	eventfd bool // the notifier is an eventfd registered for OOM notifications
+}
+
+/*
+	A watch sends the events of a cgroup to a subscriber. It is woken by its notifiers and compares the
+	cgroup's event counts with those it last saw.
+*/
+type watch struct {
+	cgroup    Cgroup
+	events    chan kernel.ResourceEvent
+	notifiers []notifier
+	changed   chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	counts    map[kernel.EventType]uint64
+	oom       uint64 // the number of OOM notifications received on an eventfd, accessed atomically
+}
+
+// newWatch starts watching the given cgroup. Only events which occur after newWatch returns are sent.
+func newWatch(cgroup Cgroup) (*watch, gerror.Gerror) {
+	w := &watch{
+		cgroup:  cgroup,
+		events:  make(chan kernel.ResourceEvent, len(eventTypes)),
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	var gerr gerror.Gerror
+	switch cgroup.Version {
+	case V2:
+		gerr = w.watchFiles(filepath.Join(cgroup.Path, "memory.events"), filepath.Join(cgroup.Path, "pids.events"))
+	case V1:
+		if memory, ok := cgroup.Paths["memory"]; ok {
+			gerr = w.watchOOM(memory)
+		}
+		if pids, ok := cgroup.Paths["pids"]; ok && gerr == nil {
+			gerr = w.watchFiles(filepath.Join(pids, "pids.events"))
+		}
+	}
+	if gerr != nil {
+		w.closeNotifiers()
+		return nil, gerr
+	}
+	counts, err := w.readCounts()
+	if err != nil {
+		w.closeNotifiers()
+		return nil, gerror.NewFromError(ErrSubscribe, err)
+	}
+	w.counts = counts
+	for _, n := range w.notifiers {
+		go w.listen(n)
+	}
+	go w.run()
+	return w, nil
+}
+
+// watchFiles adds a notifier which is readable when any of the given files is modified. Missing files are ignored.
+func (w *watch) watchFiles(files ...string) gerror.Gerror {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return gerror.NewFromError(ErrSubscribe, err)
+	}
+	watched := false
+	for _, file := range files {
+		if _, err := syscall.InotifyAddWatch(fd, file, syscall.IN_MODIFY); err == nil {
+			watched = true
+		} else if err != syscall.ENOENT {
+			syscall.Close(fd)
+			return gerror.NewFromError(ErrSubscribe, err)
+		}
+	}
+	if !watched {
+		syscall.Close(fd)
+		return nil
+	}
+	w.notifiers = append(w.notifiers, notifier{os.NewFile(uintptr(fd), "inotify"), false})
+	return nil
+}
+
+// watchOOM adds an eventfd notifier which the given version 1 memory cgroup signals on each OOM event.
+func (w *watch) watchOOM(dir string) gerror.Gerror {
+	control, err := os.Open(filepath.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return gerror.NewFromError(ErrSubscribe, err)
+	}
+	defer control.Close()
+	fd, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+	if errno != 0 {
+		return gerror.NewFromError(ErrSubscribe, errno)
+	}
+	eventfd := os.NewFile(fd, "eventfd")
+	registration := fmt.Sprintf("%d %d", fd, control.Fd())
+	if err := writeFile(dir, "cgroup.event_control", registration); err != nil {
+		eventfd.Close()
+		return gerror.NewFromError(ErrSubscribe, err)
+	}
+	w.notifiers = append(w.notifiers, notifier{eventfd, true})
+	return nil
+}
+
+// listen wakes the watch whenever the given notifier is read, until the notifier is closed.
+func (w *watch) listen(n notifier) {
+	buf := make([]byte, 4096)
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		if n.eventfd && count == 8 {
+			atomic.AddUint64(&w.oom, *(*uint64)(unsafe.Pointer(&buf[0])))
+		}
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run sends events until the watch is closed, when it checks for events a final time and closes the channel.
+func (w *watch) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.changed:
+			w.update()
+		case <-w.stop:
+			w.update()
+			close(w.events)
+			return
+		}
+	}
+}
+
+/*
+	update sends an event for each type whose count has increased. Counts never decrease, so a count which
+	appears to, for example because a file is being removed, is ignored.
+*/
+func (w *watch) update() {
+	counts, err := w.readCounts()
+	if err != nil {
+		glog.Warningf("Failed to read the event counts of cgroup %+v: %s", w.cgroup, err)
+		return
+	}
+	now := time.Now()
+	for _, t := range eventTypes {
+		if counts[t] > w.counts[t] {
+			w.events <- kernel.ResourceEvent{Type: t, Count: counts[t] - w.counts[t], Time: now}
+			w.counts[t] = counts[t]
+		}
+	}
+}
+
+// readCounts returns the number of events of each type which have occurred in the cgroup.
+func (w *watch) readCounts() (map[kernel.EventType]uint64, error) {
+	counts := make(map[kernel.EventType]uint64)
+	var memory, pids map[string]uint64
+	var err error
+	switch w.cgroup.Version {
+	case V2:
+		if memory, err = readFlatKeyed(w.cgroup.Path, "memory.events"); err != nil {
+			return nil, err
+		}
+		counts[kernel.OOMEvent] = memory["oom"]
+		if pids, err = readFlatKeyed(w.cgroup.Path, "pids.events"); err != nil {
+			return nil, err
+		}
+	case V1:
+		if dir, ok := w.cgroup.Paths["memory"]; ok {
+			if memory, err = readFlatKeyed(dir, "memory.oom_control"); err != nil {
+				return nil, err
+			}
+		}
+		counts[kernel.OOMEvent] = atomic.LoadUint64(&w.oom)
+		if dir, ok := w.cgroup.Paths["pids"]; ok {
+			if pids, err = readFlatKeyed(dir, "pids.events"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	counts[kernel.OOMKillEvent] = memory["oom_kill"]
+	counts[kernel.PidsLimitEvent] = pids["max"]
+	return counts, nil
+}
+
+// close stops the watch after a final check for events and closes its notifiers.
+func (w *watch) close() {
+	close(w.stop)
+	<-w.done
+	w.closeNotifiers()
+}
+
+func (w *watch) closeNotifiers() {
+	for _, n := range w.notifiers {
+		n.file.Close()
+	}
+}
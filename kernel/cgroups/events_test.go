@@ -0,0 +1,163 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups_test
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/cgroups"
+	"github.com/cf-guardian/guardian/test_support"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscribeV2(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	c := cgroups.NewWithOptions(cgroups.Options{Root: root, Version: cgroups.V2})
+	rCtx := createContext(t, cgroups.Limits{})
+	if err := c.Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	cgroup := c.Cgroup().Path
+	writeFixtures(t, cgroup, map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+		"pids.events":   "max 0\n",
+	})
+
+	events, err := c.Subscribe(rCtx)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	writeFixtures(t, cgroup, map[string]string{"memory.events": "low 0\nhigh 0\nmax 4\noom 1\noom_kill 1\n"})
+	checkEvent(t, events, kernel.OOMEvent, 1)
+	checkEvent(t, events, kernel.OOMKillEvent, 1)
+	writeFixtures(t, cgroup, map[string]string{"pids.events": "max 3\n"})
+	checkEvent(t, events, kernel.PidsLimitEvent, 3)
+
+	// Events which occur just before teardown are sent before the channel is closed.
+	writeFixtures(t, cgroup, map[string]string{"memory.events": "low 0\nhigh 0\nmax 4\noom 1\noom_kill 2\n"})
+	teardown(t, c, rCtx, cgroup)
+	checkEvent(t, events, kernel.OOMKillEvent, 1)
+	checkClosed(t, events)
+}
+
+func TestSubscribeV1(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+	opts.Controllers = []string{"memory", "pids"}
+	c := cgroups.NewWithOptions(opts)
+	rCtx := createContext(t, cgroups.Limits{})
+	if err := c.Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	paths := c.Cgroup().Paths
+	writeFixtures(t, paths["memory"], map[string]string{"memory.oom_control": "oom_kill_disable 0\nunder_oom 0\noom_kill 0\n"})
+	writeFixtures(t, paths["pids"], map[string]string{"pids.events": "max 0\n"})
+
+	events, err := c.Subscribe(rCtx)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if !test_support.FileExists(filepath.Join(paths["memory"], "cgroup.event_control")) {
+		t.Errorf("OOM notifications were not registered")
+	}
+	writeFixtures(t, paths["pids"], map[string]string{"pids.events": "max 1\n"})
+	checkEvent(t, events, kernel.PidsLimitEvent, 1)
+
+	writeFixtures(t, paths["memory"], map[string]string{"memory.oom_control": "oom_kill_disable 0\nunder_oom 1\noom_kill 1\n"})
+	teardown(t, c, rCtx, paths["memory"], paths["pids"])
+	checkEvent(t, events, kernel.OOMKillEvent, 1)
+	checkClosed(t, events)
+}
+
+func TestSubscribeNotInitialised(t *testing.T) {
+	_, err := cgroups.New().Subscribe(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrNotInitialised) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestSubscribeFailure(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+	opts.Controllers = []string{"memory"}
+	c := cgroups.NewWithOptions(opts)
+	rCtx := createContext(t, cgroups.Limits{})
+	if err := c.Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// memory.oom_control is missing.
+	_, err := c.Subscribe(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrSubscribe) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+/*
+	teardown tears down the controller. The first attempt fails as the given cgroup directories contain
+	files, but it stops the controller's watches while the files are still readable. The files are then
+	removed, as the cgroup filesystem would remove them, and teardown succeeds.
+*/
+func teardown(t *testing.T, c *cgroups.Controller, rCtx kernel.ResourceContext, dirs ...string) {
+	if err := c.Teardown(rCtx); err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrRemoveCgroup) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		for _, file := range files {
+			if err := os.Remove(file); err != nil {
+				t.Fatalf("%s", err)
+			}
+		}
+	}
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func checkEvent(t *testing.T, events <-chan kernel.ResourceEvent, eventType kernel.EventType, count uint64) {
+	select {
+	case e, ok := <-events:
+		if !ok || e.Type != eventType || e.Count != count || e.Time.IsZero() {
+			t.Errorf("Incorrect event %+v (%v), expected %d of %s", e, ok, count, eventType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for %s event", eventType)
+	}
+}
+
+func checkClosed(t *testing.T, events <-chan kernel.ResourceEvent) {
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for the channel to be closed")
+	}
+}
@@ -0,0 +1,308 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"bufio"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The names of the statistics reported by a Controller.
+const (
+	StatMemoryCurrent       = "memory.current"     // memory usage in bytes
+	StatMemoryPeak          = "memory.peak"        // peak memory usage in bytes
+	StatOOMKills            = "memory.oom_kill"    // number of processes killed by the OOM killer
+	StatCPUUsage            = "cpu.usage_usec"     // total CPU time in microseconds
+	StatCPUUser             = "cpu.user_usec"      // user CPU time in microseconds
+	StatCPUSystem           = "cpu.system_usec"    // system CPU time in microseconds
+	StatCPUPeriods          = "cpu.nr_periods"     // number of CPU quota periods which have elapsed
+	StatCPUThrottledPeriods = "cpu.nr_throttled"   // number of CPU quota periods in which the cgroup was throttled
+	StatCPUThrottledTime    = "cpu.throttled_usec" // total time in microseconds for which the cgroup was throttled
+	StatPidsCurrent         = "pids.current"       // number of processes
+	StatIOReadBytes         = "io.rbytes"          // bytes read from block devices
+	StatIOWriteBytes        = "io.wbytes"          // bytes written to block devices
+	StatIOReadOps           = "io.rios"            // read operations on block devices
+	StatIOWriteOps          = "io.wios"            // write operations on block devices
+)
+
+// userHz is the unit, in ticks per second, of the CPU times in a version 1 cpuacct.stat file.
+const userHz = 100
+
+/*
+	Stats is a snapshot of the resource usage of a cgroup. Statistics which are not available, for example
+	because the kernel does not provide them or because the corresponding cgroup controller is not in use,
+	are zero.
+*/
+type Stats struct {
+	MemoryCurrent       uint64
+	MemoryPeak          uint64
+	OOMKills            uint64
+	CPUUsage            uint64
+	CPUUser             uint64
+	CPUSystem           uint64
+	CPUPeriods          uint64
+	CPUThrottledPeriods uint64
+	CPUThrottledTime    uint64
+	PidsCurrent         uint64
+	IOReadBytes         uint64
+	IOWriteBytes        uint64
+	IOReadOps           uint64
+	IOWriteOps          uint64
+}
+
+// ResourceStats returns the statistics keyed by name.
+func (s Stats) ResourceStats() kernel.ResourceStats {
+	return kernel.ResourceStats{
+		StatMemoryCurrent:       s.MemoryCurrent,
+		StatMemoryPeak:          s.MemoryPeak,
+		StatOOMKills:            s.OOMKills,
+		StatCPUUsage:            s.CPUUsage,
+		StatCPUUser:             s.CPUUser,
+		StatCPUSystem:           s.CPUSystem,
+		StatCPUPeriods:          s.CPUPeriods,
+		StatCPUThrottledPeriods: s.CPUThrottledPeriods,
+		StatCPUThrottledTime:    s.CPUThrottledTime,
+		StatPidsCurrent:         s.PidsCurrent,
+		StatIOReadBytes:         s.IOReadBytes,
+		StatIOWriteBytes:        s.IOWriteBytes,
+		StatIOReadOps:           s.IOReadOps,
+		StatIOWriteOps:          s.IOWriteOps,
+	}
+}
+
+// ReadStats reads the resource usage of the given cgroup from its files.
+func ReadStats(cgroup Cgroup) (Stats, gerror.Gerror) {
+	var s Stats
+	var err error
+	switch cgroup.Version {
+	case V2:
+		err = readV2Stats(cgroup.Path, &s)
+	case V1:
+		err = readV1Stats(cgroup.Paths, &s)
+	default:
+		return s, gerror.New(ErrNotInitialised, "Cgroup has not been created")
+	}
+	if err != nil {
+		return Stats{}, gerror.NewFromError(ErrReadStats, err)
+	}
+	return s, nil
+}
+
+/*
+	Stats implements kernel.ResourceReporter and reports the resource usage of the container's cgroup using
+	the names StatMemoryCurrent and so on.
+*/
+func (c *Controller) Stats(rCtx kernel.ResourceContext) (kernel.ResourceStats, error) {
+	s, gerr := ReadStats(c.Cgroup())
+	if gerr != nil {
+		return nil, gerr
+	}
+	return s.ResourceStats(), nil
+}
+
+func readV2Stats(dir string, s *Stats) error {
+	var err error
+	if s.MemoryCurrent, err = readUint(dir, "memory.current"); err != nil {
+		return err
+	}
+	if s.MemoryPeak, err = readUint(dir, "memory.peak"); err != nil {
+		return err
+	}
+	events, err := readFlatKeyed(dir, "memory.events")
+	if err != nil {
+		return err
+	}
+	s.OOMKills = events["oom_kill"]
+
+	cpu, err := readFlatKeyed(dir, "cpu.stat")
+	if err != nil {
+		return err
+	}
+	s.CPUUsage = cpu["usage_usec"]
+	s.CPUUser = cpu["user_usec"]
+	s.CPUSystem = cpu["system_usec"]
+	s.CPUPeriods = cpu["nr_periods"]
+	s.CPUThrottledPeriods = cpu["nr_throttled"]
+	s.CPUThrottledTime = cpu["throttled_usec"]
+
+	if s.PidsCurrent, err = readUint(dir, "pids.current"); err != nil {
+		return err
+	}
+
+	// Each line of io.stat is a device number followed by key=value pairs.
+	return readLines(dir, "io.stat", func(fields []string) error {
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			switch kv[0] {
+			case "rbytes":
+				s.IOReadBytes += value
+			case "wbytes":
+				s.IOWriteBytes += value
+			case "rios":
+				s.IOReadOps += value
+			case "wios":
+				s.IOWriteOps += value
+			}
+		}
+		return nil
+	})
+}
+
+func readV1Stats(paths map[string]string, s *Stats) error {
+	var err error
+	if memory, ok := paths["memory"]; ok {
+		if s.MemoryCurrent, err = readUint(memory, "memory.usage_in_bytes"); err != nil {
+			return err
+		}
+		if s.MemoryPeak, err = readUint(memory, "memory.max_usage_in_bytes"); err != nil {
+			return err
+		}
+		oom, err := readFlatKeyed(memory, "memory.oom_control")
+		if err != nil {
+			return err
+		}
+		s.OOMKills = oom["oom_kill"]
+	}
+
+	if cpuacct, ok := paths["cpuacct"]; ok {
+		usage, err := readUint(cpuacct, "cpuacct.usage")
+		if err != nil {
+			return err
+		}
+		s.CPUUsage = usage / 1000
+		stat, err := readFlatKeyed(cpuacct, "cpuacct.stat")
+		if err != nil {
+			return err
+		}
+		s.CPUUser = stat["user"] * 1000000 / userHz
+		s.CPUSystem = stat["system"] * 1000000 / userHz
+	}
+	if cpu, ok := paths["cpu"]; ok {
+		stat, err := readFlatKeyed(cpu, "cpu.stat")
+		if err != nil {
+			return err
+		}
+		s.CPUPeriods = stat["nr_periods"]
+		s.CPUThrottledPeriods = stat["nr_throttled"]
+		s.CPUThrottledTime = stat["throttled_time"] / 1000
+	}
+
+	if pids, ok := paths["pids"]; ok {
+		if s.PidsCurrent, err = readUint(pids, "pids.current"); err != nil {
+			return err
+		}
+	}
+
+	if blkio, ok := paths["blkio"]; ok {
+		// Each line is a device number, an operation, and a value, apart from a final line of totals.
+		sum := func(read *uint64, write *uint64) func([]string) error {
+			return func(fields []string) error {
+				if len(fields) != 3 {
+					return nil
+				}
+				value, err := strconv.ParseUint(fields[2], 10, 64)
+				if err != nil {
+					return err
+				}
+				switch fields[1] {
+				case "Read":
+					*read += value
+				case "Write":
+					*write += value
+				}
+				return nil
+			}
+		}
+		if err := readLines(blkio, "blkio.throttle.io_service_bytes", sum(&s.IOReadBytes, &s.IOWriteBytes)); err != nil {
+			return err
+		}
+		if err := readLines(blkio, "blkio.throttle.io_serviced", sum(&s.IOReadOps, &s.IOWriteOps)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUint reads a file containing a single unsigned integer. A missing file or the value "max" gives zero.
+func readUint(dir string, file string) (uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+/*
+	readFlatKeyed reads a file of lines each consisting of a key and an unsigned integer value, such as
+	cpu.stat. A missing file gives an empty map.
+*/
+func readFlatKeyed(dir string, file string) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	err := readLines(dir, file, func(fields []string) error {
+		if len(fields) != 2 {
+			return nil
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		values[fields[0]] = value
+		return nil
+	})
+	return values, err
+}
+
+// readLines calls the given function with the fields of each non-empty line of a file. A missing file has no lines.
+func readLines(dir string, file string, line func([]string) error) error {
+	f, err := os.Open(filepath.Join(dir, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			if err := line(fields); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,195 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups_test
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/cgroups"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadStatsV2(t *testing.T) {
+	dir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, dir)
+	writeFixtures(t, dir, map[string]string{
+		"memory.current": "4096\n",
+		"memory.peak":    "8192\n",
+		"memory.events":  "low 0\nhigh 0\nmax 5\noom 2\noom_kill 1\n",
+		"cpu.stat": "usage_usec 3000\nuser_usec 2000\nsystem_usec 1000\n" +
+			"nr_periods 10\nnr_throttled 4\nthrottled_usec 500\n",
+		"pids.current": "3\n",
+		"io.stat": "8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n" +
+			"8:16 rbytes=1000 wbytes=2000 rios=10 wios=20 dbytes=0 dios=0\n",
+	})
+
+	stats, gerr := cgroups.ReadStats(cgroups.Cgroup{Version: cgroups.V2, Path: dir})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	expected := cgroups.Stats{
+		MemoryCurrent:       4096,
+		MemoryPeak:          8192,
+		OOMKills:            1,
+		CPUUsage:            3000,
+		CPUUser:             2000,
+		CPUSystem:           1000,
+		CPUPeriods:          10,
+		CPUThrottledPeriods: 4,
+		CPUThrottledTime:    500,
+		PidsCurrent:         3,
+		IOReadBytes:         1100,
+		IOWriteBytes:        2200,
+		IOReadOps:           11,
+		IOWriteOps:          22,
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Stats were %+v, expected %+v", stats, expected)
+	}
+	if rs := stats.ResourceStats(); rs[cgroups.StatIOWriteBytes] != 2200 || rs[cgroups.StatOOMKills] != 1 {
+		t.Errorf("Incorrect resource stats %v", rs)
+	}
+}
+
+func TestReadStatsV2MissingFiles(t *testing.T) {
+	dir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, dir)
+	writeFixtures(t, dir, map[string]string{"memory.current": "4096\n"})
+
+	stats, gerr := cgroups.ReadStats(cgroups.Cgroup{Version: cgroups.V2, Path: dir})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	if expected := (cgroups.Stats{MemoryCurrent: 4096}); stats != expected {
+		t.Errorf("Stats were %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestReadStatsV2Malformed(t *testing.T) {
+	dir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, dir)
+	writeFixtures(t, dir, map[string]string{"cpu.stat": "usage_usec lots\n"})
+
+	_, gerr := cgroups.ReadStats(cgroups.Cgroup{Version: cgroups.V2, Path: dir})
+	if gerr == nil || !gerr.EqualTag(cgroups.ErrReadStats) {
+		t.Errorf("Incorrect error %s", gerr)
+	}
+}
+
+func TestReadStatsV1(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	paths := map[string]string{}
+	for _, subsystem := range []string{"memory", "cpu", "cpuacct", "pids", "blkio"} {
+		paths[subsystem] = filepath.Join(root, subsystem)
+		if err := os.Mkdir(paths[subsystem], 0755); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	writeFixtures(t, paths["memory"], map[string]string{
+		"memory.usage_in_bytes":     "4096\n",
+		"memory.max_usage_in_bytes": "8192\n",
+		"memory.oom_control":        "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+	})
+	writeFixtures(t, paths["cpuacct"], map[string]string{
+		"cpuacct.usage": "3000000\n",
+		"cpuacct.stat":  "user 20\nsystem 10\n",
+	})
+	writeFixtures(t, paths["cpu"], map[string]string{
+		"cpu.stat": "nr_periods 10\nnr_throttled 4\nthrottled_time 500000\n",
+	})
+	writeFixtures(t, paths["pids"], map[string]string{"pids.current": "3\n"})
+	writeFixtures(t, paths["blkio"], map[string]string{
+		"blkio.throttle.io_service_bytes": "8:0 Read 100\n8:0 Write 200\n8:0 Sync 300\n8:0 Async 0\n8:0 Total 300\n" +
+			"8:16 Read 1000\n8:16 Write 2000\nTotal 3300\n",
+		"blkio.throttle.io_serviced": "8:0 Read 1\n8:0 Write 2\n8:0 Total 3\nTotal 3\n",
+	})
+
+	stats, gerr := cgroups.ReadStats(cgroups.Cgroup{Version: cgroups.V1, Paths: paths})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	expected := cgroups.Stats{
+		MemoryCurrent:       4096,
+		MemoryPeak:          8192,
+		OOMKills:            2,
+		CPUUsage:            3000,
+		CPUUser:             200000,
+		CPUSystem:           100000,
+		CPUPeriods:          10,
+		CPUThrottledPeriods: 4,
+		CPUThrottledTime:    500,
+		PidsCurrent:         3,
+		IOReadBytes:         1100,
+		IOWriteBytes:        2200,
+		IOReadOps:           1,
+		IOWriteOps:          2,
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Stats were %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestReadStatsNotCreated(t *testing.T) {
+	_, gerr := cgroups.ReadStats(cgroups.Cgroup{})
+	if gerr == nil || !gerr.EqualTag(cgroups.ErrNotInitialised) {
+		t.Errorf("Incorrect error %s", gerr)
+	}
+}
+
+func TestStats(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	c := cgroups.NewWithOptions(cgroups.Options{Root: root, Version: cgroups.V2})
+	rCtx := createContext(t, cgroups.Limits{})
+	if err := c.Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	writeFixtures(t, c.Cgroup().Path, map[string]string{"pids.current": "7\n"})
+
+	stats, err := c.Stats(rCtx)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if stats[cgroups.StatPidsCurrent] != 7 {
+		t.Errorf("Incorrect stats %v", stats)
+	}
+}
+
+func TestStatsNotInitialised(t *testing.T) {
+	_, err := cgroups.New().Stats(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrNotInitialised) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+// writeFixtures writes the given cgroup files, keyed by name, in the given directory.
+func writeFixtures(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+)
+
+// v1Subsystems maps each cgroup controller, named as in version 2, to the version 1 subsystems which provide it.
+var v1Subsystems = map[string][]string{
+	"cpu":    {"cpu", "cpuacct"},
+	"io":     {"blkio"},
+	"memory": {"memory"},
+	"pids":   {"pids"},
+}
+
+// v1 is the backend for version 1 cgroups.
+type v1 struct {
+	hierarchies map[string]string // the mount point of the hierarchy of each subsystem used
+	parent      string            // the parent cgroup, relative to each mount point
+}
+
+// newV1 returns a v1 backend for the given controllers using the given mount points of the subsystems' hierarchies.
+func newV1(mounts map[string]string, parent string, controllers []string) (*v1, gerror.Gerror) {
+	hierarchies := make(map[string]string)
+	for _, controller := range controllers {
+		subsystems, ok := v1Subsystems[controller]
+		if !ok {
+			return nil, gerror.Newf(ErrUnavailable, "Controller %q is not supported by version 1 cgroups", controller)
+		}
+		for _, subsystem := range subsystems {
+			mountPoint, ok := mounts[subsystem]
+			if !ok {
+				return nil, gerror.Newf(ErrUnavailable, "Subsystem %q is not mounted", subsystem)
+			}
+			hierarchies[subsystem] = mountPoint
+		}
+	}
+	return &v1{hierarchies: hierarchies, parent: parent}, nil
+}
+
+/*
+	create creates the cgroup with the given name under the parent cgroup in the hierarchy of each subsystem
+	and writes the given limits to it. Subsystems which share a hierarchy share a cgroup directory.
+*/
+func (b *v1) create(name string, limits Limits) (Cgroup, gerror.Gerror) {
+	paths := make(map[string]string)
+	for subsystem, mountPoint := range b.hierarchies {
+		paths[subsystem] = filepath.Join(mountPoint, b.parent, name)
+	}
+	cgroup := Cgroup{Version: V1, Paths: paths}
+
+	var created []string
+	for _, dir := range cgroup.Dirs() {
+		err := os.MkdirAll(filepath.Dir(dir), cgroupDirMode)
+		if err == nil {
+			err = os.Mkdir(dir, cgroupDirMode)
+		}
+		if err != nil {
+			removeCgroups(created)
+			return Cgroup{}, gerror.NewFromError(ErrCreateCgroup, err)
+		}
+		created = append(created, dir)
+	}
+	if gerr := applyV1Limits(cgroup, limits); gerr != nil {
+		removeCgroups(created)
+		return Cgroup{}, gerr
+	}
+	return cgroup, nil
+}
+
+/*
+	applyV1Limits writes the given limits to the given version 1 cgroup. The limits are converted as follows:
+
+		memory.max        memory.limit_in_bytes
+		memory.swap.max   memory.memsw.limit_in_bytes, which limits memory plus swap, so the memory
+		                  limit is added to the swap limit and a memory limit is required
+		cpu.max           cpu.cfs_period_us and then cpu.cfs_quota_us
+		cpu.weight        cpu.shares, scaled linearly from [1, 10000] to [2, 262144]
+		pids.max          pids.max
+		io.max            blkio.throttle.read_bps_device, blkio.throttle.write_bps_device,
+		                  blkio.throttle.read_iops_device, and blkio.throttle.write_iops_device
+
+	Files of limits which are zero are not written.
+*/
+func applyV1Limits(cgroup Cgroup, limits Limits) gerror.Gerror {
+	if limits.MemorySwap != 0 && limits.Memory == 0 {
+		return gerror.New(ErrInvalidLimit, "Version 1 cgroups cannot limit swap without limiting memory")
+	}
+	type file struct {
+		subsystem string
+		name      string
+		value     string
+	}
+	var files []file
+	if limits.Memory != 0 {
+		files = append(files, file{"memory", "memory.limit_in_bytes", fmt.Sprint(limits.Memory)})
+	}
+	if limits.MemorySwap != 0 {
+		files = append(files, file{"memory", "memory.memsw.limit_in_bytes", fmt.Sprint(limits.Memory + limits.MemorySwap)})
+	}
+	if limits.CPUQuota != 0 {
+		files = append(files,
+			file{"cpu", "cpu.cfs_period_us", fmt.Sprint(cpuPeriod(limits))},
+			file{"cpu", "cpu.cfs_quota_us", fmt.Sprint(limits.CPUQuota)})
+	}
+	if limits.CPUWeight != 0 {
+		files = append(files, file{"cpu", "cpu.shares", fmt.Sprint(cpuShares(limits.CPUWeight))})
+	}
+	if limits.Pids != 0 {
+		files = append(files, file{"pids", "pids.max", fmt.Sprint(limits.Pids)})
+	}
+	for _, f := range files {
+		if gerr := writeV1(cgroup, f.subsystem, f.name, f.value, writeFile); gerr != nil {
+			return gerr
+		}
+	}
+
+	for _, io := range limits.IO {
+		device := fmt.Sprintf("%d:%d", io.Major, io.Minor)
+		for _, throttle := range []struct {
+			name  string
+			value uint64
+		}{
+			{"blkio.throttle.read_bps_device", io.ReadBps},
+			{"blkio.throttle.write_bps_device", io.WriteBps},
+			{"blkio.throttle.read_iops_device", io.ReadIOps},
+			{"blkio.throttle.write_iops_device", io.WriteIOps},
+		} {
+			if throttle.value != 0 {
+				value := fmt.Sprintf("%s %d", device, throttle.value)
+				if gerr := writeV1(cgroup, "blkio", throttle.name, value, appendFile); gerr != nil {
+					return gerr
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeV1 writes the given value to the given file of the given subsystem's directory of the given cgroup.
+func writeV1(cgroup Cgroup, subsystem string, name string, value string, write func(string, string, string) error) gerror.Gerror {
+	dir, ok := cgroup.Paths[subsystem]
+	if !ok {
+		return gerror.Newf(ErrUnavailable, "Subsystem %q is required to write %s but is not in use", subsystem, name)
+	}
+	if err := write(dir, name, value); err != nil {
+		glog.Errorf("Writing %q to %s of cgroup %q failed: %s", value, name, dir, err)
+		return gerror.NewFromError(ErrWriteLimit, err)
+	}
+	return nil
+}
+
+// cpuShares converts a version 2 CPU weight in the range [1, 10000] to version 1 CPU shares in the range [2, 262144].
+func cpuShares(weight uint64) uint64 {
+	return 2 + (weight-1)*262142/9999
+}
+
+// removeCgroups removes the given cgroup directories, logging any failures.
+func removeCgroups(dirs []string) {
+	for _, dir := range dirs {
+		if gerr := removeCgroup(dir); gerr != nil {
+			glog.Warningf("Encountered %q while removing cgroup %q", gerr, dir)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups_test
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/cgroups"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const testCgroups = `#subsys_name	hierarchy	num_cgroups	enabled
+cpuset	3	1	1
+cpu	1	1	1
+cpuacct	1	1	1
+blkio	7	1	1
+memory	4	23	%s
+pids	8	1	1
+`
+
+// hybridMountInfo returns a mount table with version 1 hierarchies and a version 2 hierarchy under the given root.
+func hybridMountInfo(root string) string {
+	return fmt.Sprintf(`15 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+32 15 0:28 / %[1]s rw - tmpfs tmpfs rw,mode=755
+33 32 0:29 / %[1]s/cpu,cpuacct rw - cgroup cgroup rw,cpu,cpuacct
+34 32 0:30 / %[1]s/cpuset rw - cgroup cgroup rw,cpuset
+35 32 0:31 / %[1]s/memory rw - cgroup cgroup rw,memory
+36 32 0:32 / %[1]s/blkio rw - cgroup cgroup rw,blkio
+37 32 0:33 / %[1]s/pids rw - cgroup cgroup rw,pids
+38 32 0:34 / %[1]s/systemd rw - cgroup cgroup rw,name=systemd
+39 32 0:35 / %[1]s/unified rw - cgroup2 cgroup2 rw
+`, root)
+}
+
+func TestDetectV2(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, fmt.Sprintf("32 15 0:28 / %s rw - cgroup2 cgroup2 rw\n", root), "1")
+
+	c := cgroups.NewWithOptions(opts)
+	if err := c.Init(createContext(t, cgroups.Limits{Pids: 10})); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	cgroup := filepath.Join(root, cgroups.DefaultParent, "test-id")
+	if cg := c.Cgroup(); !reflect.DeepEqual(cg, cgroups.Cgroup{Version: cgroups.V2, Path: cgroup}) {
+		t.Errorf("Incorrect cgroup %+v", cg)
+	}
+	checkFile(t, cgroup, "pids.max", "10")
+}
+
+func TestDetectV1(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+
+	c := cgroups.NewWithOptions(opts)
+	if err := c.Init(createContext(t, cgroups.Limits{})); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	cpu := filepath.Join(root, "cpu,cpuacct", cgroups.DefaultParent, "test-id")
+	expected := cgroups.Cgroup{Version: cgroups.V1, Paths: map[string]string{
+		"cpu":     cpu,
+		"cpuacct": cpu,
+		"blkio":   filepath.Join(root, "blkio", cgroups.DefaultParent, "test-id"),
+		"memory":  filepath.Join(root, "memory", cgroups.DefaultParent, "test-id"),
+		"pids":    filepath.Join(root, "pids", cgroups.DefaultParent, "test-id"),
+	}}
+	if cg := c.Cgroup(); !reflect.DeepEqual(cg, expected) {
+		t.Errorf("Incorrect cgroup %+v", cg)
+	}
+	if dirs := c.Cgroup().Dirs(); len(dirs) != 4 {
+		t.Errorf("Incorrect cgroup directories %v", dirs)
+	}
+	for _, dir := range c.Cgroup().Dirs() {
+		if !test_support.FileExists(dir) {
+			t.Errorf("Cgroup directory %q was not created", dir)
+		}
+	}
+}
+
+func TestDetectNoCgroups(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, "15 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n", "1")
+
+	err := cgroups.NewWithOptions(opts).Init(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrDetect) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestDetectMissingMountInfo(t *testing.T) {
+	c := cgroups.NewWithOptions(cgroups.Options{MountInfoPath: "/no-such-file"})
+	err := c.Init(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrDetect) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestV1OnV2Host(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, fmt.Sprintf("32 15 0:28 / %s rw - cgroup2 cgroup2 rw\n", root), "1")
+	opts.Version = cgroups.V1
+
+	err := cgroups.NewWithOptions(opts).Init(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrDetect) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestV1DisabledSubsystem(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "0")
+
+	err := cgroups.NewWithOptions(opts).Init(createContext(t, cgroups.Limits{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrUnavailable) {
+		t.Errorf("Incorrect error %v", err)
+	}
+
+	// The memory subsystem is not needed to limit the number of processes.
+	opts.Controllers = []string{"pids"}
+	if err := cgroups.NewWithOptions(opts).Init(createContext(t, cgroups.Limits{Pids: 10})); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestV1Limits(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+
+	c := cgroups.NewWithOptions(opts)
+	err := c.Init(createContext(t, cgroups.Limits{
+		Memory:     1 << 30,
+		MemorySwap: 1 << 20,
+		CPUQuota:   50000,
+		CPUPeriod:  200000,
+		CPUWeight:  100,
+		Pids:       64,
+		IO: []cgroups.IOLimit{
+			{Major: 8, Minor: 0, ReadBps: 1048576, WriteIOps: 100},
+			{Major: 253, Minor: 1, ReadBps: 4096},
+		},
+	}))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	paths := c.Cgroup().Paths
+	checkFile(t, paths["memory"], "memory.limit_in_bytes", "1073741824")
+	checkFile(t, paths["memory"], "memory.memsw.limit_in_bytes", "1074790400")
+	checkFile(t, paths["cpu"], "cpu.cfs_period_us", "200000")
+	checkFile(t, paths["cpu"], "cpu.cfs_quota_us", "50000")
+	checkFile(t, paths["cpu"], "cpu.shares", "2597")
+	checkFile(t, paths["pids"], "pids.max", "64")
+	checkFile(t, paths["blkio"], "blkio.throttle.read_bps_device", "8:0 1048576\n253:1 4096\n")
+	checkFile(t, paths["blkio"], "blkio.throttle.write_iops_device", "8:0 100\n")
+	if test_support.FileExists(filepath.Join(paths["blkio"], "blkio.throttle.write_bps_device")) {
+		t.Errorf("Unexpected write_bps_device file")
+	}
+}
+
+func TestV1CPUShares(t *testing.T) {
+	for weight, shares := range map[uint64]string{1: "2", 10000: "262144"} {
+		root := test_support.CreateTempDir()
+		defer test_support.CleanupDirs(t, root)
+		opts := writeLayout(t, root, hybridMountInfo(root), "1")
+
+		c := cgroups.NewWithOptions(opts)
+		if err := c.Init(createContext(t, cgroups.Limits{CPUWeight: weight})); err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		checkFile(t, c.Cgroup().Paths["cpu"], "cpu.shares", shares)
+	}
+}
+
+func TestV1SwapWithoutMemory(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+
+	err := cgroups.NewWithOptions(opts).Init(createContext(t, cgroups.Limits{MemorySwap: 1 << 20}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrInvalidLimit) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	if test_support.FileExists(filepath.Join(root, "memory", cgroups.DefaultParent, "test-id")) {
+		t.Errorf("Cgroup was not removed")
+	}
+}
+
+func TestV1LimitWithoutController(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+	opts.Controllers = []string{"pids"}
+
+	err := cgroups.NewWithOptions(opts).Init(createContext(t, cgroups.Limits{Memory: 1 << 20}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(cgroups.ErrUnavailable) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestV1StartedAndTeardown(t *testing.T) {
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	opts := writeLayout(t, root, hybridMountInfo(root), "1")
+
+	rCtx := createContext(t, cgroups.Limits{})
+	c := cgroups.NewWithOptions(opts)
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if err := c.Started(rCtx, os.Getpid()); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	dirs := c.Cgroup().Dirs()
+	for _, dir := range dirs {
+		checkFile(t, dir, "cgroup.procs", strconv.Itoa(os.Getpid()))
+		// The cgroup filesystem removes a cgroup's files along with the cgroup.
+		os.Remove(filepath.Join(dir, "cgroup.procs"))
+	}
+
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	for _, dir := range dirs {
+		if test_support.FileExists(dir) {
+			t.Errorf("Cgroup directory %q was not removed", dir)
+		}
+	}
+}
+
+// writeLayout writes the given mount table and a list of subsystems and returns options which use them.
+func writeLayout(t *testing.T, root string, mountInfo string, memoryEnabled string) cgroups.Options {
+	dir := test_support.CreateDir(root, "proc")
+	mountInfoPath := filepath.Join(dir, "mountinfo")
+	cgroupsPath := filepath.Join(dir, "cgroups")
+	if err := ioutil.WriteFile(mountInfoPath, []byte(mountInfo), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ioutil.WriteFile(cgroupsPath, []byte(fmt.Sprintf(testCgroups, memoryEnabled)), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return cgroups.Options{Root: root, MountInfoPath: mountInfoPath, CgroupsPath: cgroupsPath}
+}
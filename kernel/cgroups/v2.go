@@ -0,0 +1,139 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// v2 is the backend for version 2 cgroups.
+type v2 struct {
+	root        string   // the mount point of the cgroup filesystem
+	parent      string   // the parent cgroup, relative to root
+	controllers []string // the controllers to enable
+}
+
+/*
+	create enables the controllers for the children of the parent cgroup, creates the cgroup with the given
+	name under the parent, and writes the given limits to it.
+*/
+func (b *v2) create(name string, limits Limits) (Cgroup, gerror.Gerror) {
+	if gerr := enableControllers(b.root, b.parent, b.controllers); gerr != nil {
+		return Cgroup{}, gerr
+	}
+	path := filepath.Join(b.root, b.parent, name)
+	if err := os.Mkdir(path, cgroupDirMode); err != nil {
+		return Cgroup{}, gerror.NewFromError(ErrCreateCgroup, err)
+	}
+	if gerr := applyLimits(path, limits); gerr != nil {
+		removeCgroup(path)
+		return Cgroup{}, gerr
+	}
+	return Cgroup{Version: V2, Path: path}, nil
+}
+
+/*
+	enableControllers creates the given parent cgroup, if necessary, and enables the given cgroup controllers
+	for the children of the root cgroup, the parent cgroup, and the cgroups in between, so that the
+	controllers are available in the parent's child cgroups.
+*/
+func enableControllers(root string, parent string, controllers []string) gerror.Gerror {
+	if len(controllers) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(root, parent), cgroupDirMode); err != nil {
+		return gerror.NewFromError(ErrCreateCgroup, err)
+	}
+	enable := "+" + strings.Join(controllers, " +")
+	cgroup := root
+	for _, dir := range append([]string{""}, strings.Split(filepath.Clean(parent), string(filepath.Separator))...) {
+		cgroup = filepath.Join(cgroup, dir)
+		if glog.V(2) {
+			glog.Infof("Enabling %q in cgroup %q", enable, cgroup)
+		}
+		if err := writeFile(cgroup, "cgroup.subtree_control", enable); err != nil {
+			return gerror.NewFromError(ErrEnableControllers, err)
+		}
+	}
+	return nil
+}
+
+// applyLimits writes the given limits to the given cgroup. Files of limits which are zero are not written.
+func applyLimits(cgroup string, limits Limits) gerror.Gerror {
+	var files [][2]string
+	if limits.Memory != 0 {
+		files = append(files, [2]string{"memory.max", fmt.Sprint(limits.Memory)})
+	}
+	if limits.MemorySwap != 0 {
+		files = append(files, [2]string{"memory.swap.max", fmt.Sprint(limits.MemorySwap)})
+	}
+	if limits.CPUQuota != 0 {
+		files = append(files, [2]string{"cpu.max", fmt.Sprintf("%d %d", limits.CPUQuota, cpuPeriod(limits))})
+	}
+	if limits.CPUWeight != 0 {
+		files = append(files, [2]string{"cpu.weight", fmt.Sprint(limits.CPUWeight)})
+	}
+	if limits.Pids != 0 {
+		files = append(files, [2]string{"pids.max", fmt.Sprint(limits.Pids)})
+	}
+	for _, file := range files {
+		if err := writeFile(cgroup, file[0], file[1]); err != nil {
+			glog.Errorf("Writing %q to %s of cgroup %q failed: %s", file[1], file[0], cgroup, err)
+			return gerror.NewFromError(ErrWriteLimit, err)
+		}
+	}
+	// Each device's limits are written separately since io.max accepts one device per write.
+	for _, io := range limits.IO {
+		if line := ioMax(io); line != "" {
+			if err := appendFile(cgroup, "io.max", line); err != nil {
+				glog.Errorf("Writing %q to io.max of cgroup %q failed: %s", line, cgroup, err)
+				return gerror.NewFromError(ErrWriteLimit, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cpuPeriod returns the CPU period of the given limits.
+func cpuPeriod(limits Limits) uint64 {
+	if limits.CPUPeriod == 0 {
+		return DefaultCPUPeriod
+	}
+	return limits.CPUPeriod
+}
+
+// ioMax returns the io.max line for the given IO limit, or the empty string if no rate is limited.
+func ioMax(io IOLimit) string {
+	var keys []string
+	for _, kv := range []struct {
+		key   string
+		value uint64
+	}{{"rbps", io.ReadBps}, {"wbps", io.WriteBps}, {"riops", io.ReadIOps}, {"wiops", io.WriteIOps}} {
+		if kv.value != 0 {
+			keys = append(keys, fmt.Sprintf("%s=%d", kv.key, kv.value))
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d %s", io.Major, io.Minor, strings.Join(keys, " "))
+}
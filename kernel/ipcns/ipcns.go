@@ -0,0 +1,89 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package ipcns provides a resource controller which gives a container an IPC namespace of its own so that
+its System V IPC objects and POSIX message queues are isolated from those of other containers.
+*/
+package ipcns
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+)
+
+// ErrorId is used for error ids relating to the ipcns package.
+type ErrorId int
+
+const (
+	ErrNamespaceId    ErrorId = iota // the IPC namespace of a process could not be determined
+	ErrPublish                       // the runner's IPC namespace could not be published in the resource context
+	ErrNotInitialised                // the controller has not successfully initialised
+	ErrNotIsolated                   // the container's process is not in an IPC namespace of its own
+)
+
+// hostNamespaceKey is the key under which Init publishes the identifier of the runner's IPC namespace.
+const hostNamespaceKey = "ipcns.host"
+
+/*
+	A Controller is a resource controller which creates the container's process in a new IPC namespace. A
+	POSIX message queue filesystem mounted in the container, such as the one mounted by a mountns.Controller,
+	then shows the message queues of the new namespace.
+*/
+type Controller struct {
+	sc syscall.SyscallNS
+}
+
+var _ kernel.ResourceEnterer = &Controller{}
+
+// Creates a new Controller which uses the given SyscallNS instance.
+func New(sc syscall.SyscallNS) *Controller {
+	return &Controller{sc: sc}
+}
+
+// Init adds an IPC namespace to the namespaces in which the container's process is created.
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	hostNS, err := c.sc.NamespaceId(0, syscall.IPCNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if err := rCtx.SetConfig(hostNamespaceKey, hostNS); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	if glog.V(1) {
+		glog.Infof("Init: requesting an IPC namespace for container %q", rCtx.GetId())
+	}
+	rCtx.AddNamespaces(syscall.IPCNS)
+	return nil
+}
+
+// Enter checks that the calling process is not in the runner's IPC namespace.
+func (c *Controller) Enter(rCtx kernel.ResourceContext) error {
+	var hostNS string
+	if ok, err := rCtx.GetConfig(hostNamespaceKey, &hostNS); err != nil || !ok {
+		return gerror.New(ErrNotInitialised, "IPC namespace of the runner has not been published")
+	}
+	ns, err := c.sc.NamespaceId(0, syscall.IPCNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if ns == hostNS {
+		return gerror.Newf(ErrNotIsolated, "Process is in the runner's IPC namespace %q", ns)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ipcns_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/ipcns"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
+	"testing"
+)
+
+const (
+	hostNS      = "ipc:[1]"
+	containerNS = "ipc:[2]"
+)
+
+func TestInit(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS)
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.IPCNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestInitNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := kernel.CreateResourceContext("/")
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.IPCNS).Return("", errors.New("an error"))
+	err := ipcns.New(mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(ipcns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != 0 {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestEnter(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.IPCNS).Return(containerNS, nil)
+	if err := ipcns.New(mockSyscallNS).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestEnterNotInitialised(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	err := ipcns.New(mockSyscallNS).Enter(kernel.CreateResourceContext("/"))
+	if err == nil || !err.(gerror.Gerror).EqualTag(ipcns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNotIsolated(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.IPCNS).Return(hostNS, nil)
+	err := ipcns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(ipcns.ErrNotIsolated) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.IPCNS).Return("", errors.New("an error"))
+	err := ipcns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(ipcns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func initController(t *testing.T, mockSyscallNS *mock_syscall.MockSyscallNS) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContext("/")
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.IPCNS).Return(hostNS, nil)
+	if err := ipcns.New(mockSyscallNS).Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func setupMocks(t *testing.T) (*gomock.Controller, *mock_syscall.MockSyscallNS) {
+	mockCtrl := gomock.NewController(t)
+	return mockCtrl, mock_syscall.NewMockSyscallNS(mockCtrl)
+}
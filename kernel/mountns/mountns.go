@@ -0,0 +1,423 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package mountns provides a resource controller which gives a container a root filesystem of its
+own in a mount namespace of its own.
+*/
+package mountns
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/rootfs"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"github.com/cf-guardian/guardian/kernel/userns"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"sync"
+	trueSyscall "syscall"
+)
+
+// ErrorId is used for error ids relating to the mountns package.
+type ErrorId int
+
+const (
+	ErrGenerate         ErrorId = iota // the root filesystem could not be generated
+	ErrRemove                          // the root filesystem could not be removed
+	ErrNotInitialised                  // the controller has not successfully initialised
+	ErrPropagation                     // the mounts of the container could not be made private
+	ErrCreateMountPoint                // a mount point could not be created in the root filesystem
+	ErrMount                           // a filesystem could not be mounted in the root filesystem
+	ErrCreateDevice                    // a device file could not be created in the root filesystem
+	ErrBindDevice                      // a device could not be bind mounted in the root filesystem
+	ErrCreateSymlink                   // a symbolic link could not be created in the root filesystem
+	ErrEnterRoot                       // the root filesystem could not be entered
+	ErrStats                           // the disk usage of the root filesystem could not be determined
+	ErrNamespaceId                     // the mount namespace of a process could not be determined
+	ErrPublish                         // the runner's mount namespace could not be published in the resource context
+	ErrNotIsolated                     // the container's process is not in a mount namespace of its own
+)
+
+/*
+	RootKey is the resource context configuration key under which Init publishes the path of the generated
+	root filesystem, as a string.
+*/
+const RootKey = "mountns.root"
+
+// hostNamespaceKey is the key under which Init publishes the identifier of the runner's mount namespace.
+const hostNamespaceKey = "mountns.host"
+
+// StatRwLayerBytes is the name of the statistic giving the disk usage, in bytes, of the read-write layer.
+const StatRwLayerBytes = "rootfs.rw_bytes"
+
+// A Mount describes a filesystem mounted in the root filesystem of a container.
+type Mount struct {
+	Source string             // the source, such as "proc" or "tmpfs"
+	Target string             // the mount point, relative to the root filesystem, such as "proc" or "dev/pts"
+	FsType string             // the filesystem type
+	Flags  syscall.MountFlags // the mount flags
+	Data   string             // filesystem-specific data
+}
+
+/*
+	DefaultMounts returns the filesystems mounted in the root filesystem when none are specified: proc,
+	a read-only sysfs, a tmpfs on dev, and devpts, shm, and mqueue under dev.
+*/
+func DefaultMounts() []Mount {
+	const safe = syscall.MountNoSuid | syscall.MountNoExec | syscall.MountNoDev
+	return []Mount{
+		{"proc", "proc", "proc", safe, ""},
+		{"sysfs", "sys", "sysfs", safe | syscall.MountReadOnly, ""},
+		{"tmpfs", "dev", "tmpfs", syscall.MountNoSuid | syscall.MountStrictAtime, "mode=755,size=65536k"},
+		{"devpts", "dev/pts", "devpts", syscall.MountNoSuid | syscall.MountNoExec, "newinstance,ptmxmode=0666,mode=0620"},
+		{"shm", "dev/shm", "tmpfs", safe, "mode=1777,size=65536k"},
+		{"mqueue", "dev/mqueue", "mqueue", safe, ""},
+	}
+}
+
+/*
+	DefaultDevices returns the devices bind mounted from the host's /dev into the root filesystem's /dev
+	when none are specified.
+*/
+func DefaultDevices() []string {
+	return []string{"null", "zero", "full", "random", "urandom", "tty"}
+}
+
+// devSymlinks are the symbolic links created in the root filesystem's /dev, keyed by link name.
+var devSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+const (
+	mountPointMode os.FileMode = 0755
+	deviceFileMode os.FileMode = 0666
+)
+
+// Options modify the behaviour of a Controller. The zero value gives the default behaviour.
+type Options struct {
+	// Mounts are the filesystems mounted in the root filesystem, in order. If nil, DefaultMounts() is used.
+	Mounts []Mount
+
+	// Devices are the devices bind mounted into /dev. If nil, DefaultDevices() is used.
+	Devices []string
+
+	// SyscallNS determines the mount namespace of a process. If nil, syscall_linux.NewNS() is used.
+	SyscallNS syscall.SyscallNS
+}
+
+/*
+	A Controller is a resource controller which generates a root filesystem for a container from the
+	prototype given by the resource context and removes it when the container is torn down. The
+	container's process enters the root filesystem by calling Enter.
+
+	Init replaces the root filesystem of the resource context with the generated root filesystem so
+	that later resource controllers and the runner use the generated root filesystem. If the resource
+	context has a userns.Mapping, the read-write layer is owned by the ids to which it maps the
+	prototype's owners.
+
+	A Controller serves a single container.
+*/
+type Controller struct {
+	rfs     rootfs.RootFS
+	sc      syscall.SyscallFS
+	ns      syscall.SyscallNS
+	mounts  []Mount
+	devices []string
+	mu      sync.Mutex // guards root
+	root    string
+}
+
+var (
+	_ kernel.ResourceDependent = &Controller{}
+	_ kernel.ResourceEnterer   = &Controller{}
+	_ kernel.ResourceReleaser  = &Controller{}
+	_ kernel.ResourceReporter  = &Controller{}
+)
+
+// Creates a new Controller which uses the given RootFS and SyscallFS instances.
+func New(rfs rootfs.RootFS, sc syscall.SyscallFS) *Controller {
+	return NewWithOptions(rfs, sc, Options{})
+}
+
+// Creates a new Controller as for New but with the given options.
+func NewWithOptions(rfs rootfs.RootFS, sc syscall.SyscallFS, opts Options) *Controller {
+	c := &Controller{rfs: rfs, sc: sc, ns: opts.SyscallNS, mounts: opts.Mounts, devices: opts.Devices}
+	if c.ns == nil {
+		c.ns = syscall_linux.NewNS()
+	}
+	if c.mounts == nil {
+		c.mounts = DefaultMounts()
+	}
+	if c.devices == nil {
+		c.devices = DefaultDevices()
+	}
+	return c
+}
+
+/*
+	Init generates a root filesystem from the prototype given by the resource context, publishes it in the
+	resource context, and adds a mount namespace to the namespaces in which the container's process is
+	created. The identifier of the runner's mount namespace is also published so that Enter can check that
+	it is not called in that namespace.
+*/
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	hostNS, err := c.ns.NamespaceId(0, syscall.MountNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if err := rCtx.SetConfig(hostNamespaceKey, hostNS); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	prototype := rCtx.GetRootFS()
+	if glog.V(1) {
+		glog.Infof("Init: generating root filesystem from %q", prototype)
+	}
+	var mapping userns.Mapping
+	ok, err := rCtx.GetConfig(userns.MappingKey, &mapping)
+	if err != nil {
+		return gerror.NewFromError(ErrGenerate, err)
+	}
+	var root string
+	var gerr gerror.Gerror
+	if ok {
+		// The read-write layer must be owned by the container's users rather than by the runner.
+		root, gerr = c.rfs.GenerateWithOptions(prototype, rootfs.GenerateOptions{Uids: mapping.Uids, Gids: mapping.Gids})
+	} else {
+		root, gerr = c.rfs.Generate(prototype)
+	}
+	if gerr != nil {
+		return gerror.NewFromError(ErrGenerate, gerr)
+	}
+	c.mu.Lock()
+	c.root = root
+	c.mu.Unlock()
+	if err := rCtx.SetConfig(RootKey, root); err != nil {
+		c.Teardown(rCtx)
+		return gerror.NewFromError(ErrGenerate, err)
+	}
+	rCtx.SetRootFS(root)
+	rCtx.AddNamespaces(syscall.MountNS)
+	return nil
+}
+
+// Provides returns the capabilities provided by a Controller, namely a root filesystem.
+func (c *Controller) Provides() []kernel.Capability {
+	return []kernel.Capability{kernel.RootFSCapability}
+}
+
+// Requires returns nil as a Controller does not require any capabilities.
+func (c *Controller) Requires() []kernel.Capability {
+	return nil
+}
+
+// Follows returns the capabilities followed by a Controller, namely a user namespace.
+func (c *Controller) Follows() []kernel.Capability {
+	return []kernel.Capability{kernel.UserNSCapability}
+}
+
+// Root returns the path of the generated root filesystem, or the empty string if Init has not succeeded.
+func (c *Controller) Root() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.root
+}
+
+/*
+	Enter sets up the mount namespace of the calling process, which must be a mount namespace of its own
+	such as the one requested by Init, and then makes the root filesystem published in the resource context by Init its root directory. All
+	mounts are made private, the filesystems and devices are mounted in the root filesystem, and the root
+	filesystem is entered using rootfs.Enter. The root filesystem of the resource context is then set to
+	"/". Enter fails with ErrNotIsolated, rather than changing the mounts of the runner's mount namespace,
+	if the calling process is in that namespace.
+
+	Enter may be called on a different Controller from the one which was initialised, such as in the
+	container's process.
+*/
+func (c *Controller) Enter(rCtx kernel.ResourceContext) error {
+	var root string
+	if ok, err := rCtx.GetConfig(RootKey, &root); err != nil || !ok || root == "" {
+		return gerror.New(ErrNotInitialised, "Root filesystem has not been generated")
+	}
+	var hostNS string
+	if ok, err := rCtx.GetConfig(hostNamespaceKey, &hostNS); err != nil || !ok {
+		return gerror.New(ErrNotInitialised, "Mount namespace of the runner has not been published")
+	}
+	// Making the mounts private in the runner's mount namespace would change the host's mount propagation.
+	ns, err := c.ns.NamespaceId(0, syscall.MountNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if ns == hostNS {
+		return gerror.Newf(ErrNotIsolated, "Process is in the runner's mount namespace %q", ns)
+	}
+	if glog.V(1) {
+		glog.Infof("Enter: entering root filesystem %q", root)
+	}
+	if err := c.sc.SetPropagation("/", syscall.PropagationPrivate, true); err != nil {
+		return gerror.NewFromError(ErrPropagation, err)
+	}
+	for _, m := range c.mounts {
+		if gerr := c.mount(root, m); gerr != nil {
+			return gerr
+		}
+	}
+	if gerr := c.populateDev(root); gerr != nil {
+		return gerr
+	}
+	if gerr := rootfs.Enter(c.sc, root); gerr != nil {
+		return gerror.NewFromError(ErrEnterRoot, gerr)
+	}
+	rCtx.SetRootFS("/")
+	return nil
+}
+
+// mount mounts the given filesystem in the given root filesystem.
+func (c *Controller) mount(root string, m Mount) gerror.Gerror {
+	target := filepath.Join(root, m.Target)
+	if err := os.MkdirAll(target, mountPointMode); err != nil {
+		return gerror.NewFromError(ErrCreateMountPoint, err)
+	}
+	if err := c.sc.Mount(m.Source, target, m.FsType, m.Flags, m.Data); err != nil {
+		glog.Errorf("Mounting %s at %q failed: %s", m.FsType, target, err)
+		return gerror.NewFromError(ErrMount, err)
+	}
+	return nil
+}
+
+/*
+	populateDev bind mounts the devices from the host's /dev into the given root filesystem's /dev and
+	creates the standard symbolic links there.
+*/
+func (c *Controller) populateDev(root string) gerror.Gerror {
+	dev := filepath.Join(root, "dev")
+	if err := os.MkdirAll(dev, mountPointMode); err != nil {
+		return gerror.NewFromError(ErrCreateMountPoint, err)
+	}
+	for _, device := range c.devices {
+		target := filepath.Join(dev, device)
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, deviceFileMode)
+		if err != nil {
+			return gerror.NewFromError(ErrCreateDevice, err)
+		}
+		f.Close()
+		if err := c.sc.Mount(filepath.Join("/dev", device), target, "", syscall.MountBind, ""); err != nil {
+			glog.Errorf("Bind mounting device %q failed: %s", device, err)
+			return gerror.NewFromError(ErrBindDevice, err)
+		}
+	}
+	for link, target := range devSymlinks {
+		path := filepath.Join(dev, link)
+		if _, err := os.Lstat(path); err == nil {
+			continue
+		}
+		if err := os.Symlink(target, path); err != nil {
+			return gerror.NewFromError(ErrCreateSymlink, err)
+		}
+	}
+	return nil
+}
+
+/*
+	Stats implements kernel.ResourceReporter and reports the disk usage of the read-write layer of the
+	generated root filesystem as StatRwLayerBytes. The read-write layer is found from the manifest of the
+	root filesystem.
+*/
+func (c *Controller) Stats(rCtx kernel.ResourceContext) (kernel.ResourceStats, error) {
+	root := c.Root()
+	if root == "" {
+		return nil, gerror.New(ErrNotInitialised, "Root filesystem has not been generated")
+	}
+	manifests, gerr := c.rfs.List()
+	if gerr != nil {
+		return nil, gerror.NewFromError(ErrStats, gerr)
+	}
+	for _, m := range manifests {
+		if m.Root == root {
+			usage, err := diskUsage(m.RwLayer)
+			if err != nil {
+				return nil, gerror.NewFromError(ErrStats, err)
+			}
+			return kernel.ResourceStats{StatRwLayerBytes: usage}, nil
+		}
+	}
+	return nil, gerror.Newf(ErrStats, "No manifest found for root filesystem %q", root)
+}
+
+/*
+	diskUsage returns the number of bytes of disk allocated to the files in the given directory tree. Files
+	with several hard links are counted once and files on other devices, such as mount points, are ignored.
+*/
+func diskUsage(dir string) (uint64, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+	var usage uint64
+	var dev uint64
+	seen := make(map[inode]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*trueSyscall.Stat_t)
+		if !ok {
+			usage += uint64(info.Size())
+			return nil
+		}
+		if path == dir {
+			dev = uint64(st.Dev)
+		} else if uint64(st.Dev) != dev {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if st.Nlink > 1 && !info.IsDir() {
+			id := inode{uint64(st.Dev), uint64(st.Ino)}
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+		usage += uint64(st.Blocks) * 512
+		return nil
+	})
+	return usage, err
+}
+
+// Teardown removes the generated root filesystem.
+func (c *Controller) Teardown(rCtx kernel.ResourceContext) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.root == "" {
+		return nil
+	}
+	if glog.V(1) {
+		glog.Infof("Teardown: removing root filesystem %q", c.root)
+	}
+	if gerr := c.rfs.Remove(c.root); gerr != nil {
+		return gerror.NewFromError(ErrRemove, gerr)
+	}
+	c.root = ""
+	return nil
+}
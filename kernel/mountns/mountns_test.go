@@ -0,0 +1,373 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mountns_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/mountns"
+	"github.com/cf-guardian/guardian/kernel/rootfs"
+	"github.com/cf-guardian/guardian/kernel/rootfs/mock_rootfs"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
+	"github.com/cf-guardian/guardian/kernel/userns"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	prototype   = "/test-prototype"
+	hostNS      = "mnt:[1]"
+	containerNS = "mnt:[2]"
+)
+
+func TestInit(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(hostNS, nil)
+	mockRootFS.EXPECT().Generate(prototype).Return("/test-root", nil)
+	c := mountns.NewWithOptions(mockRootFS, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS})
+	rCtx := kernel.CreateResourceContext(prototype)
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if root := c.Root(); root != "/test-root" {
+		t.Errorf("Root was %q", root)
+	}
+	if root := rCtx.GetRootFS(); root != "/test-root" {
+		t.Errorf("Root filesystem of resource context was %q", root)
+	}
+	var root string
+	if ok, err := rCtx.GetConfig(mountns.RootKey, &root); !ok || err != nil || root != "/test-root" {
+		t.Errorf("Published root was %q (%v, %v)", root, ok, err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.MountNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestInitWithMapping(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(hostNS, nil)
+	mapping := userns.Mapping{
+		Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+		Gids: []syscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 65536}},
+	}
+	mockRootFS.EXPECT().GenerateWithOptions(prototype, rootfs.GenerateOptions{Uids: mapping.Uids, Gids: mapping.Gids}).Return("/test-root", nil)
+	c := mountns.NewWithOptions(mockRootFS, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS})
+	rCtx := kernel.CreateResourceContext(prototype)
+	if err := rCtx.SetConfig(userns.MappingKey, mapping); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if root := rCtx.GetRootFS(); root != "/test-root" {
+		t.Errorf("Root filesystem of resource context was %q", root)
+	}
+}
+
+func TestInitFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(hostNS, nil)
+	mockRootFS.EXPECT().Generate(prototype).Return("", gerror.New(rootfs.ErrBindMountRoot, "an error"))
+	c := mountns.NewWithOptions(mockRootFS, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS})
+	rCtx := kernel.CreateResourceContext(prototype)
+	err := c.Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrGenerate) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	if root := c.Root(); root != "" {
+		t.Errorf("Root was %q", root)
+	}
+	if root := rCtx.GetRootFS(); root != prototype {
+		t.Errorf("Root filesystem of resource context was %q", root)
+	}
+}
+
+func TestInitNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// No root filesystem is generated.
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return("", errors.New("an error"))
+	c := mountns.NewWithOptions(mockRootFS, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS})
+	err := c.Init(kernel.CreateResourceContext(prototype))
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestEnter(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	_, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, root, mountns.Options{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(containerNS, nil)
+
+	calls := []*gomock.Call{mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true)}
+	for _, m := range mountns.DefaultMounts() {
+		calls = append(calls, mockSyscallFS.EXPECT().Mount(m.Source, filepath.Join(root, m.Target), m.FsType, m.Flags, m.Data))
+	}
+	for _, device := range mountns.DefaultDevices() {
+		calls = append(calls, mockSyscallFS.EXPECT().Mount(filepath.Join("/dev", device),
+			filepath.Join(root, "dev", device), "", syscall.MountBind, ""))
+	}
+	calls = append(calls,
+		mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true),
+		mockSyscallFS.EXPECT().Mount(root, root, "", syscall.MountBind|syscall.MountRec, ""),
+		mockSyscallFS.EXPECT().Chdir(root),
+		mockSyscallFS.EXPECT().PivotRoot(".", "."),
+		mockSyscallFS.EXPECT().UnmountWithOptions(".", syscall.UnmountOptions{Flags: syscall.UnmountDetach}),
+		mockSyscallFS.EXPECT().Chdir("/"))
+	gomock.InOrder(calls...)
+
+	// Enter is called on a new controller, as in the container's process.
+	if err := mountns.NewWithOptions(nil, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS}).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if root := rCtx.GetRootFS(); root != "/" {
+		t.Errorf("Root filesystem of resource context was %q after Enter", root)
+	}
+
+	for _, m := range mountns.DefaultMounts() {
+		if !test_support.FileExists(filepath.Join(root, m.Target)) {
+			t.Errorf("Mount point %q was not created", m.Target)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(root, "dev", "ptmx")); err != nil || target != "pts/ptmx" {
+		t.Errorf("Incorrect ptmx symbolic link (%q, %v)", target, err)
+	}
+}
+
+func TestEnterNotInitialised(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c := mountns.NewWithOptions(mockRootFS, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS})
+	err := c.Enter(kernel.CreateResourceContext(prototype))
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestEnterNotIsolated(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// The mounts of the runner's mount namespace are not changed.
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(hostNS, nil)
+
+	err := c.Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrNotIsolated) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestEnterNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return("", errors.New("an error"))
+
+	err := c.Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestEnterMountFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	mounts := []mountns.Mount{
+		{"proc", "proc", "proc", 0, ""},
+		{"tmpfs", "tmp", "tmpfs", 0, ""},
+		{"sysfs", "sys", "sysfs", 0, ""},
+	}
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, root, mountns.Options{Mounts: mounts})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(containerNS, nil)
+
+	gomock.InOrder(
+		mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true),
+		mockSyscallFS.EXPECT().Mount("proc", filepath.Join(root, "proc"), "proc", syscall.MountFlags(0), ""),
+		mockSyscallFS.EXPECT().Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", syscall.MountFlags(0), "").Return(errors.New("an error")),
+	)
+
+	err := c.Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrMount) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestEnterPropagationFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(containerNS, nil)
+	mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true).Return(errors.New("an error"))
+
+	err := c.Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrPropagation) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestEnterRootFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, root, mountns.Options{Mounts: []mountns.Mount{}, Devices: []string{}})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(containerNS, nil)
+
+	gomock.InOrder(
+		mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true),
+		mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true),
+		mockSyscallFS.EXPECT().Mount(root, root, "", syscall.MountBind|syscall.MountRec, ""),
+		mockSyscallFS.EXPECT().Chdir(root),
+		mockSyscallFS.EXPECT().PivotRoot(".", ".").Return(errors.New("an error")),
+	)
+
+	err := c.Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrEnterRoot) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockRootFS.EXPECT().Remove("/test-root")
+
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+	if root := c.Root(); root != "" {
+		t.Errorf("Root was %q after Teardown", root)
+	}
+	// A second teardown does nothing.
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownFailure(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockRootFS.EXPECT().Remove("/test-root").Return(gerror.New(rootfs.ErrUnmountRoot, "an error"))
+
+	err := c.Teardown(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrRemove) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rwLayer := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, rwLayer)
+	if err := ioutil.WriteFile(filepath.Join(rwLayer, "data"), make([]byte, 64*1024), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockRootFS.EXPECT().List().Return([]rootfs.Manifest{
+		{Root: "/other-root", RwLayer: "/other-rw"},
+		{Root: "/test-root", RwLayer: rwLayer},
+	}, nil)
+
+	stats, err := c.Stats(rCtx)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if usage := stats[mountns.StatRwLayerBytes]; usage < 64*1024 {
+		t.Errorf("Disk usage of read-write layer was %d", usage)
+	}
+}
+
+func TestStatsNoManifest(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockRootFS, mockSyscallFS, mockSyscallNS, "/test-root", mountns.Options{})
+	mockRootFS.EXPECT().List().Return([]rootfs.Manifest{{Root: "/other-root", RwLayer: "/other-rw"}}, nil)
+
+	_, err := c.Stats(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrStats) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestStatsNotInitialised(t *testing.T) {
+	mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, err := mountns.NewWithOptions(mockRootFS, mockSyscallFS, mountns.Options{SyscallNS: mockSyscallNS}).Stats(kernel.CreateResourceContext(prototype))
+	if err == nil || !err.(gerror.Gerror).EqualTag(mountns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func initController(t *testing.T, mockRootFS *mock_rootfs.MockRootFS, mockSyscallFS *mock_syscall.MockSyscallFS,
+	mockSyscallNS *mock_syscall.MockSyscallNS, root string, opts mountns.Options) (*mountns.Controller, kernel.ResourceContext) {
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.MountNS).Return(hostNS, nil)
+	mockRootFS.EXPECT().Generate(prototype).Return(root, nil)
+	opts.SyscallNS = mockSyscallNS
+	c := mountns.NewWithOptions(mockRootFS, mockSyscallFS, opts)
+	rCtx := kernel.CreateResourceContext(prototype)
+	if err := c.Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return c, rCtx
+}
+
+func setupMocks(t *testing.T) (*gomock.Controller, *mock_rootfs.MockRootFS, *mock_syscall.MockSyscallFS, *mock_syscall.MockSyscallNS) {
+	mockCtrl := gomock.NewController(t)
+	mockRootFS := mock_rootfs.NewMockRootFS(mockCtrl)
+	mockSyscallFS := mock_syscall.NewMockSyscallFS(mockCtrl)
+	mockSyscallNS := mock_syscall.NewMockSyscallNS(mockCtrl)
+	return mockCtrl, mockRootFS, mockSyscallFS, mockSyscallNS
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package netlink provides an abstraction of the Linux routing netlink protocol, which configures network
+devices, addresses, and routes, to support testing.
+*/
+package netlink
+
+import "net"
+
+// ErrorId is used for error ids relating to the netlink package.
+type ErrorId int
+
+const (
+	ErrLinkNotFound ErrorId = iota // there is no network device with a given name
+)
+
+// A Link describes a network device.
+type Link struct {
+	Index  int    // the index of the device in its network namespace
+	Name   string // the name of the device, such as "eth0"
+	Kind   string // the kind of a virtual device, such as "veth" or "bridge", or empty for other devices
+	Master int    // the index of the device to which the device is attached, such as a bridge, or zero
+	MTU    int    // the maximum transmission unit
+	Up     bool   // true if and only if the device is administratively up
+}
+
+/*
+	A Route describes an entry of the main routing table. The destination is nil for a default route. The
+	gateway is nil for a route to a directly connected network.
+*/
+type Route struct {
+	Destination *net.IPNet
+	Gateway     net.IP
+}
+
+/*
+	The Netlink interface configures the network devices, addresses, and routes of the network namespace of
+	the calling thread. Network devices are identified by name.
+
+	Each operation uses the network namespace the calling thread is in when the operation is called, so a
+	caller which changes the network namespace of its thread should lock the calling goroutine to the thread,
+	using runtime.LockOSThread.
+*/
+type Netlink interface {
+	/*
+		Returns the network device with the given name. If there is no such device, the error has the tag
+		ErrLinkNotFound.
+	*/
+	LinkByName(name string) (Link, error)
+
+	/*
+		Creates a pair of connected virtual ethernet devices with the given names. The peer device is created
+		in the network namespace of the process with the given process id or, if the process id is zero, in
+		the calling thread's network namespace. Both devices are initially down.
+	*/
+	AddVethPair(name string, peerName string, peerPid int) error
+
+	/*
+		Creates a bridge device with the given name. The bridge is initially down.
+	*/
+	AddBridge(name string) error
+
+	/*
+		Deletes the network device with the given name. Deleting either device of a virtual ethernet pair
+		deletes both. If there is no such device, the error has the tag ErrLinkNotFound.
+	*/
+	DeleteLink(name string) error
+
+	/*
+		Brings the network device with the given name up.
+	*/
+	SetLinkUp(name string) error
+
+	/*
+		Attaches the network device with the given name to the given master device, such as a bridge.
+	*/
+	SetLinkMaster(name string, master string) error
+
+	/*
+		Assigns the given IPv4 or IPv6 address, with the prefix length of its mask, to the network device
+		with the given name. IPv6 addresses are assigned without duplicate address detection, so they can
+		be used immediately.
+	*/
+	AddAddress(name string, addr net.IPNet) error
+
+	/*
+		Returns the IPv4 and IPv6 addresses assigned to the network device with the given name.
+	*/
+	Addresses(name string) ([]net.IPNet, error)
+
+	/*
+		Adds a default route via the given gateway, which is an IPv4 or IPv6 address, through the network
+		device with the given name to the main routing table.
+	*/
+	AddDefaultRoute(name string, gateway net.IP) error
+
+	/*
+		Returns the IPv4 and IPv6 routes of the main routing table through the network device with the
+		given name.
+	*/
+	Routes(name string) ([]Route, error)
+}
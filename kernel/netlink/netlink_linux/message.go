@@ -0,0 +1,304 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package netlink_linux
+
+import (
+	"encoding/binary"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/netlink"
+	"net"
+	"strings"
+	"sync/atomic"
+	trueSyscall "syscall"
+	"unsafe"
+)
+
+// receiveBufferSize is the size of the buffer into which replies are received, which exceeds the largest message the kernel sends.
+const receiveBufferSize = 65536
+
+// nativeEndian is the byte order of the machine, which netlink messages use.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// sequence is the sequence number of the most recent request.
+var sequence uint32
+
+// A request is a netlink request message.
+type request struct {
+	msgType uint16 // the message type, such as RTM_NEWLINK
+	flags   uint16 // flags other than NLM_F_REQUEST, such as NLM_F_ACK
+	header  []byte // the family-specific header, such as an ifinfomsg
+	attrs   []attr
+}
+
+// An attr is a routing attribute whose value is either the given bytes or the given nested attributes.
+type attr struct {
+	attrType uint16
+	value    []byte
+	nested   []attr
+}
+
+// encode returns the request as a message with the given sequence number.
+func (r *request) encode(seq uint32) []byte {
+	body := append(append([]byte{}, r.header...), encodeAttrs(r.attrs)...)
+	msg := make([]byte, trueSyscall.NLMSG_HDRLEN, trueSyscall.NLMSG_HDRLEN+len(body))
+	nativeEndian.PutUint32(msg[0:4], uint32(trueSyscall.NLMSG_HDRLEN+len(body)))
+	nativeEndian.PutUint16(msg[4:6], r.msgType)
+	nativeEndian.PutUint16(msg[6:8], r.flags|trueSyscall.NLM_F_REQUEST)
+	nativeEndian.PutUint32(msg[8:12], seq)
+	return append(msg, body...)
+}
+
+// encode returns the attribute, including its header, padded to the attribute alignment.
+func (a attr) encode() []byte {
+	value := a.value
+	if a.nested != nil {
+		value = encodeAttrs(a.nested)
+	}
+	length := trueSyscall.SizeofRtAttr + len(value)
+	b := make([]byte, rtaAlign(length))
+	nativeEndian.PutUint16(b[0:2], uint16(length))
+	nativeEndian.PutUint16(b[2:4], a.attrType)
+	copy(b[trueSyscall.SizeofRtAttr:], value)
+	return b
+}
+
+func encodeAttrs(attrs []attr) []byte {
+	b := []byte{}
+	for _, a := range attrs {
+		b = append(b, a.encode()...)
+	}
+	return b
+}
+
+func uint32Attr(attrType uint16, value uint32) attr {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, value)
+	return attr{attrType: attrType, value: b}
+}
+
+func rtaAlign(length int) int {
+	return (length + trueSyscall.RTA_ALIGNTO - 1) &^ (trueSyscall.RTA_ALIGNTO - 1)
+}
+
+/*
+	execute sends the given request on a new routing netlink socket, which is in the network namespace of
+	the calling thread, and returns the replies. If the request has the NLM_F_DUMP flag, the replies are
+	those which precede the end of the dump. If the request has the NLM_F_ACK flag, the replies are those
+	which precede the acknowledgement. Otherwise the reply is a single message. A failed request returns the
+	kernel's error number.
+*/
+func execute(r *request) ([]trueSyscall.NetlinkMessage, error) {
+	fd, err := trueSyscall.Socket(trueSyscall.AF_NETLINK, trueSyscall.SOCK_RAW|trueSyscall.SOCK_CLOEXEC, trueSyscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer trueSyscall.Close(fd)
+
+	seq := atomic.AddUint32(&sequence, 1)
+	kernel := &trueSyscall.SockaddrNetlink{Family: trueSyscall.AF_NETLINK}
+	if err := trueSyscall.Sendto(fd, r.encode(seq), 0, kernel); err != nil {
+		return nil, err
+	}
+
+	replies := []trueSyscall.NetlinkMessage{}
+	for {
+		// The replies refer to the buffer, so each receive needs a new one.
+		buf := make([]byte, receiveBufferSize)
+		n, _, err := trueSyscall.Recvfrom(fd, buf, 0)
+		if err == trueSyscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := trueSyscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, gerror.NewFromError(ErrInvalidMessage, err)
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != seq {
+				continue
+			}
+			switch msg.Header.Type {
+			case trueSyscall.NLMSG_DONE:
+				return replies, nil
+			case trueSyscall.NLMSG_ERROR:
+				if len(msg.Data) < 4 {
+					return nil, gerror.New(ErrInvalidMessage, "Truncated netlink error message")
+				}
+				if errno := int32(nativeEndian.Uint32(msg.Data[0:4])); errno != 0 {
+					return nil, trueSyscall.Errno(-errno)
+				}
+				return replies, nil
+			default:
+				replies = append(replies, msg)
+				if r.flags&(trueSyscall.NLM_F_DUMP|trueSyscall.NLM_F_ACK) == 0 {
+					return replies, nil
+				}
+			}
+		}
+	}
+}
+
+// ifInfomsg returns an ifinfomsg header for the network device with the given index, or for a new device if the index is zero.
+func ifInfomsg(index int, flags uint32, change uint32) []byte {
+	b := make([]byte, trueSyscall.SizeofIfInfomsg)
+	nativeEndian.PutUint32(b[4:8], uint32(index))
+	nativeEndian.PutUint32(b[8:12], flags)
+	nativeEndian.PutUint32(b[12:16], change)
+	return b
+}
+
+// ifAddrmsg returns an ifaddrmsg header for an address of the given family and prefix length.
+func ifAddrmsg(family uint8, prefixLen uint8, flags uint8, index int) []byte {
+	b := make([]byte, trueSyscall.SizeofIfAddrmsg)
+	b[0] = family
+	b[1] = prefixLen
+	b[2] = flags
+	b[3] = trueSyscall.RT_SCOPE_UNIVERSE
+	nativeEndian.PutUint32(b[4:8], uint32(index))
+	return b
+}
+
+// rtMsg returns an rtmsg header for a unicast route of the main routing table with the given family and destination prefix length.
+func rtMsg(family uint8, dstLen uint8) []byte {
+	b := make([]byte, trueSyscall.SizeofRtMsg)
+	b[0] = family
+	b[1] = dstLen
+	b[4] = trueSyscall.RT_TABLE_MAIN
+	b[5] = trueSyscall.RTPROT_BOOT
+	b[6] = trueSyscall.RT_SCOPE_UNIVERSE
+	b[7] = trueSyscall.RTN_UNICAST
+	return b
+}
+
+/*
+	parseAttrs returns the values of the routing attributes in the given data, keyed by attribute type. The
+	nested and byte order flags of the attribute types are ignored.
+*/
+func parseAttrs(data []byte) (map[uint16][]byte, gerror.Gerror) {
+	attrs := map[uint16][]byte{}
+	for len(data) >= trueSyscall.SizeofRtAttr {
+		length := int(nativeEndian.Uint16(data[0:2]))
+		if length < trueSyscall.SizeofRtAttr || length > len(data) {
+			return nil, gerror.Newf(ErrInvalidMessage, "Invalid routing attribute length %d", length)
+		}
+		attrType := nativeEndian.Uint16(data[2:4]) & 0x3fff
+		attrs[attrType] = data[trueSyscall.SizeofRtAttr:length]
+		if rtaAlign(length) >= len(data) {
+			break
+		}
+		data = data[rtaAlign(length):]
+	}
+	return attrs, nil
+}
+
+// parseLink parses the body of an RTM_NEWLINK message.
+func parseLink(data []byte) (netlink.Link, gerror.Gerror) {
+	if len(data) < trueSyscall.SizeofIfInfomsg {
+		return netlink.Link{}, gerror.New(ErrInvalidMessage, "Truncated link message")
+	}
+	link := netlink.Link{
+		Index: int(int32(nativeEndian.Uint32(data[4:8]))),
+		Up:    nativeEndian.Uint32(data[8:12])&trueSyscall.IFF_UP != 0,
+	}
+	attrs, gerr := parseAttrs(data[trueSyscall.SizeofIfInfomsg:])
+	if gerr != nil {
+		return netlink.Link{}, gerr
+	}
+	link.Name = cString(attrs[trueSyscall.IFLA_IFNAME])
+	if mtu := attrs[trueSyscall.IFLA_MTU]; len(mtu) == 4 {
+		link.MTU = int(nativeEndian.Uint32(mtu))
+	}
+	if master := attrs[trueSyscall.IFLA_MASTER]; len(master) == 4 {
+		link.Master = int(nativeEndian.Uint32(master))
+	}
+	if linkInfo, ok := attrs[trueSyscall.IFLA_LINKINFO]; ok {
+		info, gerr := parseAttrs(linkInfo)
+		if gerr != nil {
+			return netlink.Link{}, gerr
+		}
+		link.Kind = cString(info[ifla_info_kind])
+	}
+	return link, nil
+}
+
+// parseAddress parses the body of an RTM_NEWADDR message and returns the address and the index of its network device.
+func parseAddress(data []byte) (net.IPNet, int, gerror.Gerror) {
+	if len(data) < trueSyscall.SizeofIfAddrmsg {
+		return net.IPNet{}, 0, gerror.New(ErrInvalidMessage, "Truncated address message")
+	}
+	attrs, gerr := parseAttrs(data[trueSyscall.SizeofIfAddrmsg:])
+	if gerr != nil {
+		return net.IPNet{}, 0, gerr
+	}
+	ip, ok := attrs[trueSyscall.IFA_LOCAL]
+	if !ok {
+		ip = attrs[trueSyscall.IFA_ADDRESS]
+	}
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+		return net.IPNet{}, 0, gerror.Newf(ErrInvalidMessage, "Invalid address of length %d", len(ip))
+	}
+	addr := net.IPNet{IP: net.IP(append([]byte{}, ip...)), Mask: net.CIDRMask(int(data[1]), len(ip)*8)}
+	return addr, int(nativeEndian.Uint32(data[4:8])), nil
+}
+
+/*
+	parseRoute parses the body of an RTM_NEWROUTE message and returns the route, the index of its output
+	network device, and whether it is a unicast route of the main routing table.
+*/
+func parseRoute(data []byte) (netlink.Route, int, bool, gerror.Gerror) {
+	if len(data) < trueSyscall.SizeofRtMsg {
+		return netlink.Route{}, 0, false, gerror.New(ErrInvalidMessage, "Truncated route message")
+	}
+	attrs, gerr := parseAttrs(data[trueSyscall.SizeofRtMsg:])
+	if gerr != nil {
+		return netlink.Route{}, 0, false, gerr
+	}
+	table := uint32(data[4])
+	if t := attrs[trueSyscall.RTA_TABLE]; len(t) == 4 {
+		table = nativeEndian.Uint32(t)
+	}
+	var route netlink.Route
+	if dst, ok := attrs[trueSyscall.RTA_DST]; ok {
+		route.Destination = &net.IPNet{IP: net.IP(append([]byte{}, dst...)), Mask: net.CIDRMask(int(data[1]), len(dst)*8)}
+	}
+	if gateway, ok := attrs[trueSyscall.RTA_GATEWAY]; ok {
+		route.Gateway = net.IP(append([]byte{}, gateway...))
+	}
+	index := 0
+	if oif := attrs[trueSyscall.RTA_OIF]; len(oif) == 4 {
+		index = int(nativeEndian.Uint32(oif))
+	}
+	return route, index, table == trueSyscall.RT_TABLE_MAIN && data[7] == trueSyscall.RTN_UNICAST, nil
+}
+
+// cString returns the given bytes up to any terminating null byte.
+func cString(b []byte) string {
+	s := string(b)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,304 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package netlink_linux implements the netlink package for Linux using routing netlink sockets.
+*/
+package netlink_linux
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/netlink"
+	"github.com/golang/glog"
+	"net"
+	trueSyscall "syscall"
+)
+
+// ImplErrorId is used for error ids relating to the implementation of this package.
+type ImplErrorId int
+
+const (
+	ErrInvalidName    ImplErrorId = iota // a network device name is empty or too long
+	ErrInvalidAddress                    // an address is neither an IPv4 nor an IPv6 address or has an invalid mask
+	ErrInvalidMessage                    // a netlink message from the kernel could not be parsed
+)
+
+// ifNameSize is the size of a network device name, including the terminating null byte.
+const ifNameSize = 16
+
+// Link attributes which the standard syscall package does not define.
+const (
+	ifla_info_kind = 1
+	ifla_info_data = 2
+	veth_info_peer = 1
+)
+
+type nlWrapper struct{}
+
+// Constructs a new Netlink instance.
+func New() netlink.Netlink {
+	return &nlWrapper{}
+}
+
+func (_ *nlWrapper) LinkByName(name string) (netlink.Link, error) {
+	nameAttr, gerr := ifName(trueSyscall.IFLA_IFNAME, name)
+	if gerr != nil {
+		return netlink.Link{}, gerr
+	}
+	replies, err := execute(&request{
+		msgType: trueSyscall.RTM_GETLINK,
+		header:  ifInfomsg(0, 0, 0),
+		attrs:   []attr{nameAttr},
+	})
+	if err == trueSyscall.ENODEV {
+		return netlink.Link{}, gerror.Newf(netlink.ErrLinkNotFound, "Network device %q not found", name)
+	}
+	if err != nil {
+		return netlink.Link{}, err
+	}
+	if len(replies) != 1 || replies[0].Header.Type != trueSyscall.RTM_NEWLINK {
+		return netlink.Link{}, gerror.Newf(ErrInvalidMessage, "Unexpected reply to request for network device %q", name)
+	}
+	return parseLink(replies[0].Data)
+}
+
+func (_ *nlWrapper) AddVethPair(name string, peerName string, peerPid int) error {
+	if glog.V(1) {
+		glog.Infof("AddVethPair(%q, %q, %d)", name, peerName, peerPid)
+	}
+	peerAttr, gerr := ifName(trueSyscall.IFLA_IFNAME, peerName)
+	if gerr != nil {
+		return gerr
+	}
+	peerAttrs := []attr{peerAttr}
+	if peerPid != 0 {
+		peerAttrs = append(peerAttrs, uint32Attr(trueSyscall.IFLA_NET_NS_PID, uint32(peerPid)))
+	}
+	peer := attr{attrType: veth_info_peer, value: append(ifInfomsg(0, 0, 0), encodeAttrs(peerAttrs)...)}
+	return addLink(name, "veth", attr{attrType: ifla_info_data, nested: []attr{peer}})
+}
+
+func (_ *nlWrapper) AddBridge(name string) error {
+	if glog.V(1) {
+		glog.Infof("AddBridge(%q)", name)
+	}
+	return addLink(name, "bridge")
+}
+
+func (nl *nlWrapper) DeleteLink(name string) error {
+	if glog.V(1) {
+		glog.Infof("DeleteLink(%q)", name)
+	}
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	_, err = execute(&request{
+		msgType: trueSyscall.RTM_DELLINK,
+		flags:   trueSyscall.NLM_F_ACK,
+		header:  ifInfomsg(link.Index, 0, 0),
+	})
+	if err == trueSyscall.ENODEV {
+		return gerror.Newf(netlink.ErrLinkNotFound, "Network device %q not found", name)
+	}
+	return err
+}
+
+func (nl *nlWrapper) SetLinkUp(name string) error {
+	if glog.V(1) {
+		glog.Infof("SetLinkUp(%q)", name)
+	}
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	_, err = execute(&request{
+		msgType: trueSyscall.RTM_NEWLINK,
+		flags:   trueSyscall.NLM_F_ACK,
+		header:  ifInfomsg(link.Index, trueSyscall.IFF_UP, trueSyscall.IFF_UP),
+	})
+	return err
+}
+
+func (nl *nlWrapper) SetLinkMaster(name string, master string) error {
+	if glog.V(1) {
+		glog.Infof("SetLinkMaster(%q, %q)", name, master)
+	}
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	masterLink, err := nl.LinkByName(master)
+	if err != nil {
+		return err
+	}
+	_, err = execute(&request{
+		msgType: trueSyscall.RTM_NEWLINK,
+		flags:   trueSyscall.NLM_F_ACK,
+		header:  ifInfomsg(link.Index, 0, 0),
+		attrs:   []attr{uint32Attr(trueSyscall.IFLA_MASTER, uint32(masterLink.Index))},
+	})
+	return err
+}
+
+func (nl *nlWrapper) AddAddress(name string, addr net.IPNet) error {
+	if glog.V(1) {
+		glog.Infof("AddAddress(%q, %s)", name, &addr)
+	}
+	family, ip, gerr := ipFamily(addr.IP)
+	if gerr != nil {
+		return gerr
+	}
+	ones, bits := addr.Mask.Size()
+	if bits != len(ip)*8 {
+		return gerror.Newf(ErrInvalidAddress, "Invalid mask of address %s", &addr)
+	}
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	var flags uint8
+	if family == trueSyscall.AF_INET6 {
+		flags = trueSyscall.IFA_F_NODAD
+	}
+	_, err = execute(&request{
+		msgType: trueSyscall.RTM_NEWADDR,
+		flags:   trueSyscall.NLM_F_CREATE | trueSyscall.NLM_F_EXCL | trueSyscall.NLM_F_ACK,
+		header:  ifAddrmsg(family, uint8(ones), flags, link.Index),
+		attrs: []attr{
+			{attrType: trueSyscall.IFA_LOCAL, value: ip},
+			{attrType: trueSyscall.IFA_ADDRESS, value: ip},
+		},
+	})
+	return err
+}
+
+func (nl *nlWrapper) Addresses(name string) ([]net.IPNet, error) {
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := execute(&request{
+		msgType: trueSyscall.RTM_GETADDR,
+		flags:   trueSyscall.NLM_F_DUMP,
+		header:  ifAddrmsg(trueSyscall.AF_UNSPEC, 0, 0, 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	addrs := []net.IPNet{}
+	for _, reply := range replies {
+		if reply.Header.Type != trueSyscall.RTM_NEWADDR {
+			continue
+		}
+		addr, index, gerr := parseAddress(reply.Data)
+		if gerr != nil {
+			return nil, gerr
+		}
+		if index == link.Index {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+func (nl *nlWrapper) AddDefaultRoute(name string, gateway net.IP) error {
+	if glog.V(1) {
+		glog.Infof("AddDefaultRoute(%q, %s)", name, gateway)
+	}
+	family, ip, gerr := ipFamily(gateway)
+	if gerr != nil {
+		return gerr
+	}
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	_, err = execute(&request{
+		msgType: trueSyscall.RTM_NEWROUTE,
+		flags:   trueSyscall.NLM_F_CREATE | trueSyscall.NLM_F_EXCL | trueSyscall.NLM_F_ACK,
+		header:  rtMsg(family, 0),
+		attrs: []attr{
+			{attrType: trueSyscall.RTA_GATEWAY, value: ip},
+			uint32Attr(trueSyscall.RTA_OIF, uint32(link.Index)),
+		},
+	})
+	return err
+}
+
+func (nl *nlWrapper) Routes(name string) ([]netlink.Route, error) {
+	link, err := nl.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := execute(&request{
+		msgType: trueSyscall.RTM_GETROUTE,
+		flags:   trueSyscall.NLM_F_DUMP,
+		header:  rtMsg(trueSyscall.AF_UNSPEC, 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	routes := []netlink.Route{}
+	for _, reply := range replies {
+		if reply.Header.Type != trueSyscall.RTM_NEWROUTE {
+			continue
+		}
+		route, index, main, gerr := parseRoute(reply.Data)
+		if gerr != nil {
+			return nil, gerr
+		}
+		if main && index == link.Index {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+// addLink creates a network device with the given name and kind, and with any given kind-specific data.
+func addLink(name string, kind string, data ...attr) error {
+	nameAttr, gerr := ifName(trueSyscall.IFLA_IFNAME, name)
+	if gerr != nil {
+		return gerr
+	}
+	linkInfo := append([]attr{{attrType: ifla_info_kind, value: []byte(kind)}}, data...)
+	_, err := execute(&request{
+		msgType: trueSyscall.RTM_NEWLINK,
+		flags:   trueSyscall.NLM_F_CREATE | trueSyscall.NLM_F_EXCL | trueSyscall.NLM_F_ACK,
+		header:  ifInfomsg(0, 0, 0),
+		attrs:   []attr{nameAttr, {attrType: trueSyscall.IFLA_LINKINFO, nested: linkInfo}},
+	})
+	return err
+}
+
+// ifName returns an attribute of the given type with the given network device name, which is checked.
+func ifName(attrType uint16, name string) (attr, gerror.Gerror) {
+	if name == "" || len(name) >= ifNameSize {
+		return attr{}, gerror.Newf(ErrInvalidName, "Invalid network device name %q", name)
+	}
+	return attr{attrType: attrType, value: append([]byte(name), 0)}, nil
+}
+
+// ipFamily returns the address family of the given IP address and the address in the family's form.
+func ipFamily(ip net.IP) (uint8, []byte, gerror.Gerror) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return trueSyscall.AF_INET, ip4, nil
+	}
+	if len(ip) == net.IPv6len {
+		return trueSyscall.AF_INET6, ip, nil
+	}
+	return 0, nil, gerror.Newf(ErrInvalidAddress, "Invalid IP address %v", ip)
+}
@@ -0,0 +1,351 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package netns provides a resource controller which gives a container a network namespace of its own with a
+loopback device and, optionally, a virtual ethernet device connected to the host.
+*/
+package netns
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/netlink"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"net"
+	"strings"
+)
+
+// ErrorId is used for error ids relating to the netns package.
+type ErrorId int
+
+const (
+	ErrConfig         ErrorId = iota // the network configuration in the resource context could not be decoded
+	ErrInvalidConfig                 // the network configuration is invalid
+	ErrNamespaceId                   // the network namespace of a process could not be determined
+	ErrPublish                       // the network configuration could not be published in the resource context
+	ErrAddVeth                       // the virtual ethernet pair could not be created
+	ErrBridge                        // the host's device could not be attached to the bridge
+	ErrNotInitialised                // the controller has not successfully initialised
+	ErrNotIsolated                   // the container's process is not in a network namespace of its own
+	ErrLinkUp                        // a network device could not be brought up
+	ErrAddAddress                    // an address could not be assigned to the container's device
+	ErrAddRoute                      // a default route could not be added
+	ErrDeleteVeth                    // the virtual ethernet pair could not be deleted
+)
+
+/*
+	ConfigKey is the resource context configuration key of the container's Config. Init publishes the
+	configuration which the container uses, including any defaults, under the same key.
+*/
+const ConfigKey = "netns.config"
+
+// hostNamespaceKey is the key under which Init publishes the identifier of the runner's network namespace.
+const hostNamespaceKey = "netns.host"
+
+/*
+	createdKey is the key under which Started records that it created the virtual ethernet pair, so that
+	Teardown does not delete a device of the same name which belongs to another container or to the host.
+*/
+const createdKey = "netns.created"
+
+// DefaultContainerInterface is the name of the container's virtual ethernet device unless the configuration specifies a name.
+const DefaultContainerInterface = "eth0"
+
+// hostInterfacePrefix prefixes the container's handle in the default name of the host's virtual ethernet device.
+const hostInterfacePrefix = "gv-"
+
+// maxInterfaceName is the maximum length of a network device name.
+const maxInterfaceName = 15
+
+// loopback is the name of the loopback device.
+const loopback = "lo"
+
+// Config is the network configuration of a container.
+type Config struct {
+	Veth *Veth // a virtual ethernet pair connecting the container to the host, or nil for a loopback device only
+}
+
+/*
+	Veth configures a pair of virtual ethernet devices, one in the runner's network namespace and the other
+	in the container's.
+*/
+type Veth struct {
+	HostInterface      string   // the name of the host's device, or empty for a name derived from the container's handle
+	ContainerInterface string   // the name of the container's device, or empty for DefaultContainerInterface
+	Bridge             string   // the bridge to which the host's device is attached, or empty to leave it unattached
+	Addresses          []string // IPv4 and IPv6 addresses of the container's device in CIDR notation, such as "10.0.0.2/24"
+	Gateways           []string // the gateways of default routes, at most one IPv4 and one IPv6 address
+}
+
+/*
+	A Controller is a resource controller which creates the container's process in a new network namespace
+	and brings up its loopback device.
+
+	If the configuration has a Veth, Started creates a virtual ethernet pair, with the container's device in
+	the container's network namespace, attaches the host's device to the bridge, if any, and brings the host's
+	device up. A bridge which does not exist is created and brought up. It is not deleted when the container
+	is torn down, since other containers may be attached to it. Enter assigns the addresses to the container's
+	device, brings it up, and adds the default routes.
+
+	Started, Enter, and Teardown use the network namespace of the calling thread, so the runner's threads
+	must be in the runner's network namespace.
+*/
+type Controller struct {
+	nl netlink.Netlink
+	sc syscall.SyscallNS
+}
+
+var (
+	_ kernel.ResourceStarter  = &Controller{}
+	_ kernel.ResourceEnterer  = &Controller{}
+	_ kernel.ResourceReleaser = &Controller{}
+)
+
+// Creates a new Controller which uses the given Netlink and SyscallNS instances.
+func New(nl netlink.Netlink, sc syscall.SyscallNS) *Controller {
+	return &Controller{nl: nl, sc: sc}
+}
+
+/*
+	Init determines and publishes the container's network configuration and adds a network namespace to
+	the namespaces in which the container's process is created.
+*/
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	var config Config
+	if _, err := rCtx.GetConfig(ConfigKey, &config); err != nil {
+		return gerror.NewFromError(ErrConfig, err)
+	}
+	if veth := config.Veth; veth != nil {
+		if veth.HostInterface == "" {
+			veth.HostInterface = defaultHostInterface(rCtx.GetId())
+		}
+		if veth.ContainerInterface == "" {
+			veth.ContainerInterface = DefaultContainerInterface
+		}
+		if gerr := validate(veth); gerr != nil {
+			return gerr
+		}
+	}
+	if glog.V(1) {
+		glog.Infof("Init: requesting a network namespace for container %q with veth %+v", rCtx.GetId(), config.Veth)
+	}
+
+	hostNS, err := c.sc.NamespaceId(0, syscall.NetNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if err := rCtx.SetConfig(ConfigKey, config); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	if err := rCtx.SetConfig(hostNamespaceKey, hostNS); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	rCtx.AddNamespaces(syscall.NetNS)
+	return nil
+}
+
+/*
+	Started creates the virtual ethernet pair, if any, with the container's device in the network namespace
+	of the container's process, which has the given process id, and connects the host's device. If
+	connecting fails, the pair is deleted. Started records in the resource context that the pair was created.
+*/
+func (c *Controller) Started(rCtx kernel.ResourceContext, pid int) error {
+	veth, gerr := publishedVeth(rCtx)
+	if gerr != nil || veth == nil {
+		return gerr
+	}
+	if err := c.nl.AddVethPair(veth.HostInterface, veth.ContainerInterface, pid); err != nil {
+		return gerror.NewFromError(ErrAddVeth, err)
+	}
+	if err := rCtx.SetConfig(createdKey, true); err != nil {
+		c.deleteVeth(rCtx, veth, err)
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	if gerr := c.connect(veth); gerr != nil {
+		c.deleteVeth(rCtx, veth, gerr)
+		return gerr
+	}
+	return nil
+}
+
+// deleteVeth deletes the virtual ethernet pair after Started has failed with the given error.
+func (c *Controller) deleteVeth(rCtx kernel.ResourceContext, veth *Veth, cause error) {
+	if err := c.nl.DeleteLink(veth.HostInterface); err != nil {
+		// Teardown tries again.
+		glog.Warningf("Encountered %q while recovering from %q", err, cause)
+		return
+	}
+	rCtx.SetConfig(createdKey, false)
+}
+
+// connect attaches the host's device to the bridge, if any, creating the bridge if necessary, and brings the device up.
+func (c *Controller) connect(veth *Veth) gerror.Gerror {
+	if veth.Bridge != "" {
+		if _, err := c.nl.LinkByName(veth.Bridge); err != nil {
+			if gerr, ok := err.(gerror.Gerror); !ok || !gerr.EqualTag(netlink.ErrLinkNotFound) {
+				return gerror.NewFromError(ErrBridge, err)
+			}
+			if glog.V(1) {
+				glog.Infof("Creating bridge %q", veth.Bridge)
+			}
+			if err := c.nl.AddBridge(veth.Bridge); err != nil {
+				return gerror.NewFromError(ErrBridge, err)
+			}
+		}
+		if err := c.nl.SetLinkUp(veth.Bridge); err != nil {
+			return gerror.NewFromError(ErrBridge, err)
+		}
+		if err := c.nl.SetLinkMaster(veth.HostInterface, veth.Bridge); err != nil {
+			return gerror.NewFromError(ErrBridge, err)
+		}
+	}
+	if err := c.nl.SetLinkUp(veth.HostInterface); err != nil {
+		return gerror.NewFromError(ErrLinkUp, err)
+	}
+	return nil
+}
+
+/*
+	Enter brings up the loopback device and, if there is a virtual ethernet pair, assigns the addresses to
+	the container's device, brings it up, and adds the default routes. It fails with ErrNotIsolated, rather
+	than changing the runner's network namespace, if the calling process is in that namespace.
+*/
+func (c *Controller) Enter(rCtx kernel.ResourceContext) error {
+	var hostNS string
+	if ok, err := rCtx.GetConfig(hostNamespaceKey, &hostNS); err != nil || !ok {
+		return gerror.New(ErrNotInitialised, "Network configuration has not been published")
+	}
+	veth, gerr := publishedVeth(rCtx)
+	if gerr != nil {
+		return gerr
+	}
+	ns, err := c.sc.NamespaceId(0, syscall.NetNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if ns == hostNS {
+		return gerror.Newf(ErrNotIsolated, "Process is in the runner's network namespace %q", ns)
+	}
+
+	if err := c.nl.SetLinkUp(loopback); err != nil {
+		return gerror.NewFromError(ErrLinkUp, err)
+	}
+	if veth == nil {
+		return nil
+	}
+	if glog.V(1) {
+		glog.Infof("Enter: configuring %q with addresses %v and gateways %v", veth.ContainerInterface, veth.Addresses, veth.Gateways)
+	}
+	for _, address := range veth.Addresses {
+		ip, ipNet, _ := net.ParseCIDR(address)
+		if err := c.nl.AddAddress(veth.ContainerInterface, net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+			return gerror.NewFromError(ErrAddAddress, err)
+		}
+	}
+	if err := c.nl.SetLinkUp(veth.ContainerInterface); err != nil {
+		return gerror.NewFromError(ErrLinkUp, err)
+	}
+	for _, gateway := range veth.Gateways {
+		if err := c.nl.AddDefaultRoute(veth.ContainerInterface, net.ParseIP(gateway)); err != nil {
+			return gerror.NewFromError(ErrAddRoute, err)
+		}
+	}
+	return nil
+}
+
+/*
+	Teardown deletes the virtual ethernet pair, if Started created it. The pair is usually deleted by the
+	kernel when the container's network namespace is destroyed, in which case there is nothing to delete.
+*/
+func (c *Controller) Teardown(rCtx kernel.ResourceContext) error {
+	veth, gerr := publishedVeth(rCtx)
+	if gerr != nil || veth == nil {
+		return gerr
+	}
+	var created bool
+	if _, err := rCtx.GetConfig(createdKey, &created); err != nil || !created {
+		return nil
+	}
+	if err := c.nl.DeleteLink(veth.HostInterface); err != nil {
+		if gerr, ok := err.(gerror.Gerror); !ok || !gerr.EqualTag(netlink.ErrLinkNotFound) {
+			return gerror.NewFromError(ErrDeleteVeth, err)
+		}
+	}
+	rCtx.SetConfig(createdKey, false)
+	return nil
+}
+
+// publishedVeth returns the virtual ethernet pair of the configuration published by Init, or nil if there is none.
+func publishedVeth(rCtx kernel.ResourceContext) (*Veth, gerror.Gerror) {
+	var config Config
+	if _, err := rCtx.GetConfig(ConfigKey, &config); err != nil {
+		return nil, gerror.NewFromError(ErrConfig, err)
+	}
+	return config.Veth, nil
+}
+
+// defaultHostInterface returns the default name of the host's device, which is derived from the given container handle.
+func defaultHostInterface(id string) string {
+	name := hostInterfacePrefix + id
+	if len(name) > maxInterfaceName {
+		name = name[:maxInterfaceName]
+	}
+	return name
+}
+
+/*
+	validate checks the device names and addresses of the given virtual ethernet pair. Each gateway must be
+	of the same family as one of the addresses, so that it can be reached, and there may be at most one
+	gateway of each family.
+*/
+func validate(veth *Veth) gerror.Gerror {
+	for _, name := range []string{veth.HostInterface, veth.ContainerInterface, veth.Bridge} {
+		if name != "" && !validInterfaceName(name) {
+			return gerror.Newf(ErrInvalidConfig, "Invalid network device name %q", name)
+		}
+	}
+	if veth.Bridge == veth.HostInterface {
+		return gerror.Newf(ErrInvalidConfig, "Bridge %q has the name of the host's device", veth.Bridge)
+	}
+	families := map[bool]bool{}
+	for _, address := range veth.Addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return gerror.Newf(ErrInvalidConfig, "Invalid address %q", address)
+		}
+		families[ip.To4() != nil] = true
+	}
+	gateways := map[bool]bool{}
+	for _, gateway := range veth.Gateways {
+		ip := net.ParseIP(gateway)
+		if ip == nil {
+			return gerror.Newf(ErrInvalidConfig, "Invalid gateway %q", gateway)
+		}
+		ipv4 := ip.To4() != nil
+		if !families[ipv4] || gateways[ipv4] {
+			return gerror.Newf(ErrInvalidConfig, "Gateway %q has no address of its family or duplicates another gateway's family", gateway)
+		}
+		gateways[ipv4] = true
+	}
+	return nil
+}
+
+// validInterfaceName returns true if and only if the given name is a valid network device name.
+func validInterfaceName(name string) bool {
+	return len(name) <= maxInterfaceName && name != "." && name != ".." && !strings.ContainsAny(name, "/: \t\n\x00")
+}
@@ -0,0 +1,463 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package netns_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/netlink"
+	"github.com/cf-guardian/guardian/kernel/netlink/mock_netlink"
+	"github.com/cf-guardian/guardian/kernel/netns"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
+	"net"
+	"reflect"
+	trueSyscall "syscall"
+	"testing"
+)
+
+const (
+	hostNS      = "net:[1]"
+	containerNS = "net:[2]"
+	pid         = 42
+)
+
+func TestInit(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := createContext(t, nil)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(hostNS, nil)
+	if err := netns.New(mockNetlink, mockSyscallNS).Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var config netns.Config
+	if ok, err := rCtx.GetConfig(netns.ConfigKey, &config); !ok || err != nil || config.Veth != nil {
+		t.Errorf("Incorrect published configuration %+v (%v, %v)", config, ok, err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.NetNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestInitVethDefaults(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{Id: "0123456789abcdef"})
+	if err := rCtx.SetConfig(netns.ConfigKey, netns.Config{Veth: &netns.Veth{}}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(hostNS, nil)
+	if err := netns.New(mockNetlink, mockSyscallNS).Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var config netns.Config
+	if ok, err := rCtx.GetConfig(netns.ConfigKey, &config); !ok || err != nil || config.Veth == nil {
+		t.Errorf("Incorrect published configuration %+v (%v, %v)", config, ok, err)
+		return
+	}
+	expected := netns.Veth{HostInterface: "gv-0123456789ab", ContainerInterface: netns.DefaultContainerInterface}
+	if !reflect.DeepEqual(*config.Veth, expected) {
+		t.Errorf("Published veth was %+v, expected %+v", *config.Veth, expected)
+	}
+}
+
+func TestInitInvalidConfig(t *testing.T) {
+	for _, veth := range []netns.Veth{
+		{HostInterface: "sixteen-chars-xx"},
+		{ContainerInterface: "eth/0"},
+		{Bridge: "br 0"},
+		{HostInterface: "veth0", Bridge: "veth0"},
+		{Addresses: []string{"10.0.0.2"}},
+		{Addresses: []string{"10.0.0.2/24"}, Gateways: []string{"10.0.0"}},
+		{Addresses: []string{"10.0.0.2/24"}, Gateways: []string{"fd00::1"}},
+		{Addresses: []string{"10.0.0.2/24"}, Gateways: []string{"10.0.0.1", "10.0.0.254"}},
+	} {
+		mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+		veth := veth
+		err := netns.New(mockNetlink, mockSyscallNS).Init(createContext(t, &netns.Config{Veth: &veth}))
+		if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrInvalidConfig) {
+			t.Errorf("Incorrect error %v for veth %+v", err, veth)
+		}
+		mockCtrl.Finish()
+	}
+}
+
+func TestInitInvalidConfigValue(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig(netns.ConfigKey, "eth0"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := netns.New(mockNetlink, mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrConfig) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := createContext(t, nil)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return("", errors.New("an error"))
+	err := netns.New(mockNetlink, mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != 0 {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestStarted(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	gomock.InOrder(
+		mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid),
+		mockNetlink.EXPECT().LinkByName("br-test").Return(netlink.Link{Index: 3, Name: "br-test", Kind: "bridge"}, nil),
+		mockNetlink.EXPECT().SetLinkUp("br-test"),
+		mockNetlink.EXPECT().SetLinkMaster("veth-host", "br-test"),
+		mockNetlink.EXPECT().SetLinkUp("veth-host"),
+	)
+	if err := c.Started(rCtx, pid); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestStartedCreatesBridge(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	gomock.InOrder(
+		mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid),
+		mockNetlink.EXPECT().LinkByName("br-test").Return(netlink.Link{}, gerror.New(netlink.ErrLinkNotFound, "an error")),
+		mockNetlink.EXPECT().AddBridge("br-test"),
+		mockNetlink.EXPECT().SetLinkUp("br-test"),
+		mockNetlink.EXPECT().SetLinkMaster("veth-host", "br-test"),
+		mockNetlink.EXPECT().SetLinkUp("veth-host"),
+	)
+	if err := c.Started(rCtx, pid); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestStartedNoVeth(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, nil)
+	if err := c.Started(rCtx, pid); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestStartedAddVethFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid).Return(errors.New("an error"))
+	err := c.Started(rCtx, pid)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrAddVeth) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestStartedBridgeFailureDeletesVeth(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	gomock.InOrder(
+		mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid),
+		mockNetlink.EXPECT().LinkByName("br-test").Return(netlink.Link{Index: 3}, nil),
+		mockNetlink.EXPECT().SetLinkUp("br-test"),
+		mockNetlink.EXPECT().SetLinkMaster("veth-host", "br-test").Return(errors.New("an error")),
+		mockNetlink.EXPECT().DeleteLink("veth-host"),
+	)
+	err := c.Started(rCtx, pid)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrBridge) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestStartedLinkUpFailureDeletesVeth(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	config := testConfig()
+	config.Veth.Bridge = ""
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, config)
+	gomock.InOrder(
+		mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid),
+		mockNetlink.EXPECT().SetLinkUp("veth-host").Return(errors.New("an error")),
+		mockNetlink.EXPECT().DeleteLink("veth-host").Return(errors.New("another error")),
+	)
+	err := c.Started(rCtx, pid)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrLinkUp) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnter(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	gomock.InOrder(
+		mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(containerNS, nil),
+		mockNetlink.EXPECT().SetLinkUp("lo"),
+		mockNetlink.EXPECT().AddAddress("eth1", ipNet("10.0.0.2/24")),
+		mockNetlink.EXPECT().AddAddress("eth1", ipNet("fd00::2/64")),
+		mockNetlink.EXPECT().SetLinkUp("eth1"),
+		mockNetlink.EXPECT().AddDefaultRoute("eth1", net.ParseIP("10.0.0.1")),
+		mockNetlink.EXPECT().AddDefaultRoute("eth1", net.ParseIP("fd00::1")),
+	)
+	// Enter is called on a new controller, as in the container's process.
+	if err := netns.New(mockNetlink, mockSyscallNS).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestEnterLoopbackOnly(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, rCtx := initController(t, mockNetlink, mockSyscallNS, nil)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(containerNS, nil)
+	mockNetlink.EXPECT().SetLinkUp("lo")
+	if err := netns.New(mockNetlink, mockSyscallNS).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestEnterNotInitialised(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	err := netns.New(mockNetlink, mockSyscallNS).Enter(createContext(t, testConfig()))
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNotIsolated(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(hostNS, nil)
+	err := netns.New(mockNetlink, mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrNotIsolated) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterLoopbackFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, rCtx := initController(t, mockNetlink, mockSyscallNS, nil)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(containerNS, nil)
+	mockNetlink.EXPECT().SetLinkUp("lo").Return(errors.New("an error"))
+	err := netns.New(mockNetlink, mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrLinkUp) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterAddressFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(containerNS, nil)
+	mockNetlink.EXPECT().SetLinkUp("lo")
+	mockNetlink.EXPECT().AddAddress("eth1", ipNet("10.0.0.2/24")).Return(errors.New("an error"))
+	err := netns.New(mockNetlink, mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrAddAddress) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterRouteFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	_, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(containerNS, nil)
+	mockNetlink.EXPECT().SetLinkUp(gomock.Any()).Times(2)
+	mockNetlink.EXPECT().AddAddress("eth1", gomock.Any()).Times(2)
+	mockNetlink.EXPECT().AddDefaultRoute("eth1", net.ParseIP("10.0.0.1")).Return(errors.New("an error"))
+	err := netns.New(mockNetlink, mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrAddRoute) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	startController(t, c, rCtx, mockNetlink)
+	mockNetlink.EXPECT().DeleteLink("veth-host")
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+	// A second teardown does nothing.
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownNotStarted(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// A device of the same name, which the container did not create, is not deleted.
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownAfterAddVethFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// The pair could not be created because a device of the same name exists, which is not deleted.
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid).Return(trueSyscall.EEXIST)
+	if err := c.Started(rCtx, pid); err == nil {
+		t.Errorf("Started should have failed")
+	}
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownAfterConnectFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// Started deletes the pair, so Teardown does not delete it again.
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, &netns.Config{Veth: &netns.Veth{HostInterface: "veth-host"}})
+	gomock.InOrder(
+		mockNetlink.EXPECT().AddVethPair("veth-host", netns.DefaultContainerInterface, pid),
+		mockNetlink.EXPECT().SetLinkUp("veth-host").Return(errors.New("an error")),
+		mockNetlink.EXPECT().DeleteLink("veth-host"),
+	)
+	if err := c.Started(rCtx, pid); err == nil {
+		t.Errorf("Started should have failed")
+	}
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownAlreadyDeleted(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	startController(t, c, rCtx, mockNetlink)
+	mockNetlink.EXPECT().DeleteLink("veth-host").Return(gerror.New(netlink.ErrLinkNotFound, "an error"))
+	if err := c.Teardown(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTeardownFailure(t *testing.T) {
+	mockCtrl, mockNetlink, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c, rCtx := initController(t, mockNetlink, mockSyscallNS, testConfig())
+	startController(t, c, rCtx, mockNetlink)
+	mockNetlink.EXPECT().DeleteLink("veth-host").Return(errors.New("an error"))
+	err := c.Teardown(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(netns.ErrDeleteVeth) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func testConfig() *netns.Config {
+	return &netns.Config{Veth: &netns.Veth{
+		HostInterface:      "veth-host",
+		ContainerInterface: "eth1",
+		Bridge:             "br-test",
+		Addresses:          []string{"10.0.0.2/24", "fd00::2/64"},
+		Gateways:           []string{"10.0.0.1", "fd00::1"},
+	}}
+}
+
+func initController(t *testing.T, mockNetlink *mock_netlink.MockNetlink, mockSyscallNS *mock_syscall.MockSyscallNS,
+	config *netns.Config) (*netns.Controller, kernel.ResourceContext) {
+	rCtx := createContext(t, config)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.NetNS).Return(hostNS, nil)
+	c := netns.New(mockNetlink, mockSyscallNS)
+	if err := c.Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return c, rCtx
+}
+
+// startController calls Started, which creates and connects the pair of the test configuration.
+func startController(t *testing.T, c *netns.Controller, rCtx kernel.ResourceContext, mockNetlink *mock_netlink.MockNetlink) {
+	gomock.InOrder(
+		mockNetlink.EXPECT().AddVethPair("veth-host", "eth1", pid),
+		mockNetlink.EXPECT().LinkByName("br-test").Return(netlink.Link{Index: 3, Name: "br-test", Kind: "bridge"}, nil),
+		mockNetlink.EXPECT().SetLinkUp("br-test"),
+		mockNetlink.EXPECT().SetLinkMaster("veth-host", "br-test"),
+		mockNetlink.EXPECT().SetLinkUp("veth-host"),
+	)
+	if err := c.Started(rCtx, pid); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func createContext(t *testing.T, config *netns.Config) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContext("/")
+	if config != nil {
+		if err := rCtx.SetConfig(netns.ConfigKey, *config); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	return rCtx
+}
+
+// ipNet returns the address, with its mask, given in CIDR notation.
+func ipNet(cidr string) net.IPNet {
+	ip, ipNet, _ := net.ParseCIDR(cidr)
+	return net.IPNet{IP: ip, Mask: ipNet.Mask}
+}
+
+func setupMocks(t *testing.T) (*gomock.Controller, *mock_netlink.MockNetlink, *mock_syscall.MockSyscallNS) {
+	mockCtrl := gomock.NewController(t)
+	return mockCtrl, mock_netlink.NewMockNetlink(mockCtrl), mock_syscall.NewMockSyscallNS(mockCtrl)
+}
@@ -0,0 +1,52 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package pidns provides a resource controller which gives a container a PID namespace of its own.
+*/
+package pidns
+
+import (
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+)
+
+/*
+	A Controller is a resource controller which creates the container's process in a new PID namespace.
+	The runner then runs a minimal init process as PID 1 of the namespace which runs the container's command,
+	reaps orphaned processes, forwards SIGTERM, SIGINT, and SIGHUP to the command, and reports the command's
+	exit status (see runner.Child).
+
+	Since /proc reflects the PID namespace of the process which mounted it, the container also needs a proc
+	filesystem of its own, such as the one mounted by a mountns.Controller.
+*/
+type Controller struct {
+}
+
+// Creates a new Controller.
+func New() *Controller {
+	return &Controller{}
+}
+
+// Init adds a PID namespace to the namespaces in which the container's process is created.
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	if glog.V(1) {
+		glog.Infof("Init: requesting a PID namespace for container %q", rCtx.GetId())
+	}
+	rCtx.AddNamespaces(syscall.PIDNS)
+	return nil
+}
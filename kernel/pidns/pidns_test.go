@@ -0,0 +1,36 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package pidns_test
+
+import (
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/pidns"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	rCtx.AddNamespaces(syscall.MountNS)
+	if err := pidns.New().Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.MountNS|syscall.PIDNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
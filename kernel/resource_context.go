@@ -0,0 +1,229 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kernel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"sync"
+)
+
+// ErrorId is used for error ids relating to the kernel package.
+type ErrorId int
+
+const (
+	ErrEncodeContext ErrorId = iota // a resource context could not be encoded
+	ErrDecodeContext                // a resource context could not be decoded
+	ErrSetConfig                    // a configuration value could not be stored in a resource context
+	ErrGetConfig                    // a configuration value could not be retrieved from a resource context
+)
+
+// A Credential identifies the user and groups which a container's command runs as.
+type Credential struct {
+	Uid    uint32   // the user id
+	Gid    uint32   // the primary group id
+	Groups []uint32 // the supplementary group ids
+}
+
+// A ProcessSpec specifies the process which runs a container's command.
+type ProcessSpec struct {
+	Args       []string    // the command and its arguments, which are set by the runner
+	Env        []string    // the command's environment, or nil to use the environment of the runner
+	Dir        string      // the command's working directory, or empty to use the root directory
+	Credential *Credential // the command's user and groups, or nil to use those of the runner
+
+	// Namespaces are the types of namespace, in addition to those of the runner, in which the process is created.
+	Namespaces syscall.Namespaces
+}
+
+/*
+	ResourceContext provides configuration for resource controllers and enables resource controllers to
+	publish information for use by later resource controllers.
+
+	Configuration specific to a type of resource, such as a memory limit or a host name, is stored under a
+	key chosen by the package of the resource controller which uses it. Configuration values are stored in
+	encoded form and so must be encodable using the encoding/json package.
+
+	A ResourceContext may be used by resource controllers which are initialised in parallel, except that
+	the process specification must only be modified by resource controllers which are not.
+*/
+type ResourceContext interface {
+
+	// GetRootFS returns the path of the root file system. A root file system is an
+	// arbitrary filesystem directory.
+	GetRootFS() string
+
+	// SetRootFS sets the path of the root file system, for example once a resource controller has
+	// generated a root file system for the container.
+	SetRootFS(rootfs string)
+
+	// GetId returns the container's handle, which is unique among the containers of the program.
+	GetId() string
+
+	// GetStateDir returns the path of a directory in which resource controllers may keep state, or the
+	// empty string if there is no such directory.
+	GetStateDir() string
+
+	// GetProcess returns the specification of the container's process. Resource controllers may modify the
+	// specification.
+	GetProcess() *ProcessSpec
+
+	// AddNamespaces adds the given types of namespace to those in which the container's process is created.
+	// Unlike other modifications of the process specification, AddNamespaces may be called by resource
+	// controllers which are initialised in parallel.
+	AddNamespaces(namespaces syscall.Namespaces)
+
+	// GetConfig decodes the configuration value with the given key into the value pointed to by value and
+	// returns true or, if there is no configuration value with the given key, returns false.
+	GetConfig(key string, value interface{}) (bool, error)
+
+	// SetConfig stores the given configuration value under the given key.
+	SetConfig(key string, value interface{}) error
+}
+
+// ContextOptions provide the optional parts of a ResourceContext.
+type ContextOptions struct {
+	Id       string      // the container's handle. If empty, a random handle is generated.
+	StateDir string      // the state directory, if any
+	Process  ProcessSpec // the specification of the container's process
+}
+
+// resourceContext is the ResourceContext implementation. Its fields are exported for encoding.
+type resourceContext struct {
+	mu       sync.Mutex
+	RootFS   string
+	Id       string
+	StateDir string
+	Process  ProcessSpec
+	Config   map[string]json.RawMessage
+}
+
+// CreateResourceContext creates a ResourceContext with the given root file system.
+func CreateResourceContext(rootfs string) ResourceContext {
+	return CreateResourceContextWithOptions(rootfs, ContextOptions{})
+}
+
+// CreateResourceContextWithOptions creates a ResourceContext with the given root file system and options.
+func CreateResourceContextWithOptions(rootfs string, opts ContextOptions) ResourceContext {
+	id := opts.Id
+	if id == "" {
+		id = newId()
+	}
+	return &resourceContext{
+		RootFS:   rootfs,
+		Id:       id,
+		StateDir: opts.StateDir,
+		Process:  opts.Process,
+		Config:   make(map[string]json.RawMessage),
+	}
+}
+
+// newId returns a random container handle.
+func newId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // the random number generator of the operating system has failed
+	}
+	return hex.EncodeToString(b)
+}
+
+func (rCtx *resourceContext) GetRootFS() string {
+	rCtx.mu.Lock()
+	defer rCtx.mu.Unlock()
+	return rCtx.RootFS
+}
+
+func (rCtx *resourceContext) SetRootFS(rootfs string) {
+	rCtx.mu.Lock()
+	defer rCtx.mu.Unlock()
+	rCtx.RootFS = rootfs
+}
+
+func (rCtx *resourceContext) GetId() string {
+	return rCtx.Id
+}
+
+func (rCtx *resourceContext) GetStateDir() string {
+	return rCtx.StateDir
+}
+
+func (rCtx *resourceContext) GetProcess() *ProcessSpec {
+	return &rCtx.Process
+}
+
+func (rCtx *resourceContext) AddNamespaces(namespaces syscall.Namespaces) {
+	rCtx.mu.Lock()
+	defer rCtx.mu.Unlock()
+	rCtx.Process.Namespaces |= namespaces
+}
+
+func (rCtx *resourceContext) GetConfig(key string, value interface{}) (bool, error) {
+	rCtx.mu.Lock()
+	data, ok := rCtx.Config[key]
+	rCtx.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, gerror.NewFromError(ErrGetConfig, err)
+	}
+	return true, nil
+}
+
+func (rCtx *resourceContext) SetConfig(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return gerror.NewFromError(ErrSetConfig, err)
+	}
+	rCtx.mu.Lock()
+	defer rCtx.mu.Unlock()
+	rCtx.Config[key] = data
+	return nil
+}
+
+/*
+	EncodeResourceContext encodes the given resource context, which must have been created by this package,
+	so that it may be passed to another process.
+*/
+func EncodeResourceContext(rCtx ResourceContext) ([]byte, gerror.Gerror) {
+	rc, ok := rCtx.(*resourceContext)
+	if !ok {
+		return nil, gerror.Newf(ErrEncodeContext, "Resource context %v was not created by the kernel package", rCtx)
+	}
+	rc.mu.Lock()
+	data, err := json.Marshal(rc)
+	rc.mu.Unlock()
+	if err != nil {
+		return nil, gerror.NewFromError(ErrEncodeContext, err)
+	}
+	return data, nil
+}
+
+// DecodeResourceContext decodes a resource context encoded by EncodeResourceContext.
+func DecodeResourceContext(data []byte) (ResourceContext, gerror.Gerror) {
+	rc := &resourceContext{}
+	if err := json.Unmarshal(data, rc); err != nil {
+		return nil, gerror.NewFromError(ErrDecodeContext, err)
+	}
+	if rc.Config == nil {
+		rc.Config = make(map[string]json.RawMessage)
+	}
+	return rc, nil
+}
@@ -0,0 +1,175 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kernel_test
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"reflect"
+	"testing"
+)
+
+type testConfig struct {
+	Limit uint64
+	Names []string
+}
+
+func TestCreateResourceContext(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/test-rootfs")
+	if rootfs := rCtx.GetRootFS(); rootfs != "/test-rootfs" {
+		t.Errorf("Incorrect root file system %q", rootfs)
+	}
+	if id := rCtx.GetId(); id == "" || id == kernel.CreateResourceContext("/").GetId() {
+		t.Errorf("Incorrect id %q", id)
+	}
+	if stateDir := rCtx.GetStateDir(); stateDir != "" {
+		t.Errorf("Incorrect state directory %q", stateDir)
+	}
+	if process := rCtx.GetProcess(); !reflect.DeepEqual(*process, kernel.ProcessSpec{}) {
+		t.Errorf("Incorrect process specification %v", process)
+	}
+}
+
+func TestCreateResourceContextWithOptions(t *testing.T) {
+	process := kernel.ProcessSpec{Env: []string{"A=b"}, Dir: "/tmp", Credential: &kernel.Credential{Uid: 1, Gid: 2}}
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{Id: "test-id", StateDir: "/test-state", Process: process})
+	if id := rCtx.GetId(); id != "test-id" {
+		t.Errorf("Incorrect id %q", id)
+	}
+	if stateDir := rCtx.GetStateDir(); stateDir != "/test-state" {
+		t.Errorf("Incorrect state directory %q", stateDir)
+	}
+	if p := rCtx.GetProcess(); !reflect.DeepEqual(*p, process) {
+		t.Errorf("Incorrect process specification %v", p)
+	}
+}
+
+func TestResourceContextIsWritable(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	rCtx.SetRootFS("/test-root")
+	rCtx.GetProcess().Args = []string{"true"}
+	if rootfs := rCtx.GetRootFS(); rootfs != "/test-root" {
+		t.Errorf("Incorrect root file system %q", rootfs)
+	}
+	if args := rCtx.GetProcess().Args; !reflect.DeepEqual(args, []string{"true"}) {
+		t.Errorf("Incorrect arguments %v", args)
+	}
+}
+
+func TestAddNamespaces(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	rCtx.AddNamespaces(syscall.MountNS)
+	rCtx.AddNamespaces(syscall.PIDNS | syscall.MountNS)
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.MountNS|syscall.PIDNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	var config testConfig
+	if ok, err := rCtx.GetConfig("test", &config); ok || err != nil {
+		t.Errorf("Incorrect return values (%v, %v) for missing key", ok, err)
+	}
+
+	expected := testConfig{1 << 20, []string{"a", "b"}}
+	if err := rCtx.SetConfig("test", expected); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if ok, err := rCtx.GetConfig("test", &config); !ok || err != nil {
+		t.Errorf("Incorrect return values (%v, %v)", ok, err)
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Config was %v, expected %v", config, expected)
+	}
+}
+
+func TestConfigTypeMismatch(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig("test", "a string"); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	var config testConfig
+	ok, err := rCtx.GetConfig("test", &config)
+	if ok || err == nil || !err.(gerror.Gerror).EqualTag(kernel.ErrGetConfig) {
+		t.Errorf("Incorrect return values (%v, %v)", ok, err)
+	}
+}
+
+func TestSetConfigUnencodable(t *testing.T) {
+	rCtx := kernel.CreateResourceContext("/")
+	err := rCtx.SetConfig("test", make(chan int))
+	if err == nil || !err.(gerror.Gerror).EqualTag(kernel.ErrSetConfig) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEncodeResourceContext(t *testing.T) {
+	process := kernel.ProcessSpec{
+		Args:       []string{"ls", "-l"},
+		Credential: &kernel.Credential{Uid: 1, Gid: 2, Groups: []uint32{3}},
+		Namespaces: syscall.PIDNS,
+	}
+	rCtx := kernel.CreateResourceContextWithOptions("/test-rootfs", kernel.ContextOptions{StateDir: "/test-state", Process: process})
+	expected := testConfig{42, nil}
+	if err := rCtx.SetConfig("test", expected); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	data, gerr := kernel.EncodeResourceContext(rCtx)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	decoded, gerr := kernel.DecodeResourceContext(data)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	if decoded.GetRootFS() != "/test-rootfs" || decoded.GetId() != rCtx.GetId() || decoded.GetStateDir() != "/test-state" {
+		t.Errorf("Incorrect decoded resource context %v", decoded)
+	}
+	if p := decoded.GetProcess(); !reflect.DeepEqual(*p, process) {
+		t.Errorf("Incorrect process specification %v", p)
+	}
+	var config testConfig
+	if ok, err := decoded.GetConfig("test", &config); !ok || err != nil || !reflect.DeepEqual(config, expected) {
+		t.Errorf("Incorrect config %v (%v, %v)", config, ok, err)
+	}
+}
+
+type foreignContext struct {
+	kernel.ResourceContext
+}
+
+func TestEncodeForeignResourceContext(t *testing.T) {
+	_, gerr := kernel.EncodeResourceContext(foreignContext{kernel.CreateResourceContext("/")})
+	if gerr == nil || !gerr.EqualTag(kernel.ErrEncodeContext) {
+		t.Errorf("Incorrect error %v", gerr)
+	}
+}
+
+func TestDecodeInvalidResourceContext(t *testing.T) {
+	_, gerr := kernel.DecodeResourceContext([]byte("not json"))
+	if gerr == nil || !gerr.EqualTag(kernel.ErrDecodeContext) {
+		t.Errorf("Incorrect error %v", gerr)
+	}
+}
@@ -20,28 +20,101 @@ to create a container.
 */
 package kernel
 
-// ResourceController provides containment for a specific type of resource.
+import "time"
+
+/*
+	ResourceController provides containment for a specific type of resource. Init performs any setup
+	required in the parent process before the container's process is created.
+
+	A ResourceController may also implement ResourceStarter, ResourceEnterer, ResourceReleaser,
+	ResourceReporter, and ResourceNotifier to take part in the later stages of the container's lifecycle.
+*/
 type ResourceController interface {
 	Init(rCtx ResourceContext) error
 }
 
-// ResourceContext provides configuration for resource controllers.
-type ResourceContext interface {
+/*
+	A ResourceStarter is a ResourceController with setup to perform in the parent process once the
+	container's process has been created, with the given process id, and before any ResourceEnterer enters
+	it, such as writing the ID maps of the process's user namespace.
+*/
+type ResourceStarter interface {
+	Started(rCtx ResourceContext, pid int) error
+}
 
-	// GetRootFS returns the path of the root file system. A root file system is an
-	// arbitrary filesystem directory.
-	GetRootFS() string
+/*
+	A ResourceEnterer is a ResourceController with setup to perform in the container's process after
+	it has been created and before the container's command is executed, such as entering a root
+	filesystem.
+
+	The container's process is a new instance of the program and so Enter is called on a resource
+	controller created in that process rather than on the one which was initialised.
+*/
+type ResourceEnterer interface {
+	Enter(rCtx ResourceContext) error
 }
 
-type resourceContext struct {
-	rootfs string
+/*
+	A ResourceReleaser is a ResourceController which can undo the effects of a successful
+	call to Init, for example when a later resource controller fails to initialise or when
+	the container's command has finished.
+*/
+type ResourceReleaser interface {
+	Teardown(rCtx ResourceContext) error
 }
 
-func (rCtx *resourceContext) GetRootFS() string {
-	return rCtx.rootfs
+// A Capability names something which a resource controller provides to other resource controllers.
+type Capability string
+
+const (
+	RootFSCapability Capability = "rootfs" // a root file system generated for the container
+	CgroupCapability Capability = "cgroup" // a control group which limits the container's resource usage
+	UserNSCapability Capability = "userns" // a user namespace whose ID mappings apply to the container's files
+)
+
+/*
+	A ResourceDependent is a ResourceController which declares its dependencies on other resource
+	controllers. The runner orders resource controllers so that each is initialised after those which
+	provide the capabilities it requires or follows. A required capability must be provided by one of
+	the container's resource controllers whereas a followed capability need not be.
+*/
+type ResourceDependent interface {
+	Provides() []Capability
+	Requires() []Capability
+	Follows() []Capability
+}
+
+// ResourceStats is a snapshot of resource usage keyed by statistic name, such as "memory.current".
+type ResourceStats map[string]uint64
+
+// A ResourceReporter is a ResourceController which can report the container's usage of its resource.
+type ResourceReporter interface {
+	Stats(rCtx ResourceContext) (ResourceStats, error)
 }
 
-// CreateResourceContext creates a ResourceContext with the given root file system.
-func CreateResourceContext(rootfs string) *resourceContext {
-	return &resourceContext{rootfs}
+// An EventType identifies a kind of ResourceEvent.
+type EventType string
+
+const (
+	OOMEvent       EventType = "oom"        // the container reached its memory limit
+	OOMKillEvent   EventType = "oom_kill"   // a process was killed because the container ran out of memory
+	PidsLimitEvent EventType = "pids_limit" // a process could not be created because the container reached its process limit
+)
+
+// A ResourceEvent reports that an event affecting the container's resources has occurred.
+type ResourceEvent struct {
+	Type  EventType
+	Count uint64    // the number of occurrences since the previous event of the same type was reported
+	Time  time.Time // the time at which the occurrences were observed
+}
+
+/*
+	A ResourceNotifier is a ResourceController which reports events affecting the container's resources.
+	Subscribe returns a channel on which events are sent until the resource controller is torn down, when
+	the channel is closed. Events which occur before teardown are sent before the channel is closed. The
+	subscriber must receive from the channel until it is closed. A ResourceNotifier must also implement
+	ResourceReleaser.
+*/
+type ResourceNotifier interface {
+	Subscribe(rCtx ResourceContext) (<-chan ResourceEvent, error)
 }
@@ -0,0 +1,136 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/fileutils"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"path/filepath"
+)
+
+/*
+	bindMountStrategy bind mounts the prototype read-only and then bind mounts read-write
+	directories over the corresponding directories of the prototype.
+*/
+type bindMountStrategy struct {
+	sc          syscall.SyscallFS
+	f           fileutils.Fileutils
+	propagation syscall.Propagation
+}
+
+func (bms *bindMountStrategy) mount(prototype string, root string, rwPath string, dirs []RwDir, shift *idShift) gerror.Gerror {
+	if err := bms.sc.BindMountReadOnly(prototype, root); err != nil {
+		glog.Errorf("BindMountReadOnly(%q, %q) failed with: %s", prototype, root, err)
+		return gerror.NewFromError(ErrBindMountRoot, err)
+	}
+
+	gerr := setPropagation(bms.sc, root, bms.propagation, false)
+	if gerr == nil {
+		gerr = bms.overlay(root, rwPath, dirs, shift)
+	}
+	if gerr != nil {
+		if glog.V(1) {
+			glog.Infof("unmounting %q", root)
+		}
+		if e := bms.sc.Unmount(root); e != nil {
+			glog.Warningf("Encountered %q while recovering from %s", e, gerr)
+		}
+		return gerr
+	}
+	return nil
+}
+
+func (bms *bindMountStrategy) mountPoints(root string, dirs []RwDir) []string {
+	mounts := []string{root}
+	for _, dir := range dirs {
+		mounts = append(mounts, filepath.Join(root, dir.Path))
+	}
+	return mounts
+}
+
+func (bms *bindMountStrategy) overlay(root string, rwPath string, dirs []RwDir, shift *idShift) gerror.Gerror {
+	if glog.V(2) {
+		glog.Infof("overlay(%q, %q, %v)", root, rwPath, dirs)
+	}
+
+	for i, dir := range dirs {
+		if gerr := bms.overlayDirectory(dir, root, rwPath, shift); gerr != nil {
+			for j := i - 1; j >= 0; j-- {
+				if cleanupGerr := bms.unmountOverlayDirectory(dirs[j].Path, root); cleanupGerr != nil {
+					glog.Warningf("Encountered %q while recovering from %q", cleanupGerr, gerr)
+				}
+			}
+			return gerr
+		}
+	}
+	return nil
+}
+
+func (bms *bindMountStrategy) overlayDirectory(dir RwDir, root string, rwPath string, shift *idShift) gerror.Gerror {
+	if glog.V(2) {
+		glog.Infof("overlayDirectory(%v, %q, %q)", dir, root, rwPath)
+	}
+	mntPath := filepath.Join(root, dir.Path)
+	dirPath := filepath.Join(rwPath, dir.Path)
+
+	if dir.Policy&EmptyTmp != 0 {
+		// Create the directory so it will end up empty and with the correct permissions regardless of the
+		// directory contents and permissions in the prototype root filesystem.
+		if gerr := makeTempDir(dirPath); gerr != nil {
+			return gerr
+		}
+		if shift != nil {
+			if gerr := shift.root(dirPath); gerr != nil {
+				return gerr
+			}
+		}
+	} else if !bms.f.Exists(dirPath) {
+		// Set up read-write directory, copying mount directory contents if there are any.
+		if err := bms.f.Copy(dirPath, mntPath); err != nil {
+			return gerror.NewFromError(ErrOverlayDir, err)
+		}
+		if shift != nil {
+			if gerr := shift.copyTree(dirPath, dir.Path); gerr != nil {
+				return gerr
+			}
+		}
+	}
+
+	if glog.V(2) {
+		glog.Infof("BindMountReadWrite(%q, %q)", dirPath, mntPath)
+	}
+	err := bms.sc.BindMountReadWrite(dirPath, mntPath)
+	if err != nil {
+		glog.Errorf("BindMountReadWrite(%q, %q) failed with: %s", dirPath, mntPath, err)
+		return gerror.NewFromError(ErrBindMountSubdir, err)
+	}
+	return nil
+}
+
+func (bms *bindMountStrategy) unmountOverlayDirectory(dir string, root string) gerror.Gerror {
+	if glog.V(2) {
+		glog.Infof("unmountOverlayDirectory(%q, %q)", dir, root)
+	}
+	mntPath := filepath.Join(root, dir)
+	err := bms.sc.Unmount(mntPath)
+	if err != nil {
+		return gerror.NewFromError(ErrUnmountSubdir, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/golang/glog"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	DirPolicy determines how a read-write directory of a generated root filesystem is set up. A policy
+	combines a content policy, CopyUp or EmptyTmp, with a presence policy, Required or SkipIfMissing,
+	for example EmptyTmp|SkipIfMissing. The zero value is CopyUp|Required.
+*/
+type DirPolicy int
+
+const (
+	CopyUp        DirPolicy = 0      // the directory starts with a copy of the prototype directory's contents
+	EmptyTmp      DirPolicy = 1 << 0 // the directory starts empty and is writable by all, like /tmp
+	Required      DirPolicy = 0      // Generate fails if the prototype does not have the directory
+	SkipIfMissing DirPolicy = 1 << 1 // the directory is skipped if the prototype does not have it
+)
+
+// An RwDir specifies a directory of a generated root filesystem which is made read-write.
+type RwDir struct {
+	// Path is the path of the directory relative to the root, such as "etc" or "var/lib".
+	Path string
+
+	// Policy determines how the directory is set up.
+	Policy DirPolicy
+}
+
+/*
+	DefaultDirs returns the read-write directories used when none are specified: proc, dev, etc,
+	home, sbin, and var are copied up and tmp is empty. All are required.
+*/
+func DefaultDirs() []RwDir {
+	return []RwDir{
+		{`proc`, CopyUp},
+		{`dev`, CopyUp},
+		{`etc`, CopyUp},
+		{`home`, CopyUp},
+		{`sbin`, CopyUp},
+		{`var`, CopyUp},
+		{`tmp`, EmptyTmp},
+	}
+}
+
+// validateDirs checks that each of the given directories has a clean path relative to the root.
+func validateDirs(dirs []RwDir) gerror.Gerror {
+	for _, dir := range dirs {
+		p := dir.Path
+		if p == "" || filepath.IsAbs(p) || filepath.Clean(p) != p || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			return gerror.Newf(ErrInvalidDir, "Invalid read-write directory path %q", p)
+		}
+	}
+	return nil
+}
+
+/*
+	presentDirs returns the given directories which are present in the given prototype. If a required
+	directory is missing, an error is returned.
+*/
+func (rfs *rootfs) presentDirs(prototype string, dirs []RwDir) ([]RwDir, gerror.Gerror) {
+	present := []RwDir{}
+	for _, dir := range dirs {
+		if rfs.f.Exists(filepath.Join(prototype, dir.Path)) {
+			present = append(present, dir)
+			continue
+		}
+		if dir.Policy&SkipIfMissing == 0 {
+			glog.Errorf("Directory %q not present in prototype (%q)", dir.Path, prototype)
+			return nil, gerror.Newf(ErrRootSubdirMissing, "Directory %q not present in prototype (%q)", dir.Path, prototype)
+		}
+		if glog.V(2) {
+			glog.Infof("Skipping directory %q which is not present in prototype (%q)", dir.Path, prototype)
+		}
+	}
+	return present, nil
+}
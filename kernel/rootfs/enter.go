@@ -0,0 +1,67 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+)
+
+/*
+	Enter makes the given root filesystem, typically one returned by Generate, the root directory of the
+	calling process using pivot_root. The calling process must be in a mount namespace of its own since
+	Enter changes the propagation of all its mounts to private, which pivot_root requires, and detaches
+	the old root.
+
+	The sequence is:
+
+		1. make all mounts private so that the following steps do not propagate to other namespaces,
+		2. bind mount the root filesystem onto itself so that it is a mount point,
+		3. change directory to the root filesystem and pivot_root(".", "."), which stacks the old root
+		   on top of the new root without needing a directory for the old root in the new root, which
+		   may be read-only,
+		4. detach the old root with MNT_DETACH, which uncovers the new root,
+		5. change directory to "/".
+
+	If a step fails, an error tagged for the step is returned and the calling process is left in an
+	indeterminate state. It should then exit.
+*/
+func Enter(sc syscall.SyscallFS, root string) gerror.Gerror {
+	if glog.V(1) {
+		glog.Infof("Enter(%q)", root)
+	}
+	if err := sc.SetPropagation("/", syscall.PropagationPrivate, true); err != nil {
+		return gerror.NewFromError(ErrEnterPropagation, err)
+	}
+	if err := sc.Mount(root, root, "", syscall.MountBind|syscall.MountRec, ""); err != nil {
+		return gerror.NewFromError(ErrEnterBindRoot, err)
+	}
+	if err := sc.Chdir(root); err != nil {
+		return gerror.NewFromError(ErrEnterChdir, err)
+	}
+	if err := sc.PivotRoot(".", "."); err != nil {
+		return gerror.NewFromError(ErrPivotRoot, err)
+	}
+	if err := sc.UnmountWithOptions(".", syscall.UnmountOptions{Flags: syscall.UnmountDetach}); err != nil {
+		return gerror.NewFromError(ErrUnmountOldRoot, err)
+	}
+	if err := sc.Chdir("/"); err != nil {
+		return gerror.NewFromError(ErrEnterChdir, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/rootfs"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"testing"
+)
+
+const testRoot = "/test-rootfs"
+
+func TestEnter(t *testing.T) {
+	for failingStep := 0; failingStep <= 6; failingStep++ {
+		testEnter(t, failingStep)
+	}
+}
+
+/*
+	testEnter checks Enter with the given step failing. If the given step is greater than the
+	number of steps, no step fails.
+*/
+func testEnter(t *testing.T, failingStep int) {
+	mockCtrl, _, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	steps := []struct {
+		call *gomock.Call
+		tag  gerror.Tag
+	}{
+		{mockSyscallFS.EXPECT().SetPropagation("/", syscall.PropagationPrivate, true), rootfs.ErrEnterPropagation},
+		{mockSyscallFS.EXPECT().Mount(testRoot, testRoot, "", syscall.MountBind|syscall.MountRec, ""), rootfs.ErrEnterBindRoot},
+		{mockSyscallFS.EXPECT().Chdir(testRoot), rootfs.ErrEnterChdir},
+		{mockSyscallFS.EXPECT().PivotRoot(".", "."), rootfs.ErrPivotRoot},
+		{mockSyscallFS.EXPECT().UnmountWithOptions(".", syscall.UnmountOptions{Flags: syscall.UnmountDetach}), rootfs.ErrUnmountOldRoot},
+		{mockSyscallFS.EXPECT().Chdir("/"), rootfs.ErrEnterChdir},
+	}
+	var expectedTag gerror.Tag
+	for i, step := range steps {
+		if i > 0 {
+			step.call.After(steps[i-1].call)
+		}
+		switch {
+		case i == failingStep:
+			step.call.Return(errors.New("an error"))
+			expectedTag = step.tag
+		case i > failingStep:
+			step.call.Times(0)
+		default:
+			step.call.Return(nil)
+		}
+	}
+
+	gerr := rootfs.Enter(mockSyscallFS, testRoot)
+	if expectedTag == nil && gerr != nil || expectedTag != nil && (gerr == nil || !gerr.EqualTag(expectedTag)) {
+		t.Errorf("Incorrect error %s with step %d failing", gerr, failingStep)
+	}
+}
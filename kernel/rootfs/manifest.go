@@ -0,0 +1,135 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs
+
+import (
+	"encoding/json"
+	"github.com/cf-guardian/guardian/gerror"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// State is the state of a generated root filesystem as recorded in its manifest.
+type State string
+
+const (
+	StateGenerating State = "generating" // Generate has started but not finished
+	StateReady      State = "ready"      // Generate has finished successfully
+	StateRemoving   State = "removing"   // Remove has started but not finished
+	StateRetained   State = "retained"   // the root filesystem has been removed but its read-write layer has been kept
+)
+
+const (
+	manifestSuffix   = ".json"
+	manifestFileMode = os.FileMode(0600)
+)
+
+/*
+	A Manifest records a generated root filesystem. A manifest is stored in the read-write
+	base directory alongside the mount directory of the root filesystem so that the
+	root filesystem can be found and, if necessary, cleaned up after a restart.
+*/
+type Manifest struct {
+	// Root is the path of the generated root filesystem.
+	Root string `json:"root"`
+
+	// Prototype is the path of the prototype from which the root filesystem was generated.
+	Prototype string `json:"prototype"`
+
+	// RwLayer is the path of the read-write layer directory.
+	RwLayer string `json:"rw_layer"`
+
+	// Strategy is the strategy used to construct the root filesystem.
+	Strategy Strategy `json:"strategy"`
+
+	// Mounts lists the mount points of the root filesystem in the order in which they are mounted.
+	Mounts []string `json:"mounts"`
+
+	// State is the state of the root filesystem.
+	State State `json:"state"`
+}
+
+// manifestPath returns the path of the manifest of the given root filesystem.
+func manifestPath(root string) string {
+	return root + manifestSuffix
+}
+
+/*
+	writeManifest writes the given manifest. The manifest is written to a temporary file which is then
+	renamed so that a crash cannot leave a partially written manifest.
+*/
+func writeManifest(m *Manifest) gerror.Gerror {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return gerror.NewFromError(ErrWriteManifest, err)
+	}
+	path := manifestPath(m.Root)
+	tempPath := path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, manifestFileMode); err != nil {
+		return gerror.NewFromError(ErrWriteManifest, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return gerror.NewFromError(ErrWriteManifest, err)
+	}
+	return nil
+}
+
+// readManifest reads the manifest of the given root filesystem. If there is no manifest, nil is returned.
+func readManifest(root string) (*Manifest, gerror.Gerror) {
+	data, err := ioutil.ReadFile(manifestPath(root))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, gerror.NewFromError(ErrReadManifest, err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, gerror.NewFromError(ErrReadManifest, err)
+	}
+	return m, nil
+}
+
+func removeManifest(root string) gerror.Gerror {
+	if err := os.Remove(manifestPath(root)); err != nil && !os.IsNotExist(err) {
+		return gerror.NewFromError(ErrRemoveManifest, err)
+	}
+	return nil
+}
+
+// readManifests reads all the manifests in the given read-write base directory, ordered by root.
+func readManifests(rwBaseDir string) ([]Manifest, gerror.Gerror) {
+	paths, err := filepath.Glob(filepath.Join(rwBaseDir, "mnt-*"+manifestSuffix))
+	if err != nil {
+		return nil, gerror.NewFromError(ErrReadManifest, err)
+	}
+	sort.Strings(paths)
+	manifests := []Manifest{}
+	for _, path := range paths {
+		m, gerr := readManifest(path[:len(path)-len(manifestSuffix)])
+		if gerr != nil {
+			return nil, gerr
+		}
+		if m != nil {
+			manifests = append(manifests, *m)
+		}
+	}
+	return manifests, nil
+}
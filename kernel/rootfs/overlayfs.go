@@ -0,0 +1,203 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/fileutils"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	layerDirMode    os.FileMode = 0755
+	overlayUpperDir             = `.upper`
+	overlayWorkDir              = `.work`
+)
+
+/*
+	overlayStrategy mounts an overlay filesystem with the prototype as the lower directory and
+	upper and work directories in the read-write directory. Nothing is copied from the prototype
+	until it is modified, so directories with the CopyUp policy need no further action.
+
+	An empty read-write directory is then bind mounted over each directory with the EmptyTmp
+	policy so that it is empty and has the correct permissions regardless of the prototype.
+
+	If ids are shifted, the directories with the CopyUp policy are copied into the upper directory
+	before mounting, since the kernel would give files copied up on modification the prototype's owners.
+*/
+type overlayStrategy struct {
+	sc          syscall.SyscallFS
+	f           fileutils.Fileutils
+	propagation syscall.Propagation
+}
+
+func (ovs *overlayStrategy) mount(prototype string, root string, rwPath string, dirs []RwDir, shift *idShift) gerror.Gerror {
+	upperDir, workDir := overlayLayout(rwPath)
+	for _, dir := range []string{upperDir, workDir} {
+		if err := os.Mkdir(dir, layerDirMode); err != nil {
+			return gerror.NewFromError(ErrCreateLayerDir, err)
+		}
+	}
+	if shift != nil {
+		if gerr := ovs.copyUp(prototype, upperDir, dirs, shift); gerr != nil {
+			return gerr
+		}
+	}
+
+	if glog.V(2) {
+		glog.Infof("OverlayMount(%q, %q, %q, %q)", prototype, upperDir, workDir, root)
+	}
+	if err := ovs.sc.OverlayMount(prototype, upperDir, workDir, root); err != nil {
+		glog.Errorf("OverlayMount(%q, %q, %q, %q) failed with: %s", prototype, upperDir, workDir, root, err)
+		return gerror.NewFromError(ErrOverlayMountRoot, err)
+	}
+
+	mounted := []string{root}
+	gerr := setPropagation(ovs.sc, root, ovs.propagation, false)
+	for _, dir := range emptyDirs(dirs) {
+		if gerr != nil {
+			break
+		}
+		if gerr = ovs.mountEmptyDir(dir, root, rwPath, shift); gerr == nil {
+			mounted = append(mounted, filepath.Join(root, dir.Path))
+		}
+	}
+	if gerr != nil {
+		for i := len(mounted) - 1; i >= 0; i-- {
+			if e := ovs.sc.Unmount(mounted[i]); e != nil {
+				glog.Warningf("Encountered %q while recovering from %s", e, gerr)
+			}
+		}
+		return gerr
+	}
+	return nil
+}
+
+/*
+	copyUp copies the directories with the CopyUp policy from the prototype into the given upper directory,
+	creating any missing parent directories with the prototype's permissions, and shifts their ids. The
+	upper directory, whose owners are those of the overlay filesystem's root directory, is also shifted.
+*/
+func (ovs *overlayStrategy) copyUp(prototype string, upperDir string, dirs []RwDir, shift *idShift) gerror.Gerror {
+	if gerr := shift.file(upperDir, ""); gerr != nil {
+		return gerr
+	}
+	for _, dir := range dirs {
+		if dir.Policy&EmptyTmp != 0 {
+			continue
+		}
+		parts := strings.Split(dir.Path, string(filepath.Separator))
+		for i := 1; i < len(parts); i++ {
+			parent := filepath.Join(parts[:i]...)
+			if gerr := ovs.copyUpDir(filepath.Join(upperDir, parent), prototype, parent, shift); gerr != nil {
+				return gerr
+			}
+		}
+		dirPath := filepath.Join(upperDir, dir.Path)
+		if ovs.f.Exists(dirPath) {
+			continue
+		}
+		if gerr := ovs.f.Copy(dirPath, filepath.Join(prototype, dir.Path)); gerr != nil {
+			return gerror.NewFromError(ErrOverlayDir, gerr)
+		}
+		if gerr := shift.copyTree(dirPath, dir.Path); gerr != nil {
+			return gerr
+		}
+	}
+	return nil
+}
+
+/*
+	copyUpDir creates the given directory, unless it exists, with the permissions and shifted owners of the
+	directory of the given prototype at the given relative path.
+*/
+func (ovs *overlayStrategy) copyUpDir(path string, prototype string, rel string, shift *idShift) gerror.Gerror {
+	if ovs.f.Exists(path) {
+		return nil
+	}
+	mode, gerr := ovs.f.Filemode(filepath.Join(prototype, rel))
+	if gerr != nil {
+		return gerror.NewFromError(ErrCreateLayerDir, gerr)
+	}
+	if err := os.Mkdir(path, mode.Perm()); err != nil {
+		return gerror.NewFromError(ErrCreateLayerDir, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return gerror.NewFromError(ErrCreateLayerDir, err)
+	}
+	return shift.file(path, rel)
+}
+
+func (ovs *overlayStrategy) mountEmptyDir(dir RwDir, root string, rwPath string, shift *idShift) gerror.Gerror {
+	dirPath := filepath.Join(rwPath, dir.Path)
+	if gerr := makeTempDir(dirPath); gerr != nil {
+		return gerr
+	}
+	if shift != nil {
+		if gerr := shift.root(dirPath); gerr != nil {
+			return gerr
+		}
+	}
+	mntPath := filepath.Join(root, dir.Path)
+	if err := ovs.sc.BindMountReadWrite(dirPath, mntPath); err != nil {
+		glog.Errorf("BindMountReadWrite(%q, %q) failed with: %s", dirPath, mntPath, err)
+		return gerror.NewFromError(ErrBindMountSubdir, err)
+	}
+	return nil
+}
+
+func (ovs *overlayStrategy) mountPoints(root string, dirs []RwDir) []string {
+	mounts := []string{root}
+	for _, dir := range emptyDirs(dirs) {
+		mounts = append(mounts, filepath.Join(root, dir.Path))
+	}
+	return mounts
+}
+
+// emptyDirs returns the given directories which have the EmptyTmp policy.
+func emptyDirs(dirs []RwDir) []RwDir {
+	empty := []RwDir{}
+	for _, dir := range dirs {
+		if dir.Policy&EmptyTmp != 0 {
+			empty = append(empty, dir)
+		}
+	}
+	return empty
+}
+
+// overlayLayout returns the paths of the upper and work directories in the given read-write directory.
+func overlayLayout(rwPath string) (upperDir string, workDir string) {
+	return filepath.Join(rwPath, overlayUpperDir), filepath.Join(rwPath, overlayWorkDir)
+}
+
+/*
+	makeTempDir creates a directory, and any missing parents, with the permissions of a temporary
+	directory.
+*/
+func makeTempDir(path string) gerror.Gerror {
+	if err := os.MkdirAll(filepath.Dir(path), layerDirMode); err != nil {
+		return gerror.NewFromError(ErrOverlayTempDir, err)
+	}
+	if err := os.Mkdir(path, tempDirMode); err != nil {
+		return gerror.NewFromError(ErrOverlayTempDir, err)
+	}
+	return nil
+}
@@ -20,13 +20,16 @@ Package rootfs encapsulates the container's root filesystem.
 package rootfs
 
 import (
+	"fmt"
 	"github.com/cf-guardian/guardian/gerror"
 	"github.com/cf-guardian/guardian/kernel/fileutils"
 	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
 	"github.com/golang/glog"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	trueSyscall "syscall"
 )
 
 // ErrorId is used for error ids relating to the RootFS interface.
@@ -43,6 +46,22 @@ const (
 	ErrOverlayDir
 	ErrRemoveMountDir
 	ErrUnmountRoot
+	ErrCreateLayerDir   // a directory for the upper or work layer of an overlay filesystem could not be created
+	ErrOverlayMountRoot // the overlay filesystem could not be mounted
+	ErrRemoveRwLayer    // the read-write layer directory could not be removed
+	ErrWriteManifest    // the manifest of a root filesystem could not be written
+	ErrReadManifest     // the manifest of a root filesystem could not be read
+	ErrRemoveManifest   // the manifest of a root filesystem could not be removed
+	ErrRemoveOrphan     // a directory left behind by an incomplete Generate could not be removed
+	ErrInvalidDir       // a read-write directory path is not a clean relative path
+	ErrSetPropagation   // the propagation of the generated root filesystem could not be set
+	ErrEnterPropagation // the mounts of the calling process could not be made private before entering a root filesystem
+	ErrEnterBindRoot    // a root filesystem could not be bind mounted onto itself
+	ErrEnterChdir       // the working directory could not be changed while entering a root filesystem
+	ErrPivotRoot        // pivot_root into a root filesystem failed
+	ErrUnmountOldRoot   // the old root could not be detached after pivot_root
+	ErrShiftIds         // the owners of files in the read-write layer could not be changed into a user namespace's range
+	ErrMountTable       // the mount table could not be read to check the mount points of a root filesystem
 )
 
 type RootFS interface {
@@ -55,11 +74,14 @@ type RootFS interface {
 		the prototype.
 
 		The resultant filesystem is a collection of read-write
-		directories overlaid on the prototype. If an overlay filesystem,
-		such as `aufs` or `overlayfs`, is available, it will be used to
-		create the result. Otherwise, the result is a mounted filesystem
-		consisting of a patchwork quilt of read-write temporary directories
-		and the prototype. TODO: decide which of these to support.
+		directories, given by DefaultDirs, overlaid on the prototype. How the result is
+		constructed depends on the Strategy of the RootFS instance.
+		With OverlayStrategy, the result is an `overlayfs` mount with the
+		prototype as its lower directory. With BindMountStrategy, the
+		result is a mounted filesystem consisting of a patchwork quilt of
+		read-write temporary directories and the prototype. The
+		generated root filesystem and the mounts under it are private
+		unless Options specifies a different propagation.
 
 		If Generate fails, it has no side-effects other than possibly
 		creating some directories in the read-write base directory.
@@ -75,37 +97,177 @@ type RootFS interface {
 	Generate(prototype string) (string, gerror.Gerror)
 
 	/*
-		Remove a previously generated root filesystem.
-	 */
+		GenerateWithOptions produces a usable root filesystem instance from
+		a prototype as for Generate but with the given options.
+	*/
+	GenerateWithOptions(prototype string, opts GenerateOptions) (string, gerror.Gerror)
+
+	/*
+		Remove a previously generated root filesystem. The root filesystem
+		is unmounted and both its mount directory and its read-write layer
+		directory are deleted.
+	*/
 	Remove(root string) gerror.Gerror
+
+	/*
+		RemoveWithOptions removes a previously generated root filesystem as
+		for Remove but with the given options.
+	*/
+	RemoveWithOptions(root string, opts RemoveOptions) gerror.Gerror
+
+	/*
+		List returns the manifests of the root filesystems generated in the
+		read-write base directory, including any left behind by an earlier
+		process, ordered by root.
+	*/
+	List() ([]Manifest, gerror.Gerror)
+
+	/*
+		Recover inspects the root filesystems generated in the read-write base
+		directory, typically after a restart. Root filesystems which were
+		successfully generated and are intact, with all their mount points
+		still mounted, are re-adopted so that they may be removed as usual.
+		Root filesystems which were part way through Generate or Remove, or
+		which are no longer mounted, are unmounted and deleted, as are any directories
+		in the read-write base directory which were created by Generate but
+		are not recorded in a manifest. The read-write layers of root
+		filesystems removed with KeepRwLayer are left untouched.
+
+		The return values are the manifests of the re-adopted root
+		filesystems and the first error encountered. Recover continues
+		after an error so that as much as possible is cleaned up.
+	*/
+	Recover() ([]Manifest, gerror.Gerror)
+}
+
+// GenerateOptions modify the behaviour of GenerateWithOptions. The zero value gives the behaviour of Generate.
+type GenerateOptions struct {
+	/*
+		Dirs specifies the directories of the generated root filesystem which are read-write
+		and how each is set up. A directory must follow any directory which contains it.
+		If Dirs is nil, DefaultDirs() is used.
+	*/
+	Dirs []RwDir
+
+	/*
+		Uids and Gids are the ID mappings of a user namespace in which the generated root filesystem is
+		used. If either is non-nil, the files copied into the read-write directories are given the owners
+		of the prototype's files as seen in the namespace, and the EmptyTmp directories are owned by the
+		namespace's root, so that the namespace's users can write to their own files. With
+		OverlayStrategy, the CopyUp directories are then copied into the upper directory so that their
+		owners can be changed.
+	*/
+	Uids []syscall.IdMapping
+	Gids []syscall.IdMapping
+}
+
+// RemoveOptions modify the behaviour of RemoveWithOptions. The zero value gives the behaviour of Remove.
+type RemoveOptions struct {
+	// KeepRwLayer preserves the read-write layer directory, for example for post-mortem debugging.
+	KeepRwLayer bool
 }
 
 // ImplErrorId is used for error ids relating to the implementation of this package.
 type ImplErrorId int
 
 const (
-	ErrNilSyscallFS ImplErrorId = iota // the SyscallFS value was nil
-	ErrRwBaseDirMissing                // the read-write base directory was not found
-	ErrRwBaseDirIsFile                 // a file was found instead of the read-write base directory
-	ErrRwBaseDirNotRw                  // the read-write base directory does not have read and write permissions
+	ErrNilSyscallFS     ImplErrorId = iota // the SyscallFS value was nil
+	ErrRwBaseDirMissing                    // the read-write base directory was not found
+	ErrRwBaseDirIsFile                     // a file was found instead of the read-write base directory
+	ErrRwBaseDirNotRw                      // the read-write base directory does not have read and write permissions
+	ErrInvalidStrategy                     // the strategy is not one of the defined values
+	ErrProbeOverlay                        // the kernel could not be probed for overlay filesystem support
+)
+
+// Strategy selects how a RootFS instance constructs generated root filesystems.
+type Strategy int
+
+const (
+	AutoStrategy      Strategy = iota // use OverlayStrategy if the kernel supports overlayfs, otherwise BindMountStrategy
+	BindMountStrategy                 // bind mount copies of some prototype directories over a read-only bind mount of the prototype
+	OverlayStrategy                   // mount an overlayfs with the prototype as the lower directory
 )
 
+func (s Strategy) String() string {
+	switch s {
+	case AutoStrategy:
+		return "AutoStrategy"
+	case BindMountStrategy:
+		return "BindMountStrategy"
+	case OverlayStrategy:
+		return "OverlayStrategy"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// Options modify the behaviour of a RootFS instance. The zero value gives the default behaviour.
+type Options struct {
+	// Strategy forces the given strategy. AutoStrategy probes the kernel for overlayfs support.
+	Strategy Strategy
+
+	/*
+		Propagation is the mount propagation of generated root filesystems and the mounts under them.
+		The zero value selects syscall.PropagationPrivate so that mounts made in a generated root
+		filesystem do not appear elsewhere and mounts made elsewhere do not appear in it.
+	*/
+	Propagation syscall.Propagation
+
+	/*
+		Unmount modifies how Remove and Recover unmount generated root filesystems, for example to retry
+		while a mount is busy or to detach a mount which is still in use.
+	*/
+	Unmount syscall.UnmountOptions
+
+	/*
+		MountTable is used by Recover to check that the mount points of generated root filesystems are
+		still mounted. If MountTable is nil, the mount table of the current process is used.
+	*/
+	MountTable syscall.MountTable
+}
+
 const tempDirMode os.FileMode = 0777
 
-type rootfs struct {
-	sc        syscall.SyscallFS
-	f         fileutils.Fileutils
-	rwBaseDir string
+/*
+	A strategy mounts a root filesystem generated from a prototype and a read-write directory.
+*/
+type strategy interface {
+	/*
+		mount mounts the root filesystem at root with the given read-write directories, all of which
+		are present in the prototype, shifting the ids of the read-write directories unless shift is
+		nil. If mount fails, it undoes any mounts it has made.
+	*/
+	mount(prototype string, root string, rwPath string, dirs []RwDir, shift *idShift) gerror.Gerror
+
+	// mountPoints returns the mount points made by mount, in the order in which they are mounted.
+	mountPoints(root string, dirs []RwDir) []string
 }
 
-var rootSubdirs []string = []string{`proc`, `dev`, `etc`, `home`, `sbin`, `var`, `tmp`}
+type rootfs struct {
+	sc          syscall.SyscallFS
+	f           fileutils.Fileutils
+	rwBaseDir   string
+	strategy    strategy
+	chosen      Strategy
+	propagation syscall.Propagation
+	unmountOpts syscall.UnmountOptions
+	mt          syscall.MountTable
+}
 
 /*
 	Creates a new RootFS instance which uses the given SyscallFS interface and the given read-write
 	directory as a base for the writable portion of generated root filesystems. The SyscallFS interface value
 	must not be nil to ensure that the caller has sufficient privileges to perform mounts, etc.
+
+	The strategy is chosen by probing the kernel for overlayfs support.
 */
 func NewRootFS(sc syscall.SyscallFS, f fileutils.Fileutils, rwBaseDir string) (RootFS, gerror.Gerror) {
+	return NewRootFSWithOptions(sc, f, rwBaseDir, Options{})
+}
+
+/*
+	Creates a new RootFS instance as for NewRootFS but with the given options.
+*/
+func NewRootFSWithOptions(sc syscall.SyscallFS, f fileutils.Fileutils, rwBaseDir string, opts Options) (RootFS, gerror.Gerror) {
 	if sc == nil {
 		return nil, gerror.New(ErrNilSyscallFS, "nil SyscallFS")
 	}
@@ -121,168 +283,325 @@ func NewRootFS(sc syscall.SyscallFS, f fileutils.Fileutils, rwBaseDir string) (R
 			"Read-write base directory does not have read and write permissions: %q has permissions %q",
 			rwBaseDir, fileMode.String())
 	}
-	return &rootfs{sc, f, rwBaseDir}, nil
+	chosen, gerr := chooseStrategy(sc, opts.Strategy)
+	if gerr != nil {
+		return nil, gerr
+	}
+	if glog.V(1) {
+		glog.Infof("Using %s for root filesystems in %q", chosen, rwBaseDir)
+	}
+	propagation := opts.Propagation
+	if propagation == 0 {
+		propagation = syscall.PropagationPrivate
+	}
+	mt := opts.MountTable
+	if mt == nil {
+		mt = syscall_linux.NewMountTable()
+	}
+	rfs := &rootfs{sc: sc, f: f, rwBaseDir: rwBaseDir, chosen: chosen, propagation: propagation,
+		unmountOpts: opts.Unmount, mt: mt}
+	switch chosen {
+	case BindMountStrategy:
+		rfs.strategy = &bindMountStrategy{sc, f, propagation}
+	case OverlayStrategy:
+		rfs.strategy = &overlayStrategy{sc, f, propagation}
+	}
+	return rfs, nil
+}
+
+// chooseStrategy resolves AutoStrategy by probing the kernel for overlayfs support.
+func chooseStrategy(sc syscall.SyscallFS, strategy Strategy) (Strategy, gerror.Gerror) {
+	switch strategy {
+	case BindMountStrategy, OverlayStrategy:
+		return strategy, nil
+	case AutoStrategy:
+		supported, err := sc.FilesystemSupported("overlay")
+		if err != nil {
+			return strategy, gerror.NewFromError(ErrProbeOverlay, err)
+		}
+		if supported {
+			return OverlayStrategy, nil
+		}
+		return BindMountStrategy, nil
+	}
+	return strategy, gerror.Newf(ErrInvalidStrategy, "Invalid strategy %s", strategy)
 }
 
-func (rfs *rootfs) Generate(prototype string) (root string, gerr gerror.Gerror) {
+func (rfs *rootfs) Generate(prototype string) (string, gerror.Gerror) {
+	return rfs.GenerateWithOptions(prototype, GenerateOptions{})
+}
+
+func (rfs *rootfs) GenerateWithOptions(prototype string, opts GenerateOptions) (root string, gerr gerror.Gerror) {
 	if glog.V(1) {
-		glog.Infof("Generate(%q)", prototype)
+		glog.Infof("GenerateWithOptions(%q, %+v)", prototype, opts)
+	}
+	dirs := opts.Dirs
+	if dirs == nil {
+		dirs = DefaultDirs()
+	}
+	if gerr := validateDirs(dirs); gerr != nil {
+		return "", gerr
+	}
+	dirs, gerr = rfs.presentDirs(prototype, dirs)
+	if gerr != nil {
+		return "", gerr
 	}
+
 	defer func() {
 		if gerr != nil {
 			root = ""
 		}
 	}()
 
-	var cleanup = func(capture gerror.Gerror, undo func()) {
-		if capture == nil && gerr != nil {
+	var cleanup = func(undo func()) {
+		if gerr != nil {
 			undo()
-		} else {
-			gerr = capture
 		}
 	}
 
-	var rwPath string
-
-	if gerr == nil {
-		var err error
-		rwPath, err = ioutil.TempDir(rfs.rwBaseDir, "tmp-rootfs-")
-		defer cleanup(gerror.NewFromError(ErrCreateTempDir, err), func() {
-				if e := os.RemoveAll(rwPath); e != nil {
-					glog.Warningf("Encountered %q while recovering from %s", e, gerr)
-				}
-			})
+	rwPath, err := ioutil.TempDir(rfs.rwBaseDir, "tmp-rootfs-")
+	if err != nil {
+		return "", gerror.NewFromError(ErrCreateTempDir, err)
 	}
+	defer cleanup(func() {
+		if e := os.RemoveAll(rwPath); e != nil {
+			glog.Warningf("Encountered %q while recovering from %s", e, gerr)
+		}
+	})
 
-	if gerr == nil {
-		var err error
-		root, err = ioutil.TempDir(rfs.rwBaseDir, "mnt-")
-		defer cleanup(gerror.NewFromError(ErrCreateMountDir, err), func() {
-				if e := os.RemoveAll(root); e != nil {
-					glog.Warningf("Encountered %q while recovering from %s", e, gerr)
-				}
-			})
+	root, err = ioutil.TempDir(rfs.rwBaseDir, "mnt-")
+	if err != nil {
+		return "", gerror.NewFromError(ErrCreateMountDir, err)
 	}
-
-	if gerr == nil {
-		err := rfs.sc.BindMountReadOnly(prototype, root)
-		if err != nil {
-			glog.Errorf("BindMountReadOnly(%q, %q) failed with: %s", prototype, root, err)
+	defer cleanup(func() {
+		if e := os.RemoveAll(root); e != nil {
+			glog.Warningf("Encountered %q while recovering from %s", e, gerr)
 		}
-		defer cleanup(gerror.NewFromError(ErrBindMountRoot, err), func() {
-				if glog.V(1) {
-					glog.Infof("unmounting %q", root)
-				}
-				if e := rfs.sc.Unmount(root); e != nil {
-					glog.Warningf("Encountered %q while recovering from %s", e, gerr)
-				}
-			})
+	})
+
+	m := &Manifest{
+		Root:      root,
+		Prototype: prototype,
+		RwLayer:   rwPath,
+		Strategy:  rfs.chosen,
+		Mounts:    rfs.strategy.mountPoints(root, dirs),
+		State:     StateGenerating,
+	}
+	if gerr = writeManifest(m); gerr != nil {
+		return
 	}
+	defer cleanup(func() {
+		if e := removeManifest(root); e != nil {
+			glog.Warningf("Encountered %q while recovering from %s", e, gerr)
+		}
+	})
 
-	if gerr == nil {
-		gerr = rfs.overlay(root, rwPath)
+	if gerr = rfs.strategy.mount(prototype, root, rwPath, dirs, newIdShift(prototype, opts)); gerr != nil {
+		return
 	}
 
+	// The strategy set the propagation of the root before mounting anything under it. Mounts under the root
+	// may have joined the peer groups of their sources, so set their propagation too.
+	if gerr = setPropagation(rfs.sc, root, rfs.propagation, true); gerr == nil {
+		m.State = StateReady
+		gerr = writeManifest(m)
+	}
+	if gerr != nil {
+		if e := rfs.unmountAll(root, m.Mounts, false); e != nil {
+			glog.Warningf("Encountered %q while recovering from %s", e, gerr)
+		}
+	}
 	return
 }
 
-func (rfs *rootfs) overlay(root string, rwPath string) gerror.Gerror {
-	if glog.V(2) {
-		glog.Infof("overlay(%q, %q)", root, rwPath)
+/*
+	setPropagation sets the propagation of the given mount point and, if recursive is true, of the mount points
+	under it.
+*/
+func setPropagation(sc syscall.SyscallFS, mountPoint string, propagation syscall.Propagation, recursive bool) gerror.Gerror {
+	if err := sc.SetPropagation(mountPoint, propagation, recursive); err != nil {
+		glog.Errorf("SetPropagation(%q, %s, %v) failed with: %s", mountPoint, propagation, recursive, err)
+		return gerror.NewFromError(ErrSetPropagation, err)
 	}
+	return nil
+}
 
-	// Create a tmp directory so it will end up empty and with the correct permissions regardless of the tmp
-	// directory contents and permissions in the prototype root filesystem.
-	tmpDir := filepath.Join(rwPath, `tmp`)
-	if err := os.Mkdir(tmpDir, tempDirMode); err != nil {
-		return gerror.NewFromError(ErrOverlayTempDir, err)
-	}
+func (rfs *rootfs) Remove(root string) gerror.Gerror {
+	return rfs.RemoveWithOptions(root, RemoveOptions{})
+}
 
-	for i, dir := range rootSubdirs {
-		if gerr := rfs.overlayDirectory(dir, root, rwPath); gerr != nil {
-			for j := i - 1; j >= 0; j-- {
-				if cleanupGerr := rfs.unmountOverlayDirectory(rootSubdirs[j], root); cleanupGerr != nil {
-					glog.Warningf("Encountered %q while recovering from %q", cleanupGerr, gerr)
-				}
-			}
+func (rfs *rootfs) RemoveWithOptions(root string, opts RemoveOptions) gerror.Gerror {
+	if glog.V(1) {
+		glog.Infof("RemoveWithOptions(%q, %+v)", root, opts)
+	}
+	m, gerr := readManifest(root)
+	if gerr != nil {
+		return gerr
+	}
+	if m == nil {
+		glog.Warningf("No manifest found for %q: assuming it was generated using %s", root, rfs.chosen)
+		m = &Manifest{Root: root, Strategy: rfs.chosen, Mounts: rfs.strategy.mountPoints(root, DefaultDirs())}
+	} else {
+		m.State = StateRemoving
+		if gerr := writeManifest(m); gerr != nil {
 			return gerr
 		}
 	}
-	return nil
+	return rfs.remove(m, opts, false)
 }
 
-func (rfs *rootfs) removeOverlay(root string) gerror.Gerror {
+/*
+	remove unmounts the given root filesystem and deletes its mount directory and, unless the given options
+	specify otherwise, its read-write layer. If tolerant is true, mount points which are not mounted are
+	ignored.
+*/
+func (rfs *rootfs) remove(m *Manifest, opts RemoveOptions, tolerant bool) gerror.Gerror {
+	root := m.Root
+	if gerr := rfs.unmountAll(root, m.Mounts, tolerant); gerr != nil {
+		return gerr
+	}
+	if err := os.RemoveAll(root); err != nil {
+		glog.Errorf("Failed to remove %q: %s", root, err)
+		return gerror.NewFromError(ErrRemoveMountDir, err)
+	}
+
+	if m.RwLayer == "" {
+		glog.Warningf("Read-write layer of %q is not known and has not been removed", root)
+		return nil
+	}
+	if opts.KeepRwLayer {
+		glog.Infof("Keeping read-write layer %q of removed root filesystem %q", m.RwLayer, root)
+		m.State = StateRetained
+		return writeManifest(m)
+	}
+	if err := os.RemoveAll(m.RwLayer); err != nil {
+		glog.Errorf("Failed to remove %q: %s", m.RwLayer, err)
+		return gerror.NewFromError(ErrRemoveRwLayer, err)
+	}
+	return removeManifest(root)
+}
+
+/*
+	unmountAll unmounts the given mount points of the given root filesystem in reverse order. If any
+	mount point other than the root fails to unmount, the remaining mount points other than the root are
+	unmounted and the first error is returned. If tolerant is true, mount points which are not mounted
+	are ignored.
+*/
+func (rfs *rootfs) unmountAll(root string, mounts []string, tolerant bool) gerror.Gerror {
 	var firstGerr gerror.Gerror
-	for i, _ := range rootSubdirs {
-		// Reverse the range order.
-		dir := rootSubdirs[len(rootSubdirs) - i - 1]
-		if gerr := rfs.unmountOverlayDirectory(dir, root); gerr != nil {
-			glog.Errorf("Encountered %s while removing overlay from %q", gerr, root)
-			if firstGerr == nil {
-				firstGerr = gerr
-			}
+	for i := len(mounts) - 1; i >= 0; i-- {
+		mntPath := mounts[i]
+		if mntPath == root && firstGerr != nil {
+			break
+		}
+		if glog.V(2) {
+			glog.Infof("unmounting %q", mntPath)
+		}
+		err := rfs.sc.UnmountWithOptions(mntPath, rfs.unmountOpts)
+		if err == nil || tolerant && err == trueSyscall.EINVAL {
+			continue
+		}
+		glog.Errorf("Unmounting %q failed: %s", mntPath, err)
+		if mntPath == root {
+			return gerror.NewFromError(ErrUnmountRoot, err)
+		}
+		if firstGerr == nil {
+			firstGerr = gerror.NewFromError(ErrUnmountSubdir, err)
 		}
 	}
 	return firstGerr
 }
 
-func (rfs *rootfs) overlayDirectory(dir string, root string, rwPath string) gerror.Gerror {
-	if glog.V(2) {
-		glog.Infof("overlayDirectory(%q, %q, %q)", dir, root, rwPath)
+func (rfs *rootfs) List() ([]Manifest, gerror.Gerror) {
+	return readManifests(rfs.rwBaseDir)
+}
+
+func (rfs *rootfs) Recover() ([]Manifest, gerror.Gerror) {
+	if glog.V(1) {
+		glog.Infof("Recover() in %q", rfs.rwBaseDir)
 	}
-	mntPath := filepath.Join(root, dir)
-	// check mntPath exists
-	if !rfs.f.Exists(mntPath) {
-		glog.Errorf("Directory %q not present in root file system (%q)", dir, root)
-		return gerror.Newf(ErrRootSubdirMissing, "Directory %q not present in root file system (%q)", dir, root)
+	manifests, gerr := readManifests(rfs.rwBaseDir)
+	if gerr != nil {
+		return nil, gerr
 	}
 
-	dirPath := filepath.Join(rwPath, dir)
-
-	// Set up read-write directory, copying mount directory contents if there are any.
-	if !rfs.f.Exists(dirPath) {
-		if err := rfs.f.Copy(dirPath, mntPath); err != nil {
-			return gerror.NewFromError(ErrOverlayDir, err)
+	var firstGerr gerror.Gerror
+	record := func(gerr gerror.Gerror) {
+		if gerr != nil {
+			glog.Errorf("Recover encountered %s", gerr)
+			if firstGerr == nil {
+				firstGerr = gerr
+			}
 		}
 	}
 
-	if glog.V(2) {
-		glog.Infof("BindMountReadWrite(%q, %q)", dirPath, mntPath)
-	}
-	err := rfs.sc.BindMountReadWrite(dirPath, mntPath)
-	if err != nil {
-		glog.Errorf("BindMountReadWrite(%q, %q) failed with: %s", dirPath, mntPath, err)
-		return gerror.NewFromError(ErrBindMountSubdir, err)
+	adopted := []Manifest{}
+	known := make(map[string]bool)
+	for i := range manifests {
+		m := &manifests[i]
+		known[m.Root] = true
+		known[m.RwLayer] = true
+		switch {
+		case m.State == StateRetained:
+			continue
+		case m.State == StateReady && rfs.f.Exists(m.Root) && rfs.f.Exists(m.RwLayer):
+			mounted, gerr := rfs.mounted(m)
+			if gerr != nil {
+				// Leave the root filesystem alone since it may still be in use.
+				record(gerr)
+				continue
+			}
+			if mounted {
+				glog.Infof("Re-adopting root filesystem %q", m.Root)
+				adopted = append(adopted, *m)
+				continue
+			}
+			glog.Infof("Removing root filesystem %q which is no longer mounted", m.Root)
+			record(rfs.remove(m, RemoveOptions{}, true))
+		default:
+			glog.Infof("Removing root filesystem %q left in state %q", m.Root, m.State)
+			record(rfs.remove(m, RemoveOptions{}, true))
+		}
 	}
-	return nil
-}
 
-func (rfs *rootfs) unmountOverlayDirectory(dir string, root string) gerror.Gerror {
-	if glog.V(2) {
-		glog.Infof("unmountOverlayDirectory(%q, %q)", dir, root)
-	}
-	mntPath := filepath.Join(root, dir)
-	err := rfs.sc.Unmount(mntPath)
-	if err != nil {
-		return gerror.NewFromError(ErrUnmountSubdir, err)
+	// Remove directories created by Generate before their manifest was written, along with any partially
+	// written manifests. Nothing is mounted on these directories and so mount directories, which must be
+	// empty, are not removed recursively.
+	for _, pattern := range []string{"mnt-*", "tmp-rootfs-*"} {
+		paths, err := filepath.Glob(filepath.Join(rfs.rwBaseDir, pattern))
+		if err != nil {
+			record(gerror.NewFromError(ErrRemoveOrphan, err))
+			continue
+		}
+		for _, path := range paths {
+			if known[path] || filepath.Ext(path) == manifestSuffix {
+				continue
+			}
+			glog.Infof("Removing orphan %q", path)
+			var err error
+			if pattern == "mnt-*" {
+				err = os.Remove(path)
+			} else {
+				err = os.RemoveAll(path)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				record(gerror.NewFromError(ErrRemoveOrphan, err))
+			}
+		}
 	}
-	return nil
+	return adopted, firstGerr
 }
 
-func (rfs *rootfs) Remove(root string) gerror.Gerror {
-	if glog.V(1) {
-		glog.Infof("Remove(%q)", root)
-	}
-	if gerr := rfs.removeOverlay(root); gerr != nil {
-		return gerr
-	}
-	if err := rfs.sc.Unmount(root); err != nil {
-		glog.Errorf("Unmounting %q failed: %s", root, err)
-		return gerror.NewFromError(ErrUnmountRoot, err)
-	}
-	if err := os.RemoveAll(root); err != nil {
-		glog.Errorf("Failed to remove %q: %s", root, err)
-		return gerror.NewFromError(ErrRemoveMountDir, err)
+// mounted returns true if and only if the root and the other mount points of the given root filesystem are mounted.
+func (rfs *rootfs) mounted(m *Manifest) (bool, gerror.Gerror) {
+	for _, path := range append([]string{m.Root}, m.Mounts...) {
+		ok, err := rfs.mt.IsMountPoint(path)
+		if err != nil {
+			return false, gerror.NewFromError(ErrMountTable, err)
+		}
+		if !ok {
+			return false, nil
+		}
 	}
-	return nil
+	return true, nil
 }
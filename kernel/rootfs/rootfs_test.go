@@ -18,17 +18,23 @@ package rootfs_test
 
 import (
 	"code.google.com/p/gomock/gomock"
+	"encoding/json"
 	"errors"
 	"github.com/cf-guardian/guardian/gerror"
 	"github.com/cf-guardian/guardian/kernel/fileutils"
 	"github.com/cf-guardian/guardian/kernel/fileutils/mock_fileutils"
 	"github.com/cf-guardian/guardian/kernel/rootfs"
+	gsyscall "github.com/cf-guardian/guardian/kernel/syscall"
 	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
 	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestNilSyscallFS(t *testing.T) {
@@ -97,20 +103,22 @@ func TestGenerate(t *testing.T) {
 	defer test_support.CleanupDirs(t, tempDir)
 
 	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(false, nil)
 	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
 	if gerr != nil {
 		t.Errorf("%s", gerr)
 		return
 	}
 	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
 
 	mockSyscallFS.EXPECT().BindMountReadOnly(prototypeDir, test_support.NewStringPrefixMatcher(filepath.Join(tempDir, "mnt")))
+	expectSetPropagation(mockSyscallFS, test_support.NewStringRegexMatcher(filepath.Join(tempDir, `mnt-[^/]*$`)), gsyscall.PropagationPrivate)
 
 	for _, dir := range test_support.RootFSDirs() {
 		srcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", dir))
 		mntMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", dir))
 		mockFileUtils.EXPECT().Exists(srcMatcher).Return(true).AnyTimes()
-		mockFileUtils.EXPECT().Exists(mntMatcher).Return(true).AnyTimes()
 		mockSyscallFS.EXPECT().BindMountReadWrite(srcMatcher, mntMatcher)
 	}
 
@@ -142,15 +150,18 @@ func testGenerateBackoutAfterBindMountReadWriteError(i int, t *testing.T) {
 	defer test_support.CleanupDirs(t, tempDir)
 
 	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(false, nil)
 	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
 	if gerr != nil {
 		t.Errorf("%s", gerr)
 		return
 	}
 	prototypeDir := filepath.Join(tempDir, "test-prototype")
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
 
 	mainMountPointMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, `mnt-[\d]*$`))
 	mockSyscallFS.EXPECT().BindMountReadOnly(prototypeDir, mainMountPointMatcher)
+	mockSyscallFS.EXPECT().SetPropagation(mainMountPointMatcher, gsyscall.PropagationPrivate, false)
 	mockSyscallFS.EXPECT().Unmount(mainMountPointMatcher)
 
 	dirs := test_support.RootFSDirs()
@@ -160,7 +171,6 @@ func testGenerateBackoutAfterBindMountReadWriteError(i int, t *testing.T) {
 		srcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", dir))
 		mntMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", dir))
 		mockFileUtils.EXPECT().Exists(srcMatcher).Return(true).AnyTimes()
-		mockFileUtils.EXPECT().Exists(mntMatcher).Return(true).AnyTimes()
 		mockSyscallFS.EXPECT().BindMountReadWrite(srcMatcher, mntMatcher)
 		mockSyscallFS.EXPECT().Unmount(mntMatcher)
 	}
@@ -169,7 +179,6 @@ func testGenerateBackoutAfterBindMountReadWriteError(i int, t *testing.T) {
 	srcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", failingDir))
 	mntMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", failingDir))
 	mockFileUtils.EXPECT().Exists(srcMatcher).Return(true).AnyTimes()
-	mockFileUtils.EXPECT().Exists(mntMatcher).Return(true).AnyTimes()
 	mockSyscallFS.EXPECT().BindMountReadWrite(srcMatcher, mntMatcher).Return(errors.New("an error"))
 
 	root, gerr := rfs.Generate(prototypeDir)
@@ -179,6 +188,361 @@ func testGenerateBackoutAfterBindMountReadWriteError(i int, t *testing.T) {
 	}
 }
 
+func TestGenerateMissingRequiredDir(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.BindMountStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := filepath.Join(tempDir, "test-prototype")
+	mockFileUtils.EXPECT().Exists(filepath.Join(prototypeDir, "home")).Return(false)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	root, gerr := rfs.Generate(prototypeDir)
+	if root != "" || gerr == nil || !gerr.EqualTag(rootfs.ErrRootSubdirMissing) {
+		t.Errorf("Incorrect return values (%s, %s)", root, gerr)
+		return
+	}
+	checkNoLeftovers(t, tempDir)
+}
+
+func TestGenerateWithDirs(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.BindMountStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := filepath.Join(tempDir, "test-prototype")
+	dirs := []rootfs.RwDir{
+		{"etc", rootfs.CopyUp},
+		{"home", rootfs.CopyUp | rootfs.SkipIfMissing},
+		{"run", rootfs.EmptyTmp | rootfs.Required},
+		{"var/tmp", rootfs.EmptyTmp | rootfs.SkipIfMissing},
+	}
+	mockFileUtils.EXPECT().Exists(filepath.Join(prototypeDir, "etc")).Return(true)
+	mockFileUtils.EXPECT().Exists(filepath.Join(prototypeDir, "home")).Return(false)
+	mockFileUtils.EXPECT().Exists(filepath.Join(prototypeDir, "run")).Return(true)
+	mockFileUtils.EXPECT().Exists(filepath.Join(prototypeDir, "var/tmp")).Return(true)
+
+	etcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", "etc$"))
+	mockFileUtils.EXPECT().Exists(etcMatcher).Return(false)
+	mockFileUtils.EXPECT().Copy(etcMatcher, test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", "etc$")))
+	mockSyscallFS.EXPECT().BindMountReadOnly(prototypeDir, gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	for _, dir := range []string{"etc", "run", "var/tmp"} {
+		mockSyscallFS.EXPECT().BindMountReadWrite(
+			test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", dir+"$")),
+			test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", dir+"$")))
+	}
+
+	root, gerr := rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{Dirs: dirs})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 1 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+		return
+	}
+	expectedMounts := []string{root, filepath.Join(root, "etc"), filepath.Join(root, "run"), filepath.Join(root, "var/tmp")}
+	if !reflect.DeepEqual(manifests[0].Mounts, expectedMounts) {
+		t.Errorf("Mounts were %v, expected %v", manifests[0].Mounts, expectedMounts)
+	}
+
+	for _, dir := range []string{"run", "var/tmp"} {
+		if !test_support.FileExists(filepath.Join(manifests[0].RwLayer, dir)) {
+			t.Errorf("Empty directory %q was not created", dir)
+		}
+	}
+}
+
+func TestGenerateShiftIds(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.BindMountStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := createShiftPrototype(t, tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	etcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", "etc$"))
+	mockFileUtils.EXPECT().Exists(etcMatcher).Return(false)
+	mockFileUtils.EXPECT().Copy(etcMatcher, gomock.Any()).Do(func(dest string, src string) {
+		copyPrototypeDir(t, dest, filepath.Join(prototypeDir, "etc"))
+	})
+	mockSyscallFS.EXPECT().BindMountReadOnly(prototypeDir, gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any()).Times(2)
+
+	root, gerr := rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{
+		Dirs: []rootfs.RwDir{{"etc", rootfs.CopyUp}, {"tmp", rootfs.EmptyTmp}},
+		Uids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+		Gids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 65536}},
+	})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 1 || manifests[0].Root != root {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+		return
+	}
+	rwLayer := manifests[0].RwLayer
+	checkOwners(t, filepath.Join(rwLayer, "etc"), 100005, 200006)
+	checkOwners(t, filepath.Join(rwLayer, "etc", "passwd"), 100007, 200008)
+	checkOwners(t, filepath.Join(rwLayer, "etc", "link"), 100009, 200009)
+	checkOwners(t, filepath.Join(rwLayer, "tmp"), 100000, 200000)
+	if mode := test_support.FileMode(filepath.Join(rwLayer, "etc", "passwd")); mode&os.ModeSetuid == 0 {
+		t.Errorf("Set-user-ID bit was cleared (mode %v)", mode)
+	}
+}
+
+func TestGenerateShiftIdsUnmapped(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.BindMountStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := createShiftPrototype(t, tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	etcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", "etc$"))
+	mockFileUtils.EXPECT().Exists(etcMatcher).Return(false)
+	mockFileUtils.EXPECT().Copy(etcMatcher, gomock.Any()).Do(func(dest string, src string) {
+		copyPrototypeDir(t, dest, filepath.Join(prototypeDir, "etc"))
+	})
+	mockSyscallFS.EXPECT().BindMountReadOnly(prototypeDir, gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any())
+
+	// Only ids 0 to 6 are mapped.
+	_, gerr = rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{
+		Dirs: []rootfs.RwDir{{"etc", rootfs.CopyUp}},
+		Uids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 7}},
+		Gids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 7}},
+	})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 1 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+		return
+	}
+	rwLayer := manifests[0].RwLayer
+	checkOwners(t, filepath.Join(rwLayer, "etc"), 100005, 200006)
+	uid, gid := owners(t, filepath.Join(rwLayer, "etc", "passwd"))
+	if uid != uint32(os.Geteuid()) || gid != uint32(os.Getegid()) {
+		t.Errorf("Unmapped owners were changed to (%d, %d)", uid, gid)
+	}
+}
+
+func TestGenerateShiftIdsFailure(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.BindMountStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := createShiftPrototype(t, tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	// The copy contains a file which is not in the prototype.
+	etcMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", "etc$"))
+	mockFileUtils.EXPECT().Exists(etcMatcher).Return(false)
+	mockFileUtils.EXPECT().Copy(etcMatcher, gomock.Any()).Do(func(dest string, src string) {
+		test_support.CreateFile(test_support.CreateDir(filepath.Dir(dest), "etc"), "stray")
+	})
+	rootMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, `mnt-[^/]*$`))
+	mockSyscallFS.EXPECT().BindMountReadOnly(prototypeDir, rootMatcher)
+	mockSyscallFS.EXPECT().SetPropagation(rootMatcher, gsyscall.PropagationPrivate, false)
+	mockSyscallFS.EXPECT().Unmount(rootMatcher)
+
+	_, gerr = rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{
+		Dirs: []rootfs.RwDir{{"etc", rootfs.CopyUp}},
+		Uids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+		Gids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 65536}},
+	})
+	if gerr == nil || !gerr.EqualTag(rootfs.ErrShiftIds) {
+		t.Errorf("Incorrect error %v", gerr)
+	}
+	checkNoLeftovers(t, tempDir)
+}
+
+func TestGenerateOverlayShiftIds(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := createShiftPrototype(t, tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	upperDir := filepath.Join(tempDir, "tmp-rootfs-[^/]*", `\.upper`)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringRegexMatcher(filepath.Join(upperDir, "var$"))).Return(false)
+	mockFileUtils.EXPECT().Filemode(filepath.Join(prototypeDir, "var")).Return(os.ModeDir|os.FileMode(0750), nil)
+	libMatcher := test_support.NewStringRegexMatcher(filepath.Join(upperDir, "var", "lib$"))
+	mockFileUtils.EXPECT().Exists(libMatcher).Return(false)
+	mockFileUtils.EXPECT().Copy(libMatcher, filepath.Join(prototypeDir, "var", "lib")).Do(func(dest string, src string) {
+		copyPrototypeDir(t, dest, src)
+	})
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any())
+
+	_, gerr = rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{
+		Dirs: []rootfs.RwDir{{"var/lib", rootfs.CopyUp}, {"tmp", rootfs.EmptyTmp}},
+		Uids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+		Gids: []gsyscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 65536}},
+	})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 1 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+		return
+	}
+	rwLayer := manifests[0].RwLayer
+	upper := filepath.Join(rwLayer, ".upper")
+	rootUid, rootGid := owners(t, prototypeDir)
+	checkOwners(t, upper, 100000+rootUid, 200000+rootGid)
+	checkOwners(t, filepath.Join(upper, "var"), 100003, 200003)
+	checkOwners(t, filepath.Join(upper, "var", "lib"), 100004, 200004)
+	checkOwners(t, filepath.Join(upper, "var", "lib", "data"), 100001, 200002)
+	checkOwners(t, filepath.Join(rwLayer, "tmp"), 100000, 200000)
+	if mode := test_support.FileMode(filepath.Join(upper, "var")); mode.Perm() != 0750 {
+		t.Errorf("Parent directory has mode %v, expected the prototype's", mode)
+	}
+}
+
+/*
+	createShiftPrototype creates a prototype containing the directories etc and var/lib, with
+	files owned by various users and groups.
+*/
+func createShiftPrototype(t *testing.T, tempDir string) string {
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	etc := filepath.Join(prototypeDir, "etc")
+	passwd := test_support.CreateFile(etc, "passwd")
+	link := filepath.Join(etc, "link")
+	if err := os.Symlink(passwd, link); err != nil {
+		t.Fatalf("%s", err)
+	}
+	lib := test_support.CreateDir(filepath.Join(prototypeDir, "var"), "lib")
+	data := test_support.CreateFile(lib, "data")
+	for _, f := range []struct {
+		path     string
+		uid, gid int
+	}{
+		{etc, 5, 6},
+		{passwd, 7, 8},
+		{link, 9, 9},
+		{filepath.Join(prototypeDir, "var"), 3, 3},
+		{lib, 4, 4},
+		{data, 1, 2},
+	} {
+		if err := os.Lchown(f.path, f.uid, f.gid); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if err := os.Chmod(passwd, os.ModeSetuid|os.FileMode(0755)); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return prototypeDir
+}
+
+// copyPrototypeDir copies a directory of the prototype, as the runner would, so that the copy is owned by the runner.
+func copyPrototypeDir(t *testing.T, dest string, src string) {
+	if gerr := fileutils.New().Copy(dest, src); gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+}
+
+func checkOwners(t *testing.T, path string, expectedUid uint32, expectedGid uint32) {
+	if uid, gid := owners(t, path); uid != expectedUid || gid != expectedGid {
+		t.Errorf("%q is owned by (%d, %d), expected (%d, %d)", path, uid, gid, expectedUid, expectedGid)
+	}
+}
+
+func owners(t *testing.T, path string) (uint32, uint32) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
+}
+
+func TestGenerateInvalidDir(t *testing.T) {
+	for _, path := range []string{"", "/etc", "../etc", "etc/", "etc/../var", "."} {
+		testGenerateInvalidDir(t, path)
+	}
+}
+
+func testGenerateInvalidDir(t *testing.T, path string) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, prototypeDir := newOverlayRootFS(t, tempDir, mockFileUtils, mockSyscallFS)
+
+	root, gerr := rfs.GenerateWithOptions(prototypeDir, rootfs.GenerateOptions{Dirs: []rootfs.RwDir{{path, rootfs.CopyUp}}})
+	if root != "" || gerr == nil || !gerr.EqualTag(rootfs.ErrInvalidDir) {
+		t.Errorf("Incorrect return values for %q: (%s, %s)", path, root, gerr)
+	}
+}
+
 func TestRemove(t *testing.T) {
 	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
 	defer mockCtrl.Finish()
@@ -187,6 +551,7 @@ func TestRemove(t *testing.T) {
 	defer test_support.CleanupDirs(t, tempDir)
 
 	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(false, nil)
 	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
 	if gerr != nil {
 		t.Errorf("%s", gerr)
@@ -196,10 +561,9 @@ func TestRemove(t *testing.T) {
 	root := "/test-rootfs"
 
 	for _, dir := range test_support.RootFSDirs() {
-		mockSyscallFS.EXPECT().Unmount(filepath.Join(root, dir)).Return(nil)
+		mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(root, dir), gsyscall.UnmountOptions{}).Return(nil)
 	}
-	mockSyscallFS.EXPECT().Unmount(root).Return(nil)
-
+	mockSyscallFS.EXPECT().UnmountWithOptions(root, gsyscall.UnmountOptions{}).Return(nil)
 
 	gerr = rfs.Remove(root)
 	if gerr != nil {
@@ -208,6 +572,56 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestRemoveDeletesRwLayer(t *testing.T) {
+	testRemoveGenerated(t, rootfs.RemoveOptions{}, 0)
+}
+
+func TestRemoveKeepRwLayer(t *testing.T) {
+	testRemoveGenerated(t, rootfs.RemoveOptions{KeepRwLayer: true}, 1)
+}
+
+func testRemoveGenerated(t *testing.T, opts rootfs.RemoveOptions, expectedRwLayers int) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any())
+	root, gerr := rfs.Generate(prototypeDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(root, "tmp"), gsyscall.UnmountOptions{})
+	mockSyscallFS.EXPECT().UnmountWithOptions(root, gsyscall.UnmountOptions{})
+	gerr = rfs.RemoveWithOptions(root, opts)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	if test_support.FileExists(root) {
+		t.Errorf("root %s was not removed", root)
+	}
+	rwLayers, _ := filepath.Glob(filepath.Join(tempDir, "tmp-rootfs-*"))
+	if len(rwLayers) != expectedRwLayers {
+		t.Errorf("Found read-write layers %v, expected %d", rwLayers, expectedRwLayers)
+	}
+}
+
 func TestRemoveUnmountSubdirFailure(t *testing.T) {
 	numDirs := len(test_support.RootFSDirs())
 	for i := 0; i <= numDirs-1; i++ {
@@ -223,6 +637,7 @@ func testRemoveUnmountSubdirFailure(i int, t *testing.T) {
 	defer test_support.CleanupDirs(t, tempDir)
 
 	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(false, nil)
 	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
 	if gerr != nil {
 		t.Errorf("%s", gerr)
@@ -237,7 +652,7 @@ func testRemoveUnmountSubdirFailure(i int, t *testing.T) {
 		if j == i {
 			err = errors.New("an error")
 		}
-		mockSyscallFS.EXPECT().Unmount(filepath.Join(root, dir)).Return(err)
+		mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(root, dir), gsyscall.UnmountOptions{}).Return(err)
 	}
 
 	gerr = rfs.Remove(root)
@@ -259,6 +674,7 @@ func TestRemoveUnmountRootFailure(t *testing.T) {
 	defer test_support.CleanupDirs(t, tempDir)
 
 	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(false, nil)
 	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
 	if gerr != nil {
 		t.Errorf("%s", gerr)
@@ -268,9 +684,9 @@ func TestRemoveUnmountRootFailure(t *testing.T) {
 	root := "/test-rootfs"
 
 	for _, dir := range test_support.RootFSDirs() {
-		mockSyscallFS.EXPECT().Unmount(filepath.Join(root, dir)).Return(nil)
+		mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(root, dir), gsyscall.UnmountOptions{}).Return(nil)
 	}
-	mockSyscallFS.EXPECT().Unmount(root).Return(errors.New("an error"))
+	mockSyscallFS.EXPECT().UnmountWithOptions(root, gsyscall.UnmountOptions{}).Return(errors.New("an error"))
 
 	gerr = rfs.Remove(root)
 	if gerr == nil {
@@ -282,6 +698,581 @@ func TestRemoveUnmountRootFailure(t *testing.T) {
 		return
 	}
 }
+func TestInvalidStrategy(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.Strategy(99)})
+	if rfs != nil || !gerr.EqualTag(rootfs.ErrInvalidStrategy) {
+		t.Errorf("Incorrect return values (%s, %s)", rfs, gerr)
+		return
+	}
+}
+
+func TestProbeOverlayFailure(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(false, errors.New("an error"))
+
+	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
+	if rfs != nil || !gerr.EqualTag(rootfs.ErrProbeOverlay) {
+		t.Errorf("Incorrect return values (%s, %s)", rfs, gerr)
+		return
+	}
+}
+
+func TestGenerateOverlay(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	mockSyscallFS.EXPECT().FilesystemSupported("overlay").Return(true, nil)
+	rfs, gerr := rootfs.NewRootFS(mockSyscallFS, mockFileUtils, tempDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	rootMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, `mnt-[^/]*$`))
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir,
+		test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", `\.upper$`)),
+		test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", `\.work$`)),
+		rootMatcher)
+	expectSetPropagation(mockSyscallFS, rootMatcher, gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(
+		test_support.NewStringRegexMatcher(filepath.Join(tempDir, "tmp-rootfs-[^/]*", "tmp$")),
+		test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", "tmp$")))
+
+	root, gerr := rfs.Generate(prototypeDir)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	rootPrefix := filepath.Join(tempDir, "mnt-")
+	if !strings.HasPrefix(root, rootPrefix) {
+		t.Errorf("root was %s, but expected it to have prefix %s", root, rootPrefix)
+		return
+	}
+}
+
+func TestGenerateOverlayForced(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any())
+
+	if _, gerr := rfs.Generate(prototypeDir); gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+}
+
+func TestGenerateOverlayMountFailure(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("an error"))
+
+	root, gerr := rfs.Generate(prototypeDir)
+	if root != "" || gerr == nil || !gerr.EqualTag(rootfs.ErrOverlayMountRoot) {
+		t.Errorf("Incorrect return values (%s, %s)", root, gerr)
+		return
+	}
+	checkNoLeftovers(t, tempDir)
+}
+
+func TestGenerateOverlayTmpFailure(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	rootMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, `mnt-[^/]*$`))
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), rootMatcher)
+	mockSyscallFS.EXPECT().SetPropagation(rootMatcher, gsyscall.PropagationPrivate, false)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any()).Return(errors.New("an error"))
+	mockSyscallFS.EXPECT().Unmount(rootMatcher)
+
+	root, gerr := rfs.Generate(prototypeDir)
+	if root != "" || gerr == nil || !gerr.EqualTag(rootfs.ErrBindMountSubdir) {
+		t.Errorf("Incorrect return values (%s, %s)", root, gerr)
+		return
+	}
+	checkNoLeftovers(t, tempDir)
+}
+
+func TestRemoveOverlay(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	root := "/test-rootfs"
+
+	gomock.InOrder(
+		mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(root, "tmp"), gsyscall.UnmountOptions{}).Return(nil),
+		mockSyscallFS.EXPECT().UnmountWithOptions(root, gsyscall.UnmountOptions{}).Return(nil),
+	)
+
+	gerr = rfs.Remove(root)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+}
+
+func TestGeneratePropagationOption(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir,
+		rootfs.Options{Strategy: rootfs.OverlayStrategy, Propagation: gsyscall.PropagationSlave})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationSlave)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any())
+
+	if _, gerr := rfs.Generate(prototypeDir); gerr != nil {
+		t.Errorf("%s", gerr)
+	}
+}
+
+func TestGeneratePropagationFailure(t *testing.T) {
+	for _, recursive := range []bool{false, true} {
+		testGeneratePropagationFailure(t, recursive)
+	}
+}
+
+func testGeneratePropagationFailure(t *testing.T, recursive bool) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, prototypeDir := newOverlayRootFS(t, tempDir, mockFileUtils, mockSyscallFS)
+
+	rootMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, `mnt-[^/]*$`))
+	tmpMatcher := test_support.NewStringRegexMatcher(filepath.Join(tempDir, "mnt-[^/]*", "tmp$"))
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), rootMatcher)
+	if recursive {
+		mockSyscallFS.EXPECT().SetPropagation(rootMatcher, gsyscall.PropagationPrivate, false)
+		mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), tmpMatcher)
+		mockSyscallFS.EXPECT().SetPropagation(rootMatcher, gsyscall.PropagationPrivate, true).Return(errors.New("an error"))
+		mockSyscallFS.EXPECT().UnmountWithOptions(tmpMatcher, gsyscall.UnmountOptions{})
+		mockSyscallFS.EXPECT().UnmountWithOptions(rootMatcher, gsyscall.UnmountOptions{})
+	} else {
+		mockSyscallFS.EXPECT().SetPropagation(rootMatcher, gsyscall.PropagationPrivate, false).Return(errors.New("an error"))
+		mockSyscallFS.EXPECT().Unmount(rootMatcher)
+	}
+
+	root, gerr := rfs.Generate(prototypeDir)
+	if root != "" || gerr == nil || !gerr.EqualTag(rootfs.ErrSetPropagation) {
+		t.Errorf("Incorrect return values (%s, %s)", root, gerr)
+		return
+	}
+	checkNoLeftovers(t, tempDir)
+}
+
+func TestRemoveUnmountOptions(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	unmountOpts := gsyscall.UnmountOptions{Flags: gsyscall.UnmountDetach, Retries: 3, RetryDelay: time.Millisecond}
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir,
+		rootfs.Options{Strategy: rootfs.OverlayStrategy, Unmount: unmountOpts})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	root := "/test-rootfs"
+
+	gomock.InOrder(
+		mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(root, "tmp"), unmountOpts).Return(nil),
+		mockSyscallFS.EXPECT().UnmountWithOptions(root, unmountOpts).Return(nil),
+	)
+
+	gerr = rfs.Remove(root)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+}
+
+// checkNoLeftovers checks that only the prototype remains in the given read-write base directory.
+func checkNoLeftovers(t *testing.T, rwBaseDir string) {
+	for _, pattern := range []string{"tmp-rootfs-*", "mnt-*"} {
+		matches, err := filepath.Glob(filepath.Join(rwBaseDir, pattern))
+		if err != nil || len(matches) != 0 {
+			t.Errorf("Unexpected files left behind: %v, %s", matches, err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, prototypeDir := newOverlayRootFS(t, tempDir, mockFileUtils, mockSyscallFS)
+	root := generateOverlay(t, rfs, prototypeDir, mockSyscallFS)
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 1 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+		return
+	}
+	m := manifests[0]
+	if m.Root != root || m.Prototype != prototypeDir || m.State != rootfs.StateReady || m.Strategy != rootfs.OverlayStrategy {
+		t.Errorf("Incorrect manifest %+v", m)
+	}
+	if !strings.HasPrefix(m.RwLayer, filepath.Join(tempDir, "tmp-rootfs-")) {
+		t.Errorf("Incorrect read-write layer %q", m.RwLayer)
+	}
+	expectedMounts := []string{root, filepath.Join(root, "tmp")}
+	if !reflect.DeepEqual(m.Mounts, expectedMounts) {
+		t.Errorf("Mounts were %v, expected %v", m.Mounts, expectedMounts)
+	}
+}
+
+func TestGenerateFailureRemovesManifest(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, prototypeDir := newOverlayRootFS(t, tempDir, mockFileUtils, mockSyscallFS)
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("an error"))
+	if _, gerr := rfs.Generate(prototypeDir); gerr == nil {
+		t.Errorf("Generate should have failed")
+		return
+	}
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 0 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, prototypeDir := newOverlayRootFS(t, tempDir, mockFileUtils, mockSyscallFS)
+
+	// A healthy root filesystem.
+	healthyRoot := generateOverlay(t, rfs, prototypeDir, mockSyscallFS)
+
+	// A root filesystem left part way through Generate, with only the root mounted.
+	brokenRoot := test_support.CreateDir(tempDir, "mnt-broken")
+	brokenRwLayer := test_support.CreateDir(tempDir, "tmp-rootfs-broken")
+	writeTestManifest(t, rootfs.Manifest{
+		Root:      brokenRoot,
+		Prototype: prototypeDir,
+		RwLayer:   brokenRwLayer,
+		Strategy:  rootfs.OverlayStrategy,
+		Mounts:    []string{brokenRoot, filepath.Join(brokenRoot, "tmp")},
+		State:     rootfs.StateGenerating,
+	})
+
+	// A read-write layer retained for debugging.
+	retainedRwLayer := test_support.CreateDir(tempDir, "tmp-rootfs-retained")
+	writeTestManifest(t, rootfs.Manifest{
+		Root:    filepath.Join(tempDir, "mnt-retained"),
+		RwLayer: retainedRwLayer,
+		State:   rootfs.StateRetained,
+	})
+
+	// Directories created by a Generate which crashed before writing a manifest.
+	orphanRoot := test_support.CreateDir(tempDir, "mnt-orphan")
+	orphanRwLayer := test_support.CreateDir(tempDir, "tmp-rootfs-orphan")
+	test_support.CreateFile(orphanRwLayer, "test.file")
+
+	// Simulate a restart.
+	mockMountTable := mock_syscall.NewMockMountTable(mockCtrl)
+	rfs = newRecoveringRootFS(t, tempDir, mockFileUtils, mockSyscallFS, mockMountTable)
+
+	mockFileUtils.EXPECT().Exists(gomock.Any()).Return(true).AnyTimes()
+	mockMountTable.EXPECT().IsMountPoint(test_support.NewStringPrefixMatcher(healthyRoot)).Return(true, nil).AnyTimes()
+	gomock.InOrder(
+		mockSyscallFS.EXPECT().UnmountWithOptions(filepath.Join(brokenRoot, "tmp"), gsyscall.UnmountOptions{}).Return(syscall.EINVAL),
+		mockSyscallFS.EXPECT().UnmountWithOptions(brokenRoot, gsyscall.UnmountOptions{}).Return(nil),
+	)
+
+	adopted, gerr := rfs.Recover()
+	if gerr != nil || len(adopted) != 1 || adopted[0].Root != healthyRoot {
+		t.Errorf("Incorrect return values (%v, %s)", adopted, gerr)
+		return
+	}
+
+	for _, path := range []string{brokenRoot, brokenRwLayer, brokenRoot + ".json", orphanRoot, orphanRwLayer} {
+		if test_support.FileExists(path) {
+			t.Errorf("%q was not removed", path)
+		}
+	}
+	if !test_support.FileExists(retainedRwLayer) {
+		t.Errorf("%q was removed", retainedRwLayer)
+	}
+
+	manifests, gerr := rfs.List()
+	if gerr != nil || len(manifests) != 2 {
+		t.Errorf("Incorrect return values (%v, %s)", manifests, gerr)
+	}
+}
+
+func TestRecoverUnmountedRoot(t *testing.T) {
+	testRecoverUnmounted(t, "")
+}
+
+func TestRecoverUnmountedDir(t *testing.T) {
+	testRecoverUnmounted(t, "tmp")
+}
+
+// testRecoverUnmounted checks that a ready root filesystem, whose given mount point is not mounted, is removed.
+func testRecoverUnmounted(t *testing.T, unmounted string) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockMountTable := mock_syscall.NewMockMountTable(mockCtrl)
+	rfs := newRecoveringRootFS(t, tempDir, mockFileUtils, mockSyscallFS, mockMountTable)
+	root := test_support.CreateDir(tempDir, "mnt-unmounted")
+	rwLayer := test_support.CreateDir(tempDir, "tmp-rootfs-unmounted")
+	tmp := filepath.Join(root, "tmp")
+	writeTestManifest(t, rootfs.Manifest{
+		Root:     root,
+		RwLayer:  rwLayer,
+		Strategy: rootfs.OverlayStrategy,
+		Mounts:   []string{root, tmp},
+		State:    rootfs.StateReady,
+	})
+
+	mockFileUtils.EXPECT().Exists(gomock.Any()).Return(true).AnyTimes()
+	if unmounted == "" {
+		mockMountTable.EXPECT().IsMountPoint(root).Return(false, nil)
+	} else {
+		mockMountTable.EXPECT().IsMountPoint(root).Return(true, nil).AnyTimes()
+		mockMountTable.EXPECT().IsMountPoint(filepath.Join(root, unmounted)).Return(false, nil)
+	}
+	mockSyscallFS.EXPECT().UnmountWithOptions(tmp, gsyscall.UnmountOptions{}).Return(nil)
+	mockSyscallFS.EXPECT().UnmountWithOptions(root, gsyscall.UnmountOptions{}).Return(syscall.EINVAL)
+
+	adopted, gerr := rfs.Recover()
+	if gerr != nil || len(adopted) != 0 {
+		t.Errorf("Incorrect return values (%v, %s)", adopted, gerr)
+		return
+	}
+	for _, path := range []string{root, rwLayer, root + ".json"} {
+		if test_support.FileExists(path) {
+			t.Errorf("%q was not removed", path)
+		}
+	}
+}
+
+func TestRecoverMountTableFailure(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	mockMountTable := mock_syscall.NewMockMountTable(mockCtrl)
+	rfs := newRecoveringRootFS(t, tempDir, mockFileUtils, mockSyscallFS, mockMountTable)
+	root := test_support.CreateDir(tempDir, "mnt-unknown")
+	rwLayer := test_support.CreateDir(tempDir, "tmp-rootfs-unknown")
+	writeTestManifest(t, rootfs.Manifest{
+		Root:     root,
+		RwLayer:  rwLayer,
+		Strategy: rootfs.OverlayStrategy,
+		Mounts:   []string{root},
+		State:    rootfs.StateReady,
+	})
+
+	mockFileUtils.EXPECT().Exists(gomock.Any()).Return(true).AnyTimes()
+	mockMountTable.EXPECT().IsMountPoint(root).Return(false, errors.New("an error"))
+
+	adopted, gerr := rfs.Recover()
+	if len(adopted) != 0 || gerr == nil || !gerr.EqualTag(rootfs.ErrMountTable) {
+		t.Errorf("Incorrect return values (%v, %s)", adopted, gerr)
+		return
+	}
+	for _, path := range []string{root, rwLayer, root + ".json"} {
+		if !test_support.FileExists(path) {
+			t.Errorf("%q should not have been removed", path)
+		}
+	}
+}
+
+func TestRecoverUnmountFailure(t *testing.T) {
+	mockCtrl, mockFileUtils, mockSyscallFS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+
+	rfs, prototypeDir := newOverlayRootFS(t, tempDir, mockFileUtils, mockSyscallFS)
+
+	brokenRoot := test_support.CreateDir(tempDir, "mnt-broken")
+	brokenRwLayer := test_support.CreateDir(tempDir, "tmp-rootfs-broken")
+	writeTestManifest(t, rootfs.Manifest{
+		Root:      brokenRoot,
+		Prototype: prototypeDir,
+		RwLayer:   brokenRwLayer,
+		Strategy:  rootfs.OverlayStrategy,
+		Mounts:    []string{brokenRoot},
+		State:     rootfs.StateRemoving,
+	})
+	mockSyscallFS.EXPECT().UnmountWithOptions(brokenRoot, gsyscall.UnmountOptions{}).Return(syscall.EBUSY)
+
+	adopted, gerr := rfs.Recover()
+	if len(adopted) != 0 || gerr == nil || !gerr.EqualTag(rootfs.ErrUnmountRoot) {
+		t.Errorf("Incorrect return values (%v, %s)", adopted, gerr)
+		return
+	}
+	if !test_support.FileExists(brokenRwLayer) {
+		t.Errorf("%q should not have been removed", brokenRwLayer)
+	}
+}
+
+func newOverlayRootFS(t *testing.T, tempDir string, mockFileUtils *mock_fileutils.MockFileutils,
+	mockSyscallFS *mock_syscall.MockSyscallFS) (rootfs.RootFS, string) {
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir, rootfs.Options{Strategy: rootfs.OverlayStrategy})
+	if gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	prototypeDir := test_support.CreatePrototype(tempDir)
+	mockFileUtils.EXPECT().Exists(test_support.NewStringPrefixMatcher(prototypeDir)).Return(true).AnyTimes()
+	return rfs, prototypeDir
+}
+
+// newRecoveringRootFS creates an overlay RootFS instance which checks mount points using the given MountTable.
+func newRecoveringRootFS(t *testing.T, tempDir string, mockFileUtils *mock_fileutils.MockFileutils,
+	mockSyscallFS *mock_syscall.MockSyscallFS, mockMountTable *mock_syscall.MockMountTable) rootfs.RootFS {
+	mockFileUtils.EXPECT().Filemode(tempDir).Return(os.ModeDir|os.FileMode(0700), nil)
+	rfs, gerr := rootfs.NewRootFSWithOptions(mockSyscallFS, mockFileUtils, tempDir,
+		rootfs.Options{Strategy: rootfs.OverlayStrategy, MountTable: mockMountTable})
+	if gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	return rfs
+}
+
+func generateOverlay(t *testing.T, rfs rootfs.RootFS, prototypeDir string, mockSyscallFS *mock_syscall.MockSyscallFS) string {
+	mockSyscallFS.EXPECT().OverlayMount(prototypeDir, gomock.Any(), gomock.Any(), gomock.Any())
+	expectSetPropagation(mockSyscallFS, gomock.Any(), gsyscall.PropagationPrivate)
+	mockSyscallFS.EXPECT().BindMountReadWrite(gomock.Any(), gomock.Any())
+	root, gerr := rfs.Generate(prototypeDir)
+	if gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	return root
+}
+
+/*
+	expectSetPropagation expects the propagation of the given root to be set, first non-recursively and then
+	recursively.
+*/
+func expectSetPropagation(mockSyscallFS *mock_syscall.MockSyscallFS, root interface{}, propagation gsyscall.Propagation) {
+	gomock.InOrder(
+		mockSyscallFS.EXPECT().SetPropagation(root, propagation, false),
+		mockSyscallFS.EXPECT().SetPropagation(root, propagation, true),
+	)
+}
+
+func writeTestManifest(t *testing.T, m rootfs.Manifest) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = ioutil.WriteFile(m.Root+".json", data, os.FileMode(0600)); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
 func setupMocks(t *testing.T) (*gomock.Controller, *mock_fileutils.MockFileutils, *mock_syscall.MockSyscallFS) {
 	mockCtrl := gomock.NewController(t)
 	mockFileUtils := mock_fileutils.NewMockFileutils(mockCtrl)
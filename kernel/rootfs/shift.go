@@ -0,0 +1,138 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rootfs
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	trueSyscall "syscall"
+)
+
+/*
+	An idShift changes the owners of files in a read-write layer so that, in a user namespace with the given
+	ID mappings, they have the owners of the corresponding files of the prototype. An id which is not mapped
+	is left unchanged, so that the file appears in the namespace, like the prototype's file, to be owned by
+	the overflow id.
+*/
+type idShift struct {
+	prototype string
+	uids      []syscall.IdMapping
+	gids      []syscall.IdMapping
+}
+
+// newIdShift returns an idShift for the given prototype and options, or nil if the options specify no ID mappings.
+func newIdShift(prototype string, opts GenerateOptions) *idShift {
+	if opts.Uids == nil && opts.Gids == nil {
+		return nil
+	}
+	return &idShift{prototype, opts.Uids, opts.Gids}
+}
+
+/*
+	copyTree changes the owners of the files of the given copy of a directory tree of the prototype, at the
+	given path relative to the prototype, to those of the corresponding files of the prototype, mapped into
+	the namespace's range. Symbolic links are not followed. Each file is changed only if its owners differ.
+*/
+func (s *idShift) copyTree(copy string, dir string) gerror.Gerror {
+	source := filepath.Join(s.prototype, dir)
+	if glog.V(2) {
+		glog.Infof("Shifting the ids of %q to those of %q", copy, source)
+	}
+	err := filepath.Walk(copy, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(copy, path)
+		if err != nil {
+			return err
+		}
+		sourceInfo, err := os.Lstat(filepath.Join(source, rel))
+		if err != nil {
+			return err
+		}
+		uid, gid := owners(sourceInfo)
+		return s.chown(path, info, uid, gid)
+	})
+	if err != nil {
+		return gerror.NewFromError(ErrShiftIds, err)
+	}
+	return nil
+}
+
+/*
+	file changes the owners of the given file to those of the file at the given path relative to the prototype,
+	mapped into the namespace's range.
+*/
+func (s *idShift) file(path string, rel string) gerror.Gerror {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return gerror.NewFromError(ErrShiftIds, err)
+	}
+	sourceInfo, err := os.Lstat(filepath.Join(s.prototype, rel))
+	if err != nil {
+		return gerror.NewFromError(ErrShiftIds, err)
+	}
+	uid, gid := owners(sourceInfo)
+	if err := s.chown(path, info, uid, gid); err != nil {
+		return gerror.NewFromError(ErrShiftIds, err)
+	}
+	return nil
+}
+
+// root changes the owners of the given file to the root user and group of the namespace.
+func (s *idShift) root(path string) gerror.Gerror {
+	info, err := os.Lstat(path)
+	if err == nil {
+		err = s.chown(path, info, 0, 0)
+	}
+	if err != nil {
+		return gerror.NewFromError(ErrShiftIds, err)
+	}
+	return nil
+}
+
+// chown changes the owners of the given file, which has the given info, to the given ids of the namespace.
+func (s *idShift) chown(path string, info os.FileInfo, uid uint32, gid uint32) error {
+	newUid, newGid := -1, -1
+	currentUid, currentGid := owners(info)
+	if hostUid, ok := syscall.MapId(s.uids, uid); ok && hostUid != currentUid {
+		newUid = int(hostUid)
+	}
+	if hostGid, ok := syscall.MapId(s.gids, gid); ok && hostGid != currentGid {
+		newGid = int(hostGid)
+	}
+	if newUid == -1 && newGid == -1 {
+		return nil
+	}
+	if err := os.Lchown(path, newUid, newGid); err != nil {
+		return err
+	}
+	// Changing the owners of a file clears its set-user-ID and set-group-ID bits.
+	if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+		return os.Chmod(path, info.Mode())
+	}
+	return nil
+}
+
+// owners returns the user and group ids of the owners of the file with the given info.
+func owners(info os.FileInfo) (uint32, uint32) {
+	st := info.Sys().(*trueSyscall.Stat_t)
+	return st.Uid, st.Gid
+}
@@ -5,6 +5,9 @@ package mock_syscall
 
 import (
 	gomock "code.google.com/p/gomock/gomock"
+	syscall "github.com/cf-guardian/guardian/kernel/syscall"
+	os "os"
+	exec "os/exec"
 )
 
 // Mock of SyscallFS interface
@@ -28,6 +31,16 @@ func (_m *MockSyscallFS) EXPECT() *_MockSyscallFSRecorder {
 	return _m.recorder
 }
 
+func (_m *MockSyscallFS) Mount(source string, target string, fsType string, flags syscall.MountFlags, data string) error {
+	ret := _m.ctrl.Call(_m, "Mount", source, target, fsType, flags, data)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) Mount(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Mount", arg0, arg1, arg2, arg3, arg4)
+}
+
 func (_m *MockSyscallFS) BindMountReadWrite(source string, mountPoint string) error {
 	ret := _m.ctrl.Call(_m, "BindMountReadWrite", source, mountPoint)
 	ret0, _ := ret[0].(error)
@@ -48,6 +61,26 @@ func (_mr *_MockSyscallFSRecorder) BindMountReadOnly(arg0, arg1 interface{}) *go
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "BindMountReadOnly", arg0, arg1)
 }
 
+func (_m *MockSyscallFS) OverlayMount(lowerDir string, upperDir string, workDir string, mountPoint string) error {
+	ret := _m.ctrl.Call(_m, "OverlayMount", lowerDir, upperDir, workDir, mountPoint)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) OverlayMount(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "OverlayMount", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockSyscallFS) SetPropagation(mountPoint string, propagation syscall.Propagation, recursive bool) error {
+	ret := _m.ctrl.Call(_m, "SetPropagation", mountPoint, propagation, recursive)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) SetPropagation(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetPropagation", arg0, arg1, arg2)
+}
+
 func (_m *MockSyscallFS) Unmount(mountPoint string) error {
 	ret := _m.ctrl.Call(_m, "Unmount", mountPoint)
 	ret0, _ := ret[0].(error)
@@ -57,3 +90,252 @@ func (_m *MockSyscallFS) Unmount(mountPoint string) error {
 func (_mr *_MockSyscallFSRecorder) Unmount(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Unmount", arg0)
 }
+
+func (_m *MockSyscallFS) UnmountWithOptions(mountPoint string, opts syscall.UnmountOptions) error {
+	ret := _m.ctrl.Call(_m, "UnmountWithOptions", mountPoint, opts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) UnmountWithOptions(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnmountWithOptions", arg0, arg1)
+}
+
+func (_m *MockSyscallFS) UnmountAll(path string, opts syscall.UnmountOptions) error {
+	ret := _m.ctrl.Call(_m, "UnmountAll", path, opts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) UnmountAll(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnmountAll", arg0, arg1)
+}
+
+func (_m *MockSyscallFS) PivotRoot(newRoot string, putOld string) error {
+	ret := _m.ctrl.Call(_m, "PivotRoot", newRoot, putOld)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) PivotRoot(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PivotRoot", arg0, arg1)
+}
+
+func (_m *MockSyscallFS) Chroot(path string) error {
+	ret := _m.ctrl.Call(_m, "Chroot", path)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) Chroot(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Chroot", arg0)
+}
+
+func (_m *MockSyscallFS) Chdir(path string) error {
+	ret := _m.ctrl.Call(_m, "Chdir", path)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallFSRecorder) Chdir(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Chdir", arg0)
+}
+
+func (_m *MockSyscallFS) FilesystemSupported(fsType string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "FilesystemSupported", fsType)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSyscallFSRecorder) FilesystemSupported(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "FilesystemSupported", arg0)
+}
+
+// Mock of MountTable interface
+type MockMountTable struct {
+	ctrl     *gomock.Controller
+	recorder *_MockMountTableRecorder
+}
+
+// Recorder for MockMountTable (not exported)
+type _MockMountTableRecorder struct {
+	mock *MockMountTable
+}
+
+func NewMockMountTable(ctrl *gomock.Controller) *MockMountTable {
+	mock := &MockMountTable{ctrl: ctrl}
+	mock.recorder = &_MockMountTableRecorder{mock}
+	return mock
+}
+
+func (_m *MockMountTable) EXPECT() *_MockMountTableRecorder {
+	return _m.recorder
+}
+
+func (_m *MockMountTable) Mounts() ([]syscall.MountEntry, error) {
+	ret := _m.ctrl.Call(_m, "Mounts")
+	ret0, _ := ret[0].([]syscall.MountEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMountTableRecorder) Mounts() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Mounts")
+}
+
+func (_m *MockMountTable) IsMountPoint(path string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "IsMountPoint", path)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMountTableRecorder) IsMountPoint(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsMountPoint", arg0)
+}
+
+func (_m *MockMountTable) MountsUnder(path string) ([]syscall.MountEntry, error) {
+	ret := _m.ctrl.Call(_m, "MountsUnder", path)
+	ret0, _ := ret[0].([]syscall.MountEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMountTableRecorder) MountsUnder(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MountsUnder", arg0)
+}
+
+func (_m *MockMountTable) MountInfo(path string) (syscall.MountEntry, error) {
+	ret := _m.ctrl.Call(_m, "MountInfo", path)
+	ret0, _ := ret[0].(syscall.MountEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockMountTableRecorder) MountInfo(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MountInfo", arg0)
+}
+
+// Mock of SyscallNS interface
+type MockSyscallNS struct {
+	ctrl     *gomock.Controller
+	recorder *_MockSyscallNSRecorder
+}
+
+// Recorder for MockSyscallNS (not exported)
+type _MockSyscallNSRecorder struct {
+	mock *MockSyscallNS
+}
+
+func NewMockSyscallNS(ctrl *gomock.Controller) *MockSyscallNS {
+	mock := &MockSyscallNS{ctrl: ctrl}
+	mock.recorder = &_MockSyscallNSRecorder{mock}
+	return mock
+}
+
+func (_m *MockSyscallNS) EXPECT() *_MockSyscallNSRecorder {
+	return _m.recorder
+}
+
+func (_m *MockSyscallNS) Unshare(namespaces syscall.Namespaces) error {
+	ret := _m.ctrl.Call(_m, "Unshare", namespaces)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) Unshare(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Unshare", arg0)
+}
+
+func (_m *MockSyscallNS) Setns(ns *os.File, namespace syscall.Namespaces) error {
+	ret := _m.ctrl.Call(_m, "Setns", ns, namespace)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) Setns(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Setns", arg0, arg1)
+}
+
+func (_m *MockSyscallNS) OpenNS(pid int, namespace syscall.Namespaces) (*os.File, error) {
+	ret := _m.ctrl.Call(_m, "OpenNS", pid, namespace)
+	ret0, _ := ret[0].(*os.File)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSyscallNSRecorder) OpenNS(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "OpenNS", arg0, arg1)
+}
+
+func (_m *MockSyscallNS) NamespaceId(pid int, namespace syscall.Namespaces) (string, error) {
+	ret := _m.ctrl.Call(_m, "NamespaceId", pid, namespace)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSyscallNSRecorder) NamespaceId(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NamespaceId", arg0, arg1)
+}
+
+func (_m *MockSyscallNS) StartInNamespaces(cmd *exec.Cmd, namespaces syscall.Namespaces) error {
+	ret := _m.ctrl.Call(_m, "StartInNamespaces", cmd, namespaces)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) StartInNamespaces(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "StartInNamespaces", arg0, arg1)
+}
+
+func (_m *MockSyscallNS) Sethostname(name string) error {
+	ret := _m.ctrl.Call(_m, "Sethostname", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) Sethostname(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Sethostname", arg0)
+}
+
+func (_m *MockSyscallNS) Setdomainname(name string) error {
+	ret := _m.ctrl.Call(_m, "Setdomainname", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) Setdomainname(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Setdomainname", arg0)
+}
+
+func (_m *MockSyscallNS) WriteIdMaps(pid int, uidMappings []syscall.IdMapping, gidMappings []syscall.IdMapping, denySetgroups bool) error {
+	ret := _m.ctrl.Call(_m, "WriteIdMaps", pid, uidMappings, gidMappings, denySetgroups)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) WriteIdMaps(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteIdMaps", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockSyscallNS) RunIdMapHelpers(pid int, uidMappings []syscall.IdMapping, gidMappings []syscall.IdMapping) error {
+	ret := _m.ctrl.Call(_m, "RunIdMapHelpers", pid, uidMappings, gidMappings)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) RunIdMapHelpers(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RunIdMapHelpers", arg0, arg1, arg2)
+}
+
+func (_m *MockSyscallNS) SetIds(uid uint32, gid uint32) error {
+	ret := _m.ctrl.Call(_m, "SetIds", uid, gid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSyscallNSRecorder) SetIds(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetIds", arg0, arg1)
+}
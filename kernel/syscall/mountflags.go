@@ -0,0 +1,135 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	MountFlags is a set of flags which modify the behaviour of a mount. Flags are combined using
+	bitwise or, for example MountNoSuid|MountNoDev|MountNoExec.
+*/
+type MountFlags uint
+
+const (
+	MountReadOnly    MountFlags = 1 << iota // mount read-only
+	MountNoSuid                             // ignore set-user-id and set-group-id bits
+	MountNoDev                              // disallow access to device special files
+	MountNoExec                             // disallow execution of programs
+	MountSynchronous                        // write synchronously
+	MountDirSync                            // make directory changes synchronous
+	MountRemount                            // change the flags and data of an existing mount
+	MountNoAtime                            // do not update access times
+	MountNoDirAtime                         // do not update directory access times
+	MountRelatime                           // update access times relative to modification and change times
+	MountStrictAtime                        // always update access times
+	MountBind                               // bind mount the source at the target
+	MountMove                               // move an existing mount to the target
+	MountRec                                // apply a bind or propagation change recursively to submounts
+)
+
+var mountFlagNames = []struct {
+	flag MountFlags
+	name string
+}{
+	{MountReadOnly, "ro"},
+	{MountNoSuid, "nosuid"},
+	{MountNoDev, "nodev"},
+	{MountNoExec, "noexec"},
+	{MountSynchronous, "sync"},
+	{MountDirSync, "dirsync"},
+	{MountRemount, "remount"},
+	{MountNoAtime, "noatime"},
+	{MountNoDirAtime, "nodiratime"},
+	{MountRelatime, "relatime"},
+	{MountStrictAtime, "strictatime"},
+	{MountBind, "bind"},
+	{MountMove, "move"},
+	{MountRec, "rec"},
+}
+
+// String returns the names of the flags in the set separated by commas, in the style of mount(8).
+func (flags MountFlags) String() string {
+	names := []string{}
+	for _, fn := range mountFlagNames {
+		if flags&fn.flag != 0 {
+			names = append(names, fn.name)
+			flags &^= fn.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, "unknown")
+	}
+	if len(names) == 0 {
+		return "defaults"
+	}
+	return strings.Join(names, ",")
+}
+
+/*
+	Propagation determines whether mount and unmount events under a mount point propagate to and
+	from other mount points. See the kernel documentation on shared subtrees.
+*/
+type Propagation int
+
+const (
+	PropagationPrivate    Propagation = iota + 1 // events do not propagate to or from the mount
+	PropagationSlave                             // events propagate to, but not from, the mount
+	PropagationShared                            // events propagate to and from the mount
+	PropagationUnbindable                        // as for PropagationPrivate and, in addition, the mount cannot be bind mounted
+)
+
+func (p Propagation) String() string {
+	switch p {
+	case PropagationPrivate:
+		return "private"
+	case PropagationSlave:
+		return "slave"
+	case PropagationShared:
+		return "shared"
+	case PropagationUnbindable:
+		return "unbindable"
+	}
+	return fmt.Sprintf("Propagation(%d)", int(p))
+}
+
+// UnmountFlags is a set of flags which modify the behaviour of an unmount.
+type UnmountFlags uint
+
+const (
+	UnmountForce    UnmountFlags = 1 << iota // force the unmount even if the filesystem is busy, which may lose data
+	UnmountDetach                            // detach the mount immediately and clean it up when it is no longer busy
+	UnmountNoFollow                          // do not follow the mount point if it is a symbolic link
+)
+
+/*
+	UnmountOptions modify the behaviour of an unmount. The zero value unmounts with no flags and does not
+	retry.
+*/
+type UnmountOptions struct {
+	// Flags modify the unmount.
+	Flags UnmountFlags
+
+	// Retries is the number of times a failed unmount is retried if the mount is busy.
+	Retries int
+
+	// RetryDelay is the delay before the first retry. The delay is doubled before each subsequent retry.
+	RetryDelay time.Duration
+}
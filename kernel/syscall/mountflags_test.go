@@ -0,0 +1,36 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_test
+
+import (
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"testing"
+)
+
+func TestMountFlagsString(t *testing.T) {
+	for flags, expected := range map[syscall.MountFlags]string{
+		0:                                        "defaults",
+		syscall.MountReadOnly:                    "ro",
+		syscall.MountNoSuid | syscall.MountNoDev: "nosuid,nodev",
+		syscall.MountBind | syscall.MountRec:     "bind,rec",
+		syscall.MountRec | 1<<31:                 "rec,unknown",
+	} {
+		if s := flags.String(); s != expected {
+			t.Errorf("String of %#x was %q, expected %q", uint(flags), s, expected)
+		}
+	}
+}
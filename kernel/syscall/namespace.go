@@ -0,0 +1,115 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall
+
+import (
+	"strings"
+)
+
+/*
+	Namespaces is a set of types of Linux namespace. Types are combined using bitwise or, for example
+	MountNS|PIDNS.
+*/
+type Namespaces uint
+
+const (
+	MountNS  Namespaces = 1 << iota // mount points
+	UTSNS                           // host name and domain name
+	IPCNS                           // System V IPC objects and POSIX message queues
+	PIDNS                           // process ids
+	NetNS                           // network devices, stacks, and ports
+	UserNS                          // user and group ids
+	CgroupNS                        // cgroup root directory
+)
+
+// AllNamespaces is the set of all types of namespace.
+const AllNamespaces = MountNS | UTSNS | IPCNS | PIDNS | NetNS | UserNS | CgroupNS
+
+var namespaceNames = []struct {
+	ns   Namespaces
+	name string
+}{
+	{MountNS, "mnt"},
+	{UTSNS, "uts"},
+	{IPCNS, "ipc"},
+	{PIDNS, "pid"},
+	{NetNS, "net"},
+	{UserNS, "user"},
+	{CgroupNS, "cgroup"},
+}
+
+/*
+	Names returns the names of the types of namespace in the set, in the order of the constants above.
+	The names are those of the namespace files in /proc/<pid>/ns.
+*/
+func (namespaces Namespaces) Names() []string {
+	names := []string{}
+	for _, nn := range namespaceNames {
+		if namespaces&nn.ns != 0 {
+			names = append(names, nn.name)
+		}
+	}
+	return names
+}
+
+/*
+	Each returns the types of namespace in the set, one per element, in the order of the constants
+	above.
+*/
+func (namespaces Namespaces) Each() []Namespaces {
+	each := []Namespaces{}
+	for _, nn := range namespaceNames {
+		if namespaces&nn.ns != 0 {
+			each = append(each, nn.ns)
+		}
+	}
+	return each
+}
+
+func (namespaces Namespaces) String() string {
+	names := namespaces.Names()
+	if namespaces&^AllNamespaces != 0 {
+		names = append(names, "unknown")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+/*
+	An IdMapping maps a range of user or group ids of a user namespace to a range of the same size of ids
+	of the parent user namespace, as in a line of /proc/<pid>/uid_map.
+*/
+type IdMapping struct {
+	ContainerId uint32 // the first id of the range in the user namespace
+	HostId      uint32 // the first id of the range in the parent user namespace
+	Size        uint32 // the number of ids in the range
+}
+
+/*
+	MapId returns the id of the parent user namespace to which the given id of a user namespace is mapped by
+	the given mappings, and true, or false if the id is not mapped.
+*/
+func MapId(mappings []IdMapping, id uint32) (uint32, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerId && uint64(id) < uint64(m.ContainerId)+uint64(m.Size) {
+			return m.HostId + (id - m.ContainerId), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,66 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_test
+
+import (
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"reflect"
+	"testing"
+)
+
+func TestNamespacesString(t *testing.T) {
+	for namespaces, expected := range map[syscall.Namespaces]string{
+		0:                             "none",
+		syscall.MountNS:               "mnt",
+		syscall.PIDNS | syscall.UTSNS: "uts|pid",
+		syscall.AllNamespaces:         "mnt|uts|ipc|pid|net|user|cgroup",
+		syscall.CgroupNS | 1<<20:      "cgroup|unknown",
+	} {
+		if s := namespaces.String(); s != expected {
+			t.Errorf("String of %#x was %q, expected %q", uint(namespaces), s, expected)
+		}
+	}
+}
+
+func TestNamespacesEach(t *testing.T) {
+	each := (syscall.NetNS | syscall.MountNS).Each()
+	expected := []syscall.Namespaces{syscall.MountNS, syscall.NetNS}
+	if !reflect.DeepEqual(each, expected) {
+		t.Errorf("Each returned %v, expected %v", each, expected)
+	}
+}
+
+func TestMapId(t *testing.T) {
+	mappings := []syscall.IdMapping{
+		{ContainerId: 0, HostId: 1000, Size: 1},
+		{ContainerId: 1, HostId: 100000, Size: 65536},
+		{ContainerId: 4294967295, HostId: 5, Size: 1},
+	}
+	for id, expected := range map[uint32]uint32{0: 1000, 1: 100000, 65536: 165535, 4294967295: 5} {
+		if mapped, ok := syscall.MapId(mappings, id); !ok || mapped != expected {
+			t.Errorf("MapId of %d returned (%d, %v), expected %d", id, mapped, ok, expected)
+		}
+	}
+	for _, id := range []uint32{65537, 4294967294} {
+		if mapped, ok := syscall.MapId(mappings, id); ok {
+			t.Errorf("MapId of unmapped id %d returned %d", id, mapped)
+		}
+	}
+	if _, ok := syscall.MapId(nil, 0); ok {
+		t.Errorf("MapId with no mappings mapped root")
+	}
+}
@@ -19,8 +19,20 @@ Package syscall provides an abstraction of the standard syscall package to suppo
 */
 package syscall
 
+import (
+	"os"
+	"os/exec"
+)
+
 // The SyscallFS interface provides filesystem-related system calls.
 type SyscallFS interface {
+	/*
+		Mounts the given source at the given target. The given filesystem type, such as "proc" or "tmpfs",
+		and filesystem-specific data, such as "mode=755", are passed to the kernel. The filesystem type
+		is ignored for bind mounts, moves, and remounts.
+	*/
+	Mount(source string, target string, fsType string, flags MountFlags, data string) error
+
 	/*
 		Mounts the given source directory at the given mount point with the "bind" option.
 	*/
@@ -31,8 +43,179 @@ type SyscallFS interface {
 	*/
 	BindMountReadOnly(source string, mountPoint string) error
 
+	/*
+		Mounts an overlay filesystem at the given mount point. The given lower directory is the read-only
+		layer and the given upper directory receives any changes. The given work directory must be an empty
+		directory on the same filesystem as the upper directory.
+	*/
+	OverlayMount(lowerDir string, upperDir string, workDir string, mountPoint string) error
+
+	/*
+		Sets the propagation of the given mount point and, if recursive is true, of all the mount points
+		under it.
+	*/
+	SetPropagation(mountPoint string, propagation Propagation, recursive bool) error
+
 	/*
 		Unmounts the given mount point.
 	*/
 	Unmount(mountPoint string) error
+
+	/*
+		Unmounts the given mount point with the given options.
+	*/
+	UnmountWithOptions(mountPoint string, opts UnmountOptions) error
+
+	/*
+		Unmounts all the mounts at or below the given path, deepest first, with the given options.
+		Mounts which disappear before they are unmounted, for example because an unmount with
+		UnmountDetach also detaches the mounts below it, are ignored. If an unmount fails, the
+		remaining mounts are still unmounted and the first error is returned.
+	*/
+	UnmountAll(path string, opts UnmountOptions) error
+
+	/*
+		Makes the given new root directory the root mount of the calling process's mount namespace and
+		moves the current root mount to the given directory, which must be at or below the new root.
+		See pivot_root(2).
+	*/
+	PivotRoot(newRoot string, putOld string) error
+
+	/*
+		Changes the root directory of the calling process to the given directory.
+	*/
+	Chroot(path string) error
+
+	/*
+		Changes the current working directory of the calling process to the given directory.
+	*/
+	Chdir(path string) error
+
+	/*
+		Returns true if and only if the kernel supports the given filesystem type, as listed
+		in /proc/filesystems.
+	*/
+	FilesystemSupported(fsType string) (bool, error)
+}
+
+/*
+	A MountEntry describes a mount as listed in the mount table. See proc(5) for a description of each field.
+*/
+type MountEntry struct {
+	MountId        int      // unique id of the mount
+	ParentId       int      // id of the parent mount, or of the mount itself at the top of the tree
+	Major          int      // major device number of the filesystem
+	Minor          int      // minor device number of the filesystem
+	Root           string   // path of the directory in the filesystem which forms the root of the mount
+	MountPoint     string   // path of the mount point
+	Options        []string // per-mount options, such as "rw" and "nosuid"
+	OptionalFields []string // optional fields, such as the propagation fields "shared:1" and "master:2"
+	FsType         string   // filesystem type, such as "ext4" or "tmpfs"
+	Source         string   // filesystem-specific source, such as "/dev/sda1", or "none"
+	SuperOptions   []string // per-superblock options
+}
+
+// The MountTable interface provides queries of the mount table of the current process.
+type MountTable interface {
+	/*
+		Returns the entries of the mount table in the order in which they are listed, which is
+		the order in which the mounts were made.
+	*/
+	Mounts() ([]MountEntry, error)
+
+	/*
+		Returns true if and only if the given path is a mount point.
+	*/
+	IsMountPoint(path string) (bool, error)
+
+	/*
+		Returns the entries of the mounts at or below the given path, in the order in which they
+		are listed.
+	*/
+	MountsUnder(path string) ([]MountEntry, error)
+
+	/*
+		Returns the entry of the mount on which the given path resides. If several mounts are stacked
+		on the same mount point, the entry of the topmost mount is returned.
+	*/
+	MountInfo(path string) (MountEntry, error)
+}
+
+/*
+	The SyscallNS interface provides namespace-related system calls.
+
+	Unshare and Setns change the namespaces of the calling thread only. The caller should lock the calling
+	goroutine to its thread, using runtime.LockOSThread, and should not unlock it while the thread is in
+	namespaces different from those of the rest of the process. The kernel does not allow a multi-threaded
+	process to change its user namespace and so, in a Go program, a user namespace can only be entered
+	by starting a process in it.
+*/
+type SyscallNS interface {
+	/*
+		Moves the calling thread into new namespaces of the given types.
+	*/
+	Unshare(namespaces Namespaces) error
+
+	/*
+		Moves the calling thread into the namespace referred to by the given file, which was returned
+		by OpenNS. The given type of namespace is checked against the namespace unless it is zero.
+	*/
+	Setns(ns *os.File, namespace Namespaces) error
+
+	/*
+		Opens the namespace of the given type of the process with the given process id, or of the calling
+		process if the process id is zero. The file should be closed when it is no longer needed. The
+		namespace exists at least as long as the file is open.
+	*/
+	OpenNS(pid int, namespace Namespaces) (*os.File, error)
+
+	/*
+		Returns an identifier of the namespace of the given type of the process with the given process
+		id, or of the calling process if the process id is zero, such as "net:[4026531993]". Two processes
+		are in the same namespace of a given type if and only if they have the same identifier for that
+		type.
+	*/
+	NamespaceId(pid int, namespace Namespaces) (string, error)
+
+	/*
+		Starts the given command in new namespaces of the given types. Any clone flags already set in
+		the command's SysProcAttr are preserved.
+
+		If the namespaces include a user namespace and the command's SysProcAttr has no ambient
+		capabilities, the command is given all capabilities as ambient capabilities. It then remains
+		privileged in the new user namespace even though its user id is not mapped in the namespace when
+		it starts, and so it may, for example, set its ids once its ID maps have been written.
+	*/
+	StartInNamespaces(cmd *exec.Cmd, namespaces Namespaces) error
+
+	/*
+		Sets the host name of the UTS namespace of the calling process.
+	*/
+	Sethostname(name string) error
+
+	/*
+		Sets the NIS domain name of the UTS namespace of the calling process.
+	*/
+	Setdomainname(name string) error
+
+	/*
+		Writes the user and group ID maps of the user namespace of the process with the given process id.
+		If denySetgroups is true, the process, and any other process in the namespace, is prevented from
+		calling setgroups, as the kernel requires before an unprivileged process may write a group ID map.
+		The maps of a namespace can be written only once.
+	*/
+	WriteIdMaps(pid int, uidMappings []IdMapping, gidMappings []IdMapping, denySetgroups bool) error
+
+	/*
+		Writes the user and group ID maps of the user namespace of the process with the given process id
+		using the setuid newuidmap and newgidmap programs, which permit an unprivileged process to map
+		the subordinate ids allocated to its user in /etc/subuid and /etc/subgid.
+	*/
+	RunIdMapHelpers(pid int, uidMappings []IdMapping, gidMappings []IdMapping) error
+
+	/*
+		Sets the real, effective, and saved user and group ids of the calling process to the given ids of
+		its user namespace. The supplementary groups are unchanged.
+	*/
+	SetIds(uid uint32, gid uint32) error
 }
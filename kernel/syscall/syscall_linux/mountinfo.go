@@ -0,0 +1,215 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_linux
+
+import (
+	"bufio"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const procSelfMountInfo = "/proc/self/mountinfo"
+
+type mountTable struct {
+	path string
+}
+
+/*
+	Constructs a new MountTable instance which reads the mount table of the current process from
+	/proc/self/mountinfo. The mount table is read afresh by each query.
+*/
+func NewMountTable() syscall.MountTable {
+	return NewMountTableFromFile(procSelfMountInfo)
+}
+
+/*
+	Constructs a new MountTable instance which reads the mount table from the given file, which must
+	be in the format of /proc/self/mountinfo.
+*/
+func NewMountTableFromFile(path string) syscall.MountTable {
+	return &mountTable{path}
+}
+
+func (mt *mountTable) Mounts() ([]syscall.MountEntry, error) {
+	file, err := os.Open(mt.path)
+	if err != nil {
+		return nil, gerror.NewFromError(ErrMountInfo, err)
+	}
+	defer file.Close()
+	return ParseMountInfo(file)
+}
+
+func (mt *mountTable) IsMountPoint(path string) (bool, error) {
+	mounts, err := mt.Mounts()
+	if err != nil {
+		return false, err
+	}
+	path = canonicalPath(path)
+	for _, m := range mounts {
+		if m.MountPoint == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (mt *mountTable) MountsUnder(path string) ([]syscall.MountEntry, error) {
+	mounts, err := mt.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	path = canonicalPath(path)
+	under := []syscall.MountEntry{}
+	for _, m := range mounts {
+		if isUnder(m.MountPoint, path) {
+			under = append(under, m)
+		}
+	}
+	return under, nil
+}
+
+func (mt *mountTable) MountInfo(path string) (syscall.MountEntry, error) {
+	mounts, err := mt.Mounts()
+	if err != nil {
+		return syscall.MountEntry{}, err
+	}
+	path = canonicalPath(path)
+	// A mount listed later either is mounted below an earlier mount or hides it, so the last mount
+	// whose mount point contains the path is the one on which the path resides.
+	found := -1
+	for i, m := range mounts {
+		if isUnder(path, m.MountPoint) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return syscall.MountEntry{}, gerror.Newf(ErrNoMount, "No mount found for %q", path)
+	}
+	return mounts[found], nil
+}
+
+// canonicalPath returns the absolute path, with any symbolic links resolved, of the given path.
+func canonicalPath(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	return path
+}
+
+// isUnder returns true if and only if the given path is the given directory or is below it.
+func isUnder(path string, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+/*
+	ParseMountInfo parses a mount table in the format of /proc/self/mountinfo. Escaped characters
+	in paths are unescaped.
+*/
+func ParseMountInfo(r io.Reader) ([]syscall.MountEntry, error) {
+	mounts := []syscall.MountEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		m, gerr := parseMountInfoLine(line)
+		if gerr != nil {
+			return nil, gerr
+		}
+		mounts = append(mounts, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, gerror.NewFromError(ErrMountInfo, err)
+	}
+	return mounts, nil
+}
+
+/*
+	parseMountInfoLine parses a line such as:
+
+		36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+*/
+func parseMountInfoLine(line string) (syscall.MountEntry, gerror.Gerror) {
+	var m syscall.MountEntry
+	fields := strings.Fields(line)
+	sep := -1
+	for i, field := range fields {
+		if field == "-" && i >= 6 {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(fields) < sep+4 {
+		return m, gerror.Newf(ErrParseMountInfo, "Malformed mountinfo line %q", line)
+	}
+
+	var err error
+	if m.MountId, err = strconv.Atoi(fields[0]); err != nil {
+		return m, gerror.Newf(ErrParseMountInfo, "Invalid mount id in mountinfo line %q", line)
+	}
+	if m.ParentId, err = strconv.Atoi(fields[1]); err != nil {
+		return m, gerror.Newf(ErrParseMountInfo, "Invalid parent id in mountinfo line %q", line)
+	}
+	dev := strings.Split(fields[2], ":")
+	if len(dev) != 2 {
+		return m, gerror.Newf(ErrParseMountInfo, "Invalid device in mountinfo line %q", line)
+	}
+	if m.Major, err = strconv.Atoi(dev[0]); err != nil {
+		return m, gerror.Newf(ErrParseMountInfo, "Invalid major device number in mountinfo line %q", line)
+	}
+	if m.Minor, err = strconv.Atoi(dev[1]); err != nil {
+		return m, gerror.Newf(ErrParseMountInfo, "Invalid minor device number in mountinfo line %q", line)
+	}
+	m.Root = unescapeMountInfo(fields[3])
+	m.MountPoint = unescapeMountInfo(fields[4])
+	m.Options = strings.Split(fields[5], ",")
+	m.OptionalFields = append([]string{}, fields[6:sep]...)
+	m.FsType = fields[sep+1]
+	m.Source = unescapeMountInfo(fields[sep+2])
+	m.SuperOptions = strings.Split(fields[sep+3], ",")
+	return m, nil
+}
+
+// unescapeMountInfo replaces the octal escapes, such as \040 for a space, used by the kernel in mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b = append(b, (s[i+1]-'0')<<6|(s[i+2]-'0')<<3|(s[i+3]-'0'))
+			i += 3
+			continue
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+func isOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
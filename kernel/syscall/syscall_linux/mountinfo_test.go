@@ -0,0 +1,129 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_linux_test
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMountInfo = `15 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+36 15 0:30 / /guardian-test/a rw,nosuid,nodev shared:20 master:3 - tmpfs tmpfs rw,size=1024k
+37 36 8:1 /srv/with\040space /guardian-test/a/b\040c ro,relatime - ext4 /dev/sda1 rw
+38 15 0:31 / /guardian-test/ab rw - tmpfs none rw
+39 15 0:32 / /guardian-test/a rw - tmpfs none rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := syscall_linux.ParseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if len(mounts) != 5 {
+		t.Errorf("Parsed %d mounts, expected 5", len(mounts))
+		return
+	}
+	expected := syscall.MountEntry{
+		MountId:        36,
+		ParentId:       15,
+		Major:          0,
+		Minor:          30,
+		Root:           "/",
+		MountPoint:     "/guardian-test/a",
+		Options:        []string{"rw", "nosuid", "nodev"},
+		OptionalFields: []string{"shared:20", "master:3"},
+		FsType:         "tmpfs",
+		Source:         "tmpfs",
+		SuperOptions:   []string{"rw", "size=1024k"},
+	}
+	if !reflect.DeepEqual(mounts[1], expected) {
+		t.Errorf("Parsed %+v, expected %+v", mounts[1], expected)
+	}
+	if mounts[2].Root != "/srv/with space" || mounts[2].MountPoint != "/guardian-test/a/b c" || len(mounts[2].OptionalFields) != 0 {
+		t.Errorf("Incorrectly parsed %+v", mounts[2])
+	}
+}
+
+func TestParseMalformedMountInfo(t *testing.T) {
+	for _, line := range []string{
+		"15 1 8:1 / / rw,relatime shared:1 ext4 /dev/sda1 rw",
+		"15 1 8:1 / / rw - ext4 /dev/sda1",
+		"x 1 8:1 / / rw - ext4 /dev/sda1 rw",
+		"15 1 8-1 / / rw - ext4 /dev/sda1 rw",
+	} {
+		_, err := syscall_linux.ParseMountInfo(strings.NewReader(line))
+		if err == nil || !err.(gerror.Gerror).EqualTag(syscall_linux.ErrParseMountInfo) {
+			t.Errorf("Incorrect error %s for %q", err, line)
+		}
+	}
+}
+
+func TestMountTableQueries(t *testing.T) {
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+	path := filepath.Join(tempDir, "mountinfo")
+	if err := ioutil.WriteFile(path, []byte(testMountInfo), 0600); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	mt := syscall_linux.NewMountTableFromFile(path)
+
+	for p, expected := range map[string]bool{
+		"/":                     true,
+		"/guardian-test":        false,
+		"/guardian-test/a":      true,
+		"/guardian-test/a/b c":  true,
+		"/guardian-test/a/b":    false,
+		"/guardian-test/a/../a": true,
+	} {
+		if isMountPoint, err := mt.IsMountPoint(p); isMountPoint != expected || err != nil {
+			t.Errorf("IsMountPoint(%q) returned (%v, %s)", p, isMountPoint, err)
+		}
+	}
+
+	under, err := mt.MountsUnder("/guardian-test/a")
+	if err != nil || len(under) != 3 || under[0].MountId != 36 || under[1].MountId != 37 || under[2].MountId != 39 {
+		t.Errorf("MountsUnder returned (%+v, %s)", under, err)
+	}
+
+	for p, expected := range map[string]int{
+		"/etc":                      15,
+		"/guardian-test/a/x":        39,
+		"/guardian-test/ab/x":       38,
+		"/guardian-test/a/b c/file": 39,
+	} {
+		if m, err := mt.MountInfo(p); m.MountId != expected || err != nil {
+			t.Errorf("MountInfo(%q) returned (%+v, %s), expected mount id %d", p, m, err, expected)
+		}
+	}
+}
+
+func TestMountTableMissingFile(t *testing.T) {
+	mt := syscall_linux.NewMountTableFromFile("/nosuch")
+	_, err := mt.Mounts()
+	if err == nil || !err.(gerror.Gerror).EqualTag(syscall_linux.ErrMountInfo) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_linux
+
+import (
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	trueSyscall "syscall"
+)
+
+// procCapLastCap contains the number of the highest capability which the kernel supports.
+const procCapLastCap = "/proc/sys/kernel/cap_last_cap"
+
+// clone_newcgroup is the Linux clone flag for a new cgroup namespace.
+const clone_newcgroup = 0x02000000
+
+// linuxCloneFlags maps each type of namespace to the corresponding Linux clone flag.
+var linuxCloneFlags = map[syscall.Namespaces]uintptr{
+	syscall.MountNS:  trueSyscall.CLONE_NEWNS,
+	syscall.UTSNS:    trueSyscall.CLONE_NEWUTS,
+	syscall.IPCNS:    trueSyscall.CLONE_NEWIPC,
+	syscall.PIDNS:    trueSyscall.CLONE_NEWPID,
+	syscall.NetNS:    trueSyscall.CLONE_NEWNET,
+	syscall.UserNS:   trueSyscall.CLONE_NEWUSER,
+	syscall.CgroupNS: clone_newcgroup,
+}
+
+type nsWrapper struct {
+}
+
+/*
+	Constructs a new SyscallNS instance. Most operations require root privileges or, for a user namespace,
+	a kernel which permits unprivileged user namespaces.
+*/
+func NewNS() syscall.SyscallNS {
+	return &nsWrapper{}
+}
+
+func (_ *nsWrapper) Unshare(namespaces syscall.Namespaces) error {
+	flags, gerr := toCloneFlags(namespaces)
+	if gerr != nil {
+		return gerr
+	}
+	if glog.V(2) {
+		glog.Infof("Unsharing %s", namespaces)
+	}
+	return trueSyscall.Unshare(int(flags))
+}
+
+func (_ *nsWrapper) Setns(ns *os.File, namespace syscall.Namespaces) error {
+	flags, gerr := toCloneFlags(namespace)
+	if gerr != nil {
+		return gerr
+	}
+	if glog.V(2) {
+		glog.Infof("Entering %s namespace %q", namespace, ns.Name())
+	}
+	_, _, errno := trueSyscall.RawSyscall(sys_setns, ns.Fd(), flags, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (_ *nsWrapper) OpenNS(pid int, namespace syscall.Namespaces) (*os.File, error) {
+	path, gerr := nsPath(pid, namespace)
+	if gerr != nil {
+		return nil, gerr
+	}
+	return os.Open(path)
+}
+
+func (_ *nsWrapper) NamespaceId(pid int, namespace syscall.Namespaces) (string, error) {
+	path, gerr := nsPath(pid, namespace)
+	if gerr != nil {
+		return "", gerr
+	}
+	return os.Readlink(path)
+}
+
+func (_ *nsWrapper) StartInNamespaces(cmd *exec.Cmd, namespaces syscall.Namespaces) error {
+	flags, gerr := toCloneFlags(namespaces)
+	if gerr != nil {
+		return gerr
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &trueSyscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= flags
+	if namespaces&syscall.UserNS != 0 && cmd.SysProcAttr.AmbientCaps == nil {
+		caps, err := allCapabilities()
+		if err != nil {
+			return err
+		}
+		cmd.SysProcAttr.AmbientCaps = caps
+	}
+	if glog.V(2) {
+		glog.Infof("Starting %q in new namespaces %s", cmd.Path, namespaces)
+	}
+	return cmd.Start()
+}
+
+func (_ *nsWrapper) Sethostname(name string) error {
+	if glog.V(2) {
+		glog.Infof("Setting host name %q", name)
+	}
+	return trueSyscall.Sethostname([]byte(name))
+}
+
+func (_ *nsWrapper) Setdomainname(name string) error {
+	if glog.V(2) {
+		glog.Infof("Setting domain name %q", name)
+	}
+	return trueSyscall.Setdomainname([]byte(name))
+}
+
+func (_ *nsWrapper) WriteIdMaps(pid int, uidMappings []syscall.IdMapping, gidMappings []syscall.IdMapping, denySetgroups bool) error {
+	if glog.V(2) {
+		glog.Infof("Writing ID maps of process %d: uids %v, gids %v, deny setgroups %v", pid, uidMappings, gidMappings, denySetgroups)
+	}
+	// The kernel requires setgroups to be denied before an unprivileged process writes gid_map.
+	if denySetgroups {
+		if err := ioutil.WriteFile(procFile(pid, "setgroups"), []byte("deny"), 0); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(procFile(pid, "uid_map"), []byte(idMap(uidMappings)), 0); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(procFile(pid, "gid_map"), []byte(idMap(gidMappings)), 0)
+}
+
+func (_ *nsWrapper) RunIdMapHelpers(pid int, uidMappings []syscall.IdMapping, gidMappings []syscall.IdMapping) error {
+	if err := runIdMapHelper("newuidmap", pid, uidMappings); err != nil {
+		return err
+	}
+	return runIdMapHelper("newgidmap", pid, gidMappings)
+}
+
+func (_ *nsWrapper) SetIds(uid uint32, gid uint32) error {
+	if glog.V(2) {
+		glog.Infof("Setting user id %d and group id %d", uid, gid)
+	}
+	// The group id is set first as setting a non-root user id may drop the capability to set it.
+	if err := trueSyscall.Setresgid(int(gid), int(gid), int(gid)); err != nil {
+		return err
+	}
+	return trueSyscall.Setresuid(int(uid), int(uid), int(uid))
+}
+
+// allCapabilities returns the numbers of all the capabilities which the kernel supports.
+func allCapabilities() ([]uintptr, error) {
+	content, err := ioutil.ReadFile(procCapLastCap)
+	if err != nil {
+		return nil, err
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, err
+	}
+	caps := make([]uintptr, last+1)
+	for i := range caps {
+		caps[i] = uintptr(i)
+	}
+	return caps, nil
+}
+
+// procFile returns the path of the given file in the /proc directory of the process with the given process id.
+func procFile(pid int, name string) string {
+	return fmt.Sprintf("/proc/%d/%s", pid, name)
+}
+
+// idMap returns the content of an ID map file, such as uid_map, with the given mappings.
+func idMap(mappings []syscall.IdMapping) string {
+	lines := make([]string, len(mappings))
+	for i, m := range mappings {
+		lines[i] = fmt.Sprintf("%d %d %d", m.ContainerId, m.HostId, m.Size)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// runIdMapHelper runs the given helper program, newuidmap or newgidmap, to write an ID map of the process with the given process id.
+func runIdMapHelper(helper string, pid int, mappings []syscall.IdMapping) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range mappings {
+		args = append(args, strconv.FormatUint(uint64(m.ContainerId), 10), strconv.FormatUint(uint64(m.HostId), 10),
+			strconv.FormatUint(uint64(m.Size), 10))
+	}
+	if glog.V(2) {
+		glog.Infof("Running %s %v", helper, args)
+	}
+	if out, err := exec.Command(helper, args...).CombinedOutput(); err != nil {
+		return gerror.Newf(ErrIdMapHelper, "%s %v failed: %s: %s", helper, args, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+/*
+	nsPath returns the path of the namespace file of the given type of the process with the given process id
+	or, if the process id is zero, of the calling process.
+*/
+func nsPath(pid int, namespace syscall.Namespaces) (string, gerror.Gerror) {
+	names := namespace.Names()
+	if len(names) != 1 || namespace&^syscall.AllNamespaces != 0 {
+		return "", gerror.Newf(ErrNamespaceType, "Invalid namespace type %s", namespace)
+	}
+	if pid == 0 {
+		return fmt.Sprintf("/proc/self/ns/%s", names[0]), nil
+	}
+	return fmt.Sprintf("/proc/%d/ns/%s", pid, names[0]), nil
+}
+
+func toCloneFlags(namespaces syscall.Namespaces) (uintptr, gerror.Gerror) {
+	if namespaces&^syscall.AllNamespaces != 0 {
+		return 0, gerror.Newf(ErrNamespaceType, "Invalid namespace types %s", namespaces)
+	}
+	var flags uintptr
+	for _, ns := range namespaces.Each() {
+		flags |= linuxCloneFlags[ns]
+	}
+	return flags, nil
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package syscall_linux
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	procSelfStatus = "/proc/self/status"
+	procSelfUidMap = "/proc/self/uid_map"
+)
+
+// cap_sys_admin is the number of the Linux capability CAP_SYS_ADMIN.
+const cap_sys_admin = 21
+
+// initialUidMap is the content, split into fields, of the uid_map of a process in the initial user namespace.
+var initialUidMap = []string{"0", "0", "4294967295"}
+
+/*
+	privilegedInUserNS returns true if and only if the calling process holds CAP_SYS_ADMIN in its user
+	namespace and that namespace is not the initial user namespace, in which case the process may perform
+	the mounts of a SyscallFS in mount namespaces which its user namespace owns.
+*/
+func privilegedInUserNS() (bool, error) {
+	capable, err := hasEffectiveCapability(procSelfStatus, cap_sys_admin)
+	if err != nil || !capable {
+		return false, err
+	}
+	initial, err := inInitialUserNS(procSelfUidMap)
+	return !initial, err
+}
+
+// hasEffectiveCapability returns true if and only if the given process status file shows the given capability as effective.
+func hasEffectiveCapability(statusPath string, capability uint) (bool, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			caps, err := strconv.ParseUint(fields[1], 16, 64)
+			if err != nil {
+				return false, err
+			}
+			return caps&(1<<capability) != 0, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("No effective capabilities found in %s", statusPath)
+}
+
+// inInitialUserNS returns true if and only if the given uid_map file is that of a process in the initial user namespace.
+func inInitialUserNS(uidMapPath string) (bool, error) {
+	content, err := ioutil.ReadFile(uidMapPath)
+	if err != nil {
+		return false, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != len(initialUidMap) {
+		return false, nil
+	}
+	for i, f := range fields {
+		if f != initialUidMap[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
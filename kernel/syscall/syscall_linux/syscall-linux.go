@@ -20,34 +20,124 @@ Package syscall-linux wraps the standard syscall package for Linux.
 package syscall_linux
 
 import (
+	"bufio"
+	"fmt"
 	"github.com/cf-guardian/guardian/gerror"
 	syscall "github.com/cf-guardian/guardian/kernel/syscall"
 	"github.com/golang/glog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	trueSyscall "syscall"
-	"io/ioutil"
+	"time"
 )
 
 // ImplErrorId is used for error ids relating to the implementation of this package.
 type ImplErrorId int
 
 const (
-	ErrNotRoot ImplErrorId = iota // root is required to create a SyscallFS
+	ErrNotRoot             ImplErrorId = iota // root, or CAP_SYS_ADMIN in a user namespace, is required to create a SyscallFS
+	ErrOverlayPath                            // an overlay directory path contains a character which cannot be passed to the kernel
+	ErrProcFilesystems                        // /proc/filesystems could not be read
+	ErrUnknownMountFlags                      // a mount flag has no Linux equivalent
+	ErrUnknownPropagation                     // a propagation has no Linux equivalent
+	ErrMountInfo                              // the mount table could not be read
+	ErrParseMountInfo                         // the mount table could not be parsed
+	ErrNoMount                                // no mount was found for a path
+	ErrStatfs                                 // the flags of a mount could not be read
+	ErrReadOnlyIgnored                        // a read-only bind mount turned out read-write
+	ErrUnknownUnmountFlags                    // an unmount flag has no Linux equivalent
+	ErrNamespaceType                          // a namespace type is invalid or, where a single type is required, is not a single type
+	ErrIdMapHelper                            // newuidmap or newgidmap failed to write an ID map
 )
 
+const procFilesystems = "/proc/filesystems"
+
+// st_rdonly is the statfs flag which indicates a read-only mount.
+const st_rdonly = 0x1
+
+// linuxMountFlags maps each mount flag to the corresponding Linux mount flag.
+var linuxMountFlags = map[syscall.MountFlags]uintptr{
+	syscall.MountReadOnly:    trueSyscall.MS_RDONLY,
+	syscall.MountNoSuid:      trueSyscall.MS_NOSUID,
+	syscall.MountNoDev:       trueSyscall.MS_NODEV,
+	syscall.MountNoExec:      trueSyscall.MS_NOEXEC,
+	syscall.MountSynchronous: trueSyscall.MS_SYNCHRONOUS,
+	syscall.MountDirSync:     trueSyscall.MS_DIRSYNC,
+	syscall.MountRemount:     trueSyscall.MS_REMOUNT,
+	syscall.MountNoAtime:     trueSyscall.MS_NOATIME,
+	syscall.MountNoDirAtime:  trueSyscall.MS_NODIRATIME,
+	syscall.MountRelatime:    trueSyscall.MS_RELATIME,
+	syscall.MountStrictAtime: trueSyscall.MS_STRICTATIME,
+	syscall.MountBind:        trueSyscall.MS_BIND,
+	syscall.MountMove:        trueSyscall.MS_MOVE,
+	syscall.MountRec:         trueSyscall.MS_REC,
+}
+
+// linuxUnmountFlags maps each unmount flag to the corresponding Linux unmount flag.
+var linuxUnmountFlags = map[syscall.UnmountFlags]int{
+	syscall.UnmountForce:    trueSyscall.MNT_FORCE,
+	syscall.UnmountDetach:   trueSyscall.MNT_DETACH,
+	syscall.UnmountNoFollow: umount_nofollow,
+}
+
+// umount_nofollow is the Linux unmount flag which prevents a symbolic link mount point being followed.
+const umount_nofollow = 0x8
+
+// linuxPropagation maps each propagation to the corresponding Linux mount flag.
+var linuxPropagation = map[syscall.Propagation]uintptr{
+	syscall.PropagationPrivate:    trueSyscall.MS_PRIVATE,
+	syscall.PropagationSlave:      trueSyscall.MS_SLAVE,
+	syscall.PropagationShared:     trueSyscall.MS_SHARED,
+	syscall.PropagationUnbindable: trueSyscall.MS_UNBINDABLE,
+}
+
 type syscallWrapper struct {
+	mt syscall.MountTable
 }
 
 /*
 	Constructs a new SyscallFS instance and returns it providing the effective user id
-	is root. Otherwise return an error.
+	is root or the caller holds CAP_SYS_ADMIN in a user namespace of its own, as an
+	unprivileged user may after creating a user namespace. Otherwise return an error.
 */
 func NewFS() (syscall.SyscallFS, error) {
-	euid := os.Geteuid()
-	if euid != 0 {
-		return nil, gerror.Newf(ErrNotRoot, "Effective user id %d is not root", euid)
+	if euid := os.Geteuid(); euid != 0 {
+		privileged, err := privilegedInUserNS()
+		if err != nil {
+			return nil, gerror.NewFromError(ErrNotRoot, err)
+		}
+		if !privileged {
+			return nil, gerror.Newf(ErrNotRoot, "Effective user id %d is not root and lacks CAP_SYS_ADMIN in a user namespace of its own", euid)
+		}
+	}
+	return &syscallWrapper{mt: NewMountTable()}, nil
+}
+
+func (_ *syscallWrapper) Mount(source string, target string, fsType string, flags syscall.MountFlags, data string) error {
+	lflags, gerr := toLinuxMountFlags(flags)
+	if gerr != nil {
+		return gerr
+	}
+	if glog.V(2) {
+		glog.Infof("Mounting %q at %q with type %q, flags %s, and data %q", source, target, fsType, flags, data)
+	}
+	return trueSyscall.Mount(source, target, fsType, lflags, data)
+}
+
+func toLinuxMountFlags(flags syscall.MountFlags) (uintptr, gerror.Gerror) {
+	var lflags uintptr
+	for flag, lflag := range linuxMountFlags {
+		if flags&flag != 0 {
+			lflags |= lflag
+			flags &^= flag
+		}
+	}
+	if flags != 0 {
+		return 0, gerror.Newf(ErrUnknownMountFlags, "Unknown mount flags %#x", uint(flags))
 	}
-	return &syscallWrapper{}, nil
+	return lflags, nil
 }
 
 func (_ *syscallWrapper) BindMountReadWrite(source string, mountPoint string) error {
@@ -60,8 +150,8 @@ func (sc *syscallWrapper) BindMountReadOnly(source string, mountPoint string) er
 	if err != nil {
 		return err
 	}
-	readOnly := checkReadOnly(mountPoint)
-	if !readOnly {
+	readOnly, gerr := checkReadOnly(mountPoint)
+	if gerr == nil && !readOnly {
 		if glog.V(2) {
 			glog.Infof("Remounting bind mount %q read-only", mountPoint)
 		}
@@ -72,17 +162,20 @@ func (sc *syscallWrapper) BindMountReadOnly(source string, mountPoint string) er
 			}
 			return err
 		}
-		if !checkReadOnly(mountPoint) {
-			glog.Warningf("Failed to remount bind mount of %q read-only", mountPoint)
-			if unmountErr := sc.Unmount(mountPoint); unmountErr != nil {
-				glog.Warningf("Failed to undo bind mount of %q while recovering from failure to remount read-only", mountPoint)
-			}
-			return gerror.Newf("Failed to remount bind mount of %q read-only", mountPoint)
-		} else {
-			if glog.V(2) {
-				glog.Infof("Successfully remounted bind mount %q read-only", mountPoint)
-			}
+		readOnly, gerr = checkReadOnly(mountPoint)
+		if gerr == nil && !readOnly {
+			gerr = gerror.Newf(ErrReadOnlyIgnored, "Bind mount of %q is read-write after remounting it read-only", mountPoint)
+		}
+		if gerr == nil && glog.V(2) {
+			glog.Infof("Successfully remounted bind mount %q read-only", mountPoint)
+		}
+	}
+	if gerr != nil {
+		glog.Warningf("Failed to make bind mount of %q read-only: %s", mountPoint, gerr)
+		if unmountErr := sc.Unmount(mountPoint); unmountErr != nil {
+			glog.Warningf("Failed to undo bind mount of %q while recovering from %s", mountPoint, gerr)
 		}
+		return gerr
 	}
 	return nil
 }
@@ -95,19 +188,151 @@ func doBindRemountReadOnly(source string, mountPoint string) error {
 	return trueSyscall.Mount(source, mountPoint, "", trueSyscall.MS_BIND|trueSyscall.MS_REMOUNT|trueSyscall.MS_RDONLY, "")
 }
 
-func checkReadOnly(mountPoint string) bool {
-	path, err := ioutil.TempDir(mountPoint, "cf-guardian-ro-check-")
+/*
+	checkReadOnly returns true if and only if the mount at the given mount point is read-only. The per-mount
+	flags reported by statfs are used so that nothing is written to the mount.
+*/
+func checkReadOnly(mountPoint string) (bool, gerror.Gerror) {
+	var stat trueSyscall.Statfs_t
+	if err := trueSyscall.Statfs(mountPoint, &stat); err != nil {
+		return false, gerror.NewFromError(ErrStatfs, err)
+	}
+	return stat.Flags&st_rdonly != 0, nil
+}
+
+func (sc *syscallWrapper) OverlayMount(lowerDir string, upperDir string, workDir string, mountPoint string) error {
+	// The kernel parses the mount data as a comma separated list of options.
+	for _, dir := range []string{lowerDir, upperDir, workDir} {
+		if strings.ContainsAny(dir, ",:") {
+			return gerror.Newf(ErrOverlayPath, "Overlay directory %q must not contain ',' or ':'", dir)
+		}
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workDir)
+	return sc.Mount("overlay", mountPoint, "overlay", 0, data)
+}
+
+func (_ *syscallWrapper) SetPropagation(mountPoint string, propagation syscall.Propagation, recursive bool) error {
+	flags, ok := linuxPropagation[propagation]
+	if !ok {
+		return gerror.Newf(ErrUnknownPropagation, "Unknown propagation %s", propagation)
+	}
+	if recursive {
+		flags |= trueSyscall.MS_REC
+	}
+	if glog.V(2) {
+		glog.Infof("Setting propagation of %q to %s (recursive %v)", mountPoint, propagation, recursive)
+	}
+	return trueSyscall.Mount("", mountPoint, "", flags, "")
+}
+
+func (sc *syscallWrapper) Unmount(mountPoint string) error {
+	return sc.UnmountWithOptions(mountPoint, syscall.UnmountOptions{})
+}
+
+func (_ *syscallWrapper) UnmountWithOptions(mountPoint string, opts syscall.UnmountOptions) error {
+	flags, gerr := toLinuxUnmountFlags(opts.Flags)
+	if gerr != nil {
+		return gerr
+	}
+	delay := opts.RetryDelay
+	for retry := 0; ; retry++ {
+		err := trueSyscall.Unmount(mountPoint, flags)
+		if err != trueSyscall.EBUSY || retry >= opts.Retries {
+			return err
+		}
+		if glog.V(2) {
+			glog.Infof("Unmounting %q failed with %s: retrying in %s", mountPoint, err, delay)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func toLinuxUnmountFlags(flags syscall.UnmountFlags) (int, gerror.Gerror) {
+	var lflags int
+	for flag, lflag := range linuxUnmountFlags {
+		if flags&flag != 0 {
+			lflags |= lflag
+			flags &^= flag
+		}
+	}
+	if flags != 0 {
+		return 0, gerror.Newf(ErrUnknownUnmountFlags, "Unknown unmount flags %#x", uint(flags))
+	}
+	return lflags, nil
+}
+
+func (sc *syscallWrapper) UnmountAll(path string, opts syscall.UnmountOptions) error {
+	mounts, err := sc.mt.MountsUnder(path)
 	if err != nil {
-		return true
-	} else {
-		err = os.Remove(path)
-		if err != nil {
-			glog.Warningf("Failed to delete file %q used to check read-only bind mount", path)
+		return err
+	}
+
+	// Unmount in reverse order of mounting, so that stacked mounts are unmounted from the top, and
+	// then deepest first.
+	mountPoints := make([]string, len(mounts))
+	for i, m := range mounts {
+		mountPoints[len(mounts)-1-i] = m.MountPoint
+	}
+	sort.Stable(byDepth(mountPoints))
+
+	var firstErr error
+	for _, mountPoint := range mountPoints {
+		if glog.V(2) {
+			glog.Infof("unmounting %q", mountPoint)
+		}
+		err := sc.UnmountWithOptions(mountPoint, opts)
+		if err == nil || err == trueSyscall.EINVAL || err == trueSyscall.ENOENT {
+			continue
+		}
+		glog.Errorf("Unmounting %q failed: %s", mountPoint, err)
+		if firstErr == nil {
+			firstErr = err
 		}
-		return false
 	}
+	return firstErr
+}
+
+// byDepth sorts paths so that deeper paths come first.
+type byDepth []string
+
+func (p byDepth) Len() int           { return len(p) }
+func (p byDepth) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byDepth) Less(i, j int) bool { return depth(p[i]) > depth(p[j]) }
+
+func depth(path string) int {
+	return strings.Count(filepath.Clean(path), "/")
+}
+
+func (_ *syscallWrapper) PivotRoot(newRoot string, putOld string) error {
+	return trueSyscall.PivotRoot(newRoot, putOld)
 }
 
-func (_ *syscallWrapper) Unmount(mountPoint string) error {
-	return trueSyscall.Unmount(mountPoint, 0)
+func (_ *syscallWrapper) Chroot(path string) error {
+	return trueSyscall.Chroot(path)
+}
+
+func (_ *syscallWrapper) Chdir(path string) error {
+	return trueSyscall.Chdir(path)
+}
+
+func (_ *syscallWrapper) FilesystemSupported(fsType string) (bool, error) {
+	file, err := os.Open(procFilesystems)
+	if err != nil {
+		return false, gerror.NewFromError(ErrProcFilesystems, err)
+	}
+	defer file.Close()
+
+	// Each line consists of an optional "nodev" flag followed by a filesystem type.
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == fsType {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, gerror.NewFromError(ErrProcFilesystems, err)
+	}
+	return false, nil
 }
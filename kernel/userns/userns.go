@@ -0,0 +1,324 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package userns provides a resource controller which gives a container a user namespace of its own with its
+own user and group ids.
+*/
+package userns
+
+import (
+	"bufio"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// ErrorId is used for error ids relating to the userns package.
+type ErrorId int
+
+const (
+	ErrMapping        ErrorId = iota // the mapping in the resource context could not be decoded
+	ErrInvalidMapping                // a mapping is empty, has overlapping ranges, or does not map root
+	ErrSubIds                        // the subordinate ids of the runner's user could not be read
+	ErrNamespaceId                   // the user namespace of a process could not be determined
+	ErrPublish                       // the mapping could not be published in the resource context
+	ErrWriteMaps                     // the ID maps of the container's process could not be written
+	ErrNotInitialised                // the controller has not successfully initialised
+	ErrNotIsolated                   // the container's process is not in a user namespace of its own
+	ErrSetIds                        // the user and group ids of the container's process could not be set
+)
+
+/*
+	MappingKey is the resource context configuration key of the container's Mapping. Init publishes the
+	mapping which the container uses, including any default, under the same key.
+*/
+const MappingKey = "userns.mapping"
+
+// hostNamespaceKey is the key under which Init publishes the identifier of the runner's user namespace.
+const hostNamespaceKey = "userns.host"
+
+// maxMappings is the maximum number of ranges in an ID map.
+const maxMappings = 340
+
+const (
+	defaultSubuidPath = "/etc/subuid"
+	defaultSubgidPath = "/etc/subgid"
+)
+
+// A Mapping maps the user and group ids of a container to those of the runner's user namespace.
+type Mapping struct {
+	Uids          []syscall.IdMapping // the user id ranges
+	Gids          []syscall.IdMapping // the group id ranges
+	DenySetgroups bool                // prevents setgroups, as the kernel requires if an unprivileged runner writes the maps itself
+	UseHelpers    bool                // writes the maps with newuidmap and newgidmap rather than directly
+}
+
+// Options modify the behaviour of NewWithOptions. The zero value gives the behaviour of New.
+type Options struct {
+	SubuidPath string // the file of subordinate user ids, or empty for /etc/subuid
+	SubgidPath string // the file of subordinate group ids, or empty for /etc/subgid
+}
+
+/*
+	A Controller is a resource controller which creates the container's process in a new user namespace and
+	maps the user and group ids of the namespace.
+
+	If the resource context has no Mapping, Init maps ids to the subordinate ids of the runner's effective
+	user and group, as listed in /etc/subuid and /etc/subgid. An unprivileged runner's own id is mapped to
+	root and its subordinate ids are mapped to the following ids, and the maps are written with newuidmap
+	and newgidmap, which the kernel permits to write such maps. A privileged runner's subordinate ids are
+	mapped from root upwards.
+
+	Enter makes the container's process root of the user namespace so that the resource controllers entered
+	after it, and the command unless the process specification has a credential, act as the container's root
+	user. A Controller provides the user namespace capability so that resource controllers which follow it,
+	such as a mountns.Controller, are initialised and entered after it. If setgroups is denied, the process
+	specification's credential is unusable since setting it sets the supplementary groups.
+*/
+type Controller struct {
+	sc   syscall.SyscallNS
+	opts Options
+}
+
+var (
+	_ kernel.ResourceDependent = &Controller{}
+	_ kernel.ResourceStarter   = &Controller{}
+	_ kernel.ResourceEnterer   = &Controller{}
+)
+
+// Creates a new Controller which uses the given SyscallNS instance.
+func New(sc syscall.SyscallNS) *Controller {
+	return NewWithOptions(sc, Options{})
+}
+
+// Creates a new Controller as for New but with the given options.
+func NewWithOptions(sc syscall.SyscallNS, opts Options) *Controller {
+	if opts.SubuidPath == "" {
+		opts.SubuidPath = defaultSubuidPath
+	}
+	if opts.SubgidPath == "" {
+		opts.SubgidPath = defaultSubgidPath
+	}
+	return &Controller{sc: sc, opts: opts}
+}
+
+/*
+	Init determines, validates, and publishes the container's mapping and adds a user namespace to the
+	namespaces in which the container's process is created.
+*/
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	var mapping Mapping
+	ok, err := rCtx.GetConfig(MappingKey, &mapping)
+	if err != nil {
+		return gerror.NewFromError(ErrMapping, err)
+	}
+	if !ok {
+		var gerr gerror.Gerror
+		if mapping, gerr = c.subordinateMapping(); gerr != nil {
+			return gerr
+		}
+	}
+	if gerr := validate(mapping.Uids, "user"); gerr != nil {
+		return gerr
+	}
+	if gerr := validate(mapping.Gids, "group"); gerr != nil {
+		return gerr
+	}
+	if glog.V(1) {
+		glog.Infof("Init: using mapping %+v", mapping)
+	}
+
+	hostNS, err := c.sc.NamespaceId(0, syscall.UserNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if err := rCtx.SetConfig(MappingKey, mapping); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	if err := rCtx.SetConfig(hostNamespaceKey, hostNS); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	rCtx.AddNamespaces(syscall.UserNS)
+	return nil
+}
+
+// Provides returns the capabilities provided by a Controller, namely a user namespace.
+func (c *Controller) Provides() []kernel.Capability {
+	return []kernel.Capability{kernel.UserNSCapability}
+}
+
+// Requires returns nil as a Controller does not require any capabilities.
+func (c *Controller) Requires() []kernel.Capability {
+	return nil
+}
+
+// Follows returns nil as a Controller does not follow any capabilities.
+func (c *Controller) Follows() []kernel.Capability {
+	return nil
+}
+
+// Started writes the ID maps of the container's process, which has the given process id.
+func (c *Controller) Started(rCtx kernel.ResourceContext, pid int) error {
+	var mapping Mapping
+	if ok, err := rCtx.GetConfig(MappingKey, &mapping); err != nil || !ok {
+		return gerror.New(ErrNotInitialised, "Mapping has not been published")
+	}
+	var err error
+	if mapping.UseHelpers {
+		err = c.sc.RunIdMapHelpers(pid, mapping.Uids, mapping.Gids)
+	} else {
+		err = c.sc.WriteIdMaps(pid, mapping.Uids, mapping.Gids, mapping.DenySetgroups)
+	}
+	if err != nil {
+		return gerror.NewFromError(ErrWriteMaps, err)
+	}
+	return nil
+}
+
+/*
+	Enter sets the user and group ids of the container's process to root of its user namespace. It fails
+	with ErrNotIsolated if the calling process is in the runner's user namespace.
+*/
+func (c *Controller) Enter(rCtx kernel.ResourceContext) error {
+	var hostNS string
+	if ok, err := rCtx.GetConfig(hostNamespaceKey, &hostNS); err != nil || !ok {
+		return gerror.New(ErrNotInitialised, "Mapping has not been published")
+	}
+	ns, err := c.sc.NamespaceId(0, syscall.UserNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if ns == hostNS {
+		return gerror.Newf(ErrNotIsolated, "Process is in the runner's user namespace %q", ns)
+	}
+	if err := c.sc.SetIds(0, 0); err != nil {
+		return gerror.NewFromError(ErrSetIds, err)
+	}
+	return nil
+}
+
+// subordinateMapping returns the mapping to the subordinate ids of the runner's effective user and group.
+func (c *Controller) subordinateMapping() (Mapping, gerror.Gerror) {
+	euid, egid := os.Geteuid(), os.Getegid()
+	var uname, gname string
+	if u, err := user.LookupId(strconv.Itoa(euid)); err == nil {
+		uname = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(egid)); err == nil {
+		gname = g.Name
+	}
+	uids, gerr := subordinateIds(c.opts.SubuidPath, uint32(euid), uname)
+	if gerr != nil {
+		return Mapping{}, gerr
+	}
+	gids, gerr := subordinateIds(c.opts.SubgidPath, uint32(egid), gname)
+	if gerr != nil {
+		return Mapping{}, gerr
+	}
+	return Mapping{Uids: uids, Gids: gids, UseHelpers: euid != 0}, nil
+}
+
+/*
+	subordinateIds returns mappings to the ranges of subordinate ids listed for the given id, or its name, in
+	the given file, which has the format of /etc/subuid. Unless the id is root, the id itself is mapped to
+	root and the ranges follow it. Otherwise the ranges are mapped from root upwards.
+*/
+func subordinateIds(path string, id uint32, name string) ([]syscall.IdMapping, gerror.Gerror) {
+	var mappings []syscall.IdMapping
+	next := uint32(0)
+	if id != 0 {
+		mappings = append(mappings, syscall.IdMapping{ContainerId: 0, HostId: id, Size: 1})
+		next = 1
+	}
+	f, err := os.Open(path)
+	if err != nil && !(os.IsNotExist(err) && id != 0) {
+		return nil, gerror.NewFromError(ErrSubIds, err)
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Split(line, ":")
+			if len(fields) != 3 {
+				return nil, gerror.Newf(ErrSubIds, "Invalid line %q in %s", line, path)
+			}
+			if fields[0] != name && fields[0] != strconv.FormatUint(uint64(id), 10) {
+				continue
+			}
+			start, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, gerror.Newf(ErrSubIds, "Invalid line %q in %s: %s", line, path, err)
+			}
+			count, err := strconv.ParseUint(fields[2], 10, 32)
+			if err != nil {
+				return nil, gerror.Newf(ErrSubIds, "Invalid line %q in %s: %s", line, path, err)
+			}
+			mappings = append(mappings, syscall.IdMapping{ContainerId: next, HostId: uint32(start), Size: uint32(count)})
+			next += uint32(count)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, gerror.NewFromError(ErrSubIds, err)
+		}
+	}
+	if len(mappings) == 0 {
+		return nil, gerror.Newf(ErrSubIds, "No subordinate ids of %d (%q) in %s", id, name, path)
+	}
+	return mappings, nil
+}
+
+/*
+	validate checks that the given mappings, of the given kind of id, are non-empty, map root of the
+	container, and do not overlap either in the container or in the runner's user namespace, as the kernel
+	requires.
+*/
+func validate(mappings []syscall.IdMapping, kind string) gerror.Gerror {
+	if len(mappings) == 0 || len(mappings) > maxMappings {
+		return gerror.Newf(ErrInvalidMapping, "Number of %s id mappings %d is not between 1 and %d", kind, len(mappings), maxMappings)
+	}
+	root := false
+	for i, m := range mappings {
+		if m.Size == 0 || uint64(m.ContainerId)+uint64(m.Size) > 1<<32 || uint64(m.HostId)+uint64(m.Size) > 1<<32 {
+			return gerror.Newf(ErrInvalidMapping, "Invalid %s id mapping %+v", kind, m)
+		}
+		for _, o := range mappings[:i] {
+			if overlap(m.ContainerId, m.Size, o.ContainerId, o.Size) || overlap(m.HostId, m.Size, o.HostId, o.Size) {
+				return gerror.Newf(ErrInvalidMapping, "The %s id mappings %+v and %+v overlap", kind, o, m)
+			}
+		}
+		if m.ContainerId == 0 {
+			root = true
+		}
+	}
+	if !root {
+		return gerror.Newf(ErrInvalidMapping, "The %s id mappings %+v do not map root", kind, mappings)
+	}
+	return nil
+}
+
+// overlap returns true if and only if the ranges with the given starts and sizes overlap.
+func overlap(start1 uint32, size1 uint32, start2 uint32, size2 uint32) bool {
+	return uint64(start1) < uint64(start2)+uint64(size2) && uint64(start2) < uint64(start1)+uint64(size1)
+}
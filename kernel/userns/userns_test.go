@@ -0,0 +1,352 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package userns_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
+	"github.com/cf-guardian/guardian/kernel/userns"
+	"github.com/cf-guardian/guardian/runner"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const (
+	hostNS      = "user:[1]"
+	containerNS = "user:[2]"
+)
+
+var testMapping = userns.Mapping{
+	Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}},
+	Gids: []syscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 1000}, {ContainerId: 1000, HostId: 300000, Size: 10}},
+}
+
+func TestInit(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, testMapping)
+	checkMapping(t, rCtx, testMapping)
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.UserNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestProvides(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	c := userns.New(mockSyscallNS)
+	if p := c.Provides(); !reflect.DeepEqual(p, []kernel.Capability{kernel.UserNSCapability}) {
+		t.Errorf("Incorrect capabilities provided %v", p)
+	}
+	if r, f := c.Requires(), c.Follows(); r != nil || f != nil {
+		t.Errorf("Incorrect capabilities required %v or followed %v", r, f)
+	}
+}
+
+func TestOrdering(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return(hostNS, nil)
+	f := &follower{}
+	if _, gerr := runner.BuildContainer(createContext(t, testMapping), []kernel.ResourceController{f, userns.New(mockSyscallNS)}); gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	if f.namespaces&syscall.UserNS == 0 {
+		t.Errorf("Follower was initialised before the user namespace controller")
+	}
+}
+
+func TestInitSubordinateIds(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+	if os.Geteuid() != 0 {
+		t.Skip("The default mapping of an unprivileged user is not tested")
+	}
+
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+	euid, egid := strconv.Itoa(os.Geteuid()), strconv.Itoa(os.Getegid())
+	subuid := writeFile(t, tempDir, "subuid", "# comment\nother:1:2\n"+euid+":100000:65536\n\n"+euid+":300000:10\n")
+	subgid := writeFile(t, tempDir, "subgid", egid+":200000:1000\n")
+
+	rCtx := kernel.CreateResourceContext("/")
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return(hostNS, nil)
+	c := userns.NewWithOptions(mockSyscallNS, userns.Options{SubuidPath: subuid, SubgidPath: subgid})
+	if err := c.Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	checkMapping(t, rCtx, userns.Mapping{
+		Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 100000, Size: 65536}, {ContainerId: 65536, HostId: 300000, Size: 10}},
+		Gids: []syscall.IdMapping{{ContainerId: 0, HostId: 200000, Size: 1000}},
+	})
+}
+
+func TestInitSubordinateIdsFailure(t *testing.T) {
+	tempDir := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, tempDir)
+	for _, content := range []string{"invalid\n", "0:x:1\n", "0:1:x\n"} {
+		subuid := writeFile(t, tempDir, "subuid", content)
+		subgid := writeFile(t, tempDir, "subgid", "0:1:1\n")
+		testSubordinateIdsFailure(t, subuid, subgid)
+	}
+	testSubordinateIdsFailure(t, filepath.Join(tempDir, "no-such-file"), filepath.Join(tempDir, "no-such-file"))
+}
+
+func testSubordinateIdsFailure(t *testing.T, subuid string, subgid string) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+	if os.Geteuid() != 0 {
+		t.Skip("The default mapping of an unprivileged user is not tested")
+	}
+
+	c := userns.NewWithOptions(mockSyscallNS, userns.Options{SubuidPath: subuid, SubgidPath: subgid})
+	err := c.Init(kernel.CreateResourceContext("/"))
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrSubIds) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitInvalidMapping(t *testing.T) {
+	valid := []syscall.IdMapping{{ContainerId: 0, HostId: 1000, Size: 1}}
+	for _, mapping := range []userns.Mapping{
+		{Uids: nil, Gids: valid},
+		{Uids: valid, Gids: nil},
+		{Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 1000, Size: 0}}, Gids: valid},
+		{Uids: []syscall.IdMapping{{ContainerId: 1, HostId: 1000, Size: 1}}, Gids: valid},
+		{Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 4294967295, Size: 2}}, Gids: valid},
+		{Uids: []syscall.IdMapping{{ContainerId: 0, HostId: 1000, Size: 10}, {ContainerId: 5, HostId: 2000, Size: 10}}, Gids: valid},
+		{Uids: valid, Gids: []syscall.IdMapping{{ContainerId: 0, HostId: 1000, Size: 10}, {ContainerId: 10, HostId: 1005, Size: 10}}},
+	} {
+		mockCtrl, mockSyscallNS := setupMocks(t)
+		err := userns.New(mockSyscallNS).Init(createContext(t, mapping))
+		if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrInvalidMapping) {
+			t.Errorf("Incorrect error %v for mapping %+v", err, mapping)
+		}
+		mockCtrl.Finish()
+	}
+}
+
+func TestInitInvalidMappingConfig(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig(userns.MappingKey, "0 1000 1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := userns.New(mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrMapping) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := createContext(t, testMapping)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return("", errors.New("an error"))
+	err := userns.New(mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != 0 {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestStarted(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	mapping := testMapping
+	mapping.DenySetgroups = true
+	rCtx := initController(t, mockSyscallNS, mapping)
+	mockSyscallNS.EXPECT().WriteIdMaps(123, mapping.Uids, mapping.Gids, true)
+	if err := userns.New(mockSyscallNS).Started(rCtx, 123); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestStartedWithHelpers(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	mapping := testMapping
+	mapping.UseHelpers = true
+	rCtx := initController(t, mockSyscallNS, mapping)
+	mockSyscallNS.EXPECT().RunIdMapHelpers(123, mapping.Uids, mapping.Gids)
+	if err := userns.New(mockSyscallNS).Started(rCtx, 123); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestStartedFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, testMapping)
+	mockSyscallNS.EXPECT().WriteIdMaps(123, testMapping.Uids, testMapping.Gids, false).Return(errors.New("an error"))
+	err := userns.New(mockSyscallNS).Started(rCtx, 123)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrWriteMaps) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestStartedNotInitialised(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	err := userns.New(mockSyscallNS).Started(kernel.CreateResourceContext("/"), 123)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnter(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, testMapping)
+	gomock.InOrder(
+		mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return(containerNS, nil),
+		mockSyscallNS.EXPECT().SetIds(uint32(0), uint32(0)),
+	)
+	if err := userns.New(mockSyscallNS).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestEnterNotInitialised(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	err := userns.New(mockSyscallNS).Enter(createContext(t, testMapping))
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNotIsolated(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, testMapping)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return(hostNS, nil)
+	err := userns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrNotIsolated) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, testMapping)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return("", errors.New("an error"))
+	err := userns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterSetIdsFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, testMapping)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return(containerNS, nil)
+	mockSyscallNS.EXPECT().SetIds(uint32(0), uint32(0)).Return(errors.New("an error"))
+	err := userns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(userns.ErrSetIds) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+// follower is a resource controller which follows a user namespace and records the namespaces of the container's
+// process when it is initialised.
+type follower struct {
+	namespaces syscall.Namespaces
+}
+
+func (f *follower) Init(rCtx kernel.ResourceContext) error {
+	f.namespaces = rCtx.GetProcess().Namespaces
+	return nil
+}
+
+func (f *follower) Provides() []kernel.Capability {
+	return nil
+}
+
+func (f *follower) Requires() []kernel.Capability {
+	return nil
+}
+
+func (f *follower) Follows() []kernel.Capability {
+	return []kernel.Capability{kernel.UserNSCapability}
+}
+
+func initController(t *testing.T, mockSyscallNS *mock_syscall.MockSyscallNS, mapping userns.Mapping) kernel.ResourceContext {
+	rCtx := createContext(t, mapping)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UserNS).Return(hostNS, nil)
+	if err := userns.New(mockSyscallNS).Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func createContext(t *testing.T, mapping userns.Mapping) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig(userns.MappingKey, mapping); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func checkMapping(t *testing.T, rCtx kernel.ResourceContext, expected userns.Mapping) {
+	var mapping userns.Mapping
+	if ok, err := rCtx.GetConfig(userns.MappingKey, &mapping); !ok || err != nil || !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Published mapping was %+v (%v, %v), expected %+v", mapping, ok, err, expected)
+	}
+}
+
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return path
+}
+
+func setupMocks(t *testing.T) (*gomock.Controller, *mock_syscall.MockSyscallNS) {
+	mockCtrl := gomock.NewController(t)
+	return mockCtrl, mock_syscall.NewMockSyscallNS(mockCtrl)
+}
@@ -0,0 +1,302 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package utsns provides a resource controller which gives a container a UTS namespace of its own with its own
+host name and domain name.
+*/
+package utsns
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/mountns"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"strings"
+	trueSyscall "syscall"
+)
+
+// ErrorId is used for error ids relating to the utsns package.
+type ErrorId int
+
+const (
+	ErrNames          ErrorId = iota // the names in the resource context could not be decoded
+	ErrInvalidName                   // a host name or domain name is invalid
+	ErrNamespaceId                   // the UTS namespace of a process could not be determined
+	ErrPublish                       // the names could not be published in the resource context
+	ErrWriteHostname                 // /etc/hostname could not be written in the root filesystem
+	ErrWriteHosts                    // /etc/hosts could not be written in the root filesystem
+	ErrNotInitialised                // the controller has not successfully initialised
+	ErrNotIsolated                   // the container's process is not in a UTS namespace of its own
+	ErrSethostname                   // the host name could not be set
+	ErrSetdomainname                 // the domain name could not be set
+	ErrRootFS                        // the generated root filesystem in the resource context could not be decoded
+	ErrUnsafeEtc                     // /etc in the root filesystem is a symbolic link or is not a directory
+)
+
+/*
+	NamesKey is the resource context configuration key of the container's Names. Init publishes the names
+	which the container uses, including any default, under the same key.
+*/
+const NamesKey = "utsns.names"
+
+// hostNamespaceKey is the key under which Init publishes the identifier of the runner's UTS namespace.
+const hostNamespaceKey = "utsns.host"
+
+// maxNameLength is the maximum length of a host name or domain name.
+const maxNameLength = 64
+
+// hostsAddress is the address of the entry for the container's host name in /etc/hosts.
+const hostsAddress = "127.0.1.1"
+
+// defaultHosts are the entries of /etc/hosts which are written if the root filesystem has no /etc/hosts.
+var defaultHosts = []string{
+	"127.0.0.1\tlocalhost",
+	"::1\tlocalhost ip6-localhost ip6-loopback",
+}
+
+const etcFileMode os.FileMode = 0644
+
+// Names are the host name and domain name of a container.
+type Names struct {
+	Hostname   string // the host name, or empty to use the container's handle
+	Domainname string // the NIS domain name, or empty to leave the domain name unset
+}
+
+/*
+	A Controller is a resource controller which creates the container's process in a new UTS namespace and
+	sets the host name and domain name of the namespace.
+
+	Init also writes the host name to /etc/hostname, and an entry for it to /etc/hosts, in the root
+	filesystem generated by a mountns.Controller. Since neither /etc of the host nor that of a prototype
+	root filesystem must be modified, this is not done if no root filesystem has been generated. A
+	Controller follows the root filesystem capability so that the generated root filesystem is published
+	before it is initialised. The files come from the prototype, which is not trusted, so symbolic links
+	are not followed and files other than regular files are not written.
+*/
+type Controller struct {
+	sc syscall.SyscallNS
+}
+
+var (
+	_ kernel.ResourceDependent = &Controller{}
+	_ kernel.ResourceEnterer   = &Controller{}
+)
+
+// Creates a new Controller which uses the given SyscallNS instance.
+func New(sc syscall.SyscallNS) *Controller {
+	return &Controller{sc: sc}
+}
+
+/*
+	Init determines and publishes the container's names, writes /etc/hostname and /etc/hosts in the generated
+	root filesystem, if any, and adds a UTS namespace to the namespaces in which the container's process is created.
+*/
+func (c *Controller) Init(rCtx kernel.ResourceContext) error {
+	var names Names
+	if _, err := rCtx.GetConfig(NamesKey, &names); err != nil {
+		return gerror.NewFromError(ErrNames, err)
+	}
+	if names.Hostname == "" {
+		names.Hostname = rCtx.GetId()
+	}
+	if gerr := validate(names); gerr != nil {
+		return gerr
+	}
+	if glog.V(1) {
+		glog.Infof("Init: using names %+v", names)
+	}
+
+	hostNS, err := c.sc.NamespaceId(0, syscall.UTSNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if err := rCtx.SetConfig(NamesKey, names); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+	if err := rCtx.SetConfig(hostNamespaceKey, hostNS); err != nil {
+		return gerror.NewFromError(ErrPublish, err)
+	}
+
+	var root string
+	ok, err := rCtx.GetConfig(mountns.RootKey, &root)
+	if err != nil {
+		return gerror.NewFromError(ErrRootFS, err)
+	}
+	if ok {
+		etc := filepath.Join(root, "etc")
+		if info, err := os.Lstat(etc); err != nil || !info.IsDir() {
+			return gerror.Newf(ErrUnsafeEtc, "%q is not a directory (%v)", etc, err)
+		}
+		if err := writeEtcFile(filepath.Join(etc, "hostname"), names.Hostname+"\n"); err != nil {
+			return gerror.NewFromError(ErrWriteHostname, err)
+		}
+		if err := writeHosts(filepath.Join(etc, "hosts"), names); err != nil {
+			return gerror.NewFromError(ErrWriteHosts, err)
+		}
+	}
+
+	rCtx.AddNamespaces(syscall.UTSNS)
+	return nil
+}
+
+// Provides returns nil as a Controller does not provide any capabilities.
+func (c *Controller) Provides() []kernel.Capability {
+	return nil
+}
+
+// Requires returns nil as a Controller does not require any capabilities.
+func (c *Controller) Requires() []kernel.Capability {
+	return nil
+}
+
+// Follows returns the capabilities followed by a Controller, namely a root filesystem.
+func (c *Controller) Follows() []kernel.Capability {
+	return []kernel.Capability{kernel.RootFSCapability}
+}
+
+/*
+	Enter sets the host name and domain name published by Init. It fails with ErrNotIsolated, rather than
+	changing the names of the runner's UTS namespace, if the calling process is in that namespace.
+*/
+func (c *Controller) Enter(rCtx kernel.ResourceContext) error {
+	var names Names
+	var hostNS string
+	if ok, err := rCtx.GetConfig(hostNamespaceKey, &hostNS); err != nil || !ok {
+		return gerror.New(ErrNotInitialised, "Names have not been published")
+	}
+	if _, err := rCtx.GetConfig(NamesKey, &names); err != nil {
+		return gerror.NewFromError(ErrNames, err)
+	}
+	ns, err := c.sc.NamespaceId(0, syscall.UTSNS)
+	if err != nil {
+		return gerror.NewFromError(ErrNamespaceId, err)
+	}
+	if ns == hostNS {
+		return gerror.Newf(ErrNotIsolated, "Process is in the runner's UTS namespace %q", ns)
+	}
+	if glog.V(1) {
+		glog.Infof("Enter: setting names %+v", names)
+	}
+	if err := c.sc.Sethostname(names.Hostname); err != nil {
+		return gerror.NewFromError(ErrSethostname, err)
+	}
+	if names.Domainname != "" {
+		if err := c.sc.Setdomainname(names.Domainname); err != nil {
+			return gerror.NewFromError(ErrSetdomainname, err)
+		}
+	}
+	return nil
+}
+
+/*
+	validate checks that the host name is a valid host name of at most 64 characters and that the domain
+	name, if any, is also at most 64 characters.
+*/
+func validate(names Names) gerror.Gerror {
+	if len(names.Hostname) > maxNameLength || !validHostname(names.Hostname) {
+		return gerror.Newf(ErrInvalidName, "Invalid host name %q", names.Hostname)
+	}
+	if len(names.Domainname) > maxNameLength || strings.ContainsAny(names.Domainname, "\x00\n") {
+		return gerror.Newf(ErrInvalidName, "Invalid domain name %q", names.Domainname)
+	}
+	return nil
+}
+
+// validHostname returns true if and only if the given name consists of letters, digits, and hyphens separated by dots.
+func validHostname(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+	writeHosts writes an entry for the container's host name to the given hosts file, replacing any previous
+	entry for the same address. If the file does not exist, it is created with entries for localhost.
+*/
+func writeHosts(path string, names Names) error {
+	lines := append([]string{}, defaultHosts...)
+	if f, err := openEtcFile(path, os.O_RDONLY); err == nil {
+		lines = nil
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) == 0 || fields[0] != hostsAddress {
+				lines = append(lines, scanner.Text())
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	entry := hostsAddress + "\t" + names.Hostname
+	if names.Domainname != "" {
+		entry = hostsAddress + "\t" + names.Hostname + "." + names.Domainname + " " + names.Hostname
+	}
+	return writeEtcFile(path, strings.Join(append(lines, entry), "\n")+"\n")
+}
+
+// writeEtcFile replaces the content of the given file in /etc of a root filesystem, creating it if necessary.
+func writeEtcFile(path string, content string) error {
+	f, err := openEtcFile(path, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	// The file is truncated only once it is known to be a regular file.
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteString(content)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+/*
+	openEtcFile opens the given file in /etc of a root filesystem with the given flags. A symbolic link is
+	not followed, since it could refer to a file of the host, and the file must be a regular file. The file
+	is opened without blocking so that a named pipe cannot stall the runner.
+*/
+func openEtcFile(path string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag|trueSyscall.O_NOFOLLOW|trueSyscall.O_NONBLOCK, etcFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		if err == nil {
+			err = fmt.Errorf("%q is not a regular file", path)
+		}
+		return nil, err
+	}
+	return f, nil
+}
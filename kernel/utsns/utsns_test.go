@@ -0,0 +1,405 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package utsns_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/mountns"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
+	"github.com/cf-guardian/guardian/kernel/utsns"
+	"github.com/cf-guardian/guardian/test_support"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	hostNS      = "uts:[1]"
+	containerNS = "uts:[2]"
+)
+
+func TestInit(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	etc := test_support.CreateDir(root, "etc")
+	writeFile(t, etc, "hosts", "127.0.0.1 localhost\n127.0.1.1 old-host\n10.0.0.1 other\n")
+
+	rCtx := createGeneratedContext(t, root, utsns.Names{Hostname: "test-host", Domainname: "example.com"})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	if err := utsns.New(mockSyscallNS).Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	var names utsns.Names
+	if ok, err := rCtx.GetConfig(utsns.NamesKey, &names); !ok || err != nil || names != (utsns.Names{"test-host", "example.com"}) {
+		t.Errorf("Incorrect published names %+v (%v, %v)", names, ok, err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != syscall.UTSNS {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+	checkFile(t, etc, "hostname", "test-host\n")
+	checkFile(t, etc, "hosts", "127.0.0.1 localhost\n10.0.0.1 other\n127.0.1.1\ttest-host.example.com test-host\n")
+}
+
+func TestInitDefaultHostname(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	etc := test_support.CreateDir(root, "etc")
+
+	rCtx := kernel.CreateResourceContextWithOptions(root, kernel.ContextOptions{Id: "test-id"})
+	if err := rCtx.SetConfig(mountns.RootKey, root); err != nil {
+		t.Fatalf("%s", err)
+	}
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	if err := utsns.New(mockSyscallNS).Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+
+	var names utsns.Names
+	if ok, err := rCtx.GetConfig(utsns.NamesKey, &names); !ok || err != nil || names != (utsns.Names{Hostname: "test-id"}) {
+		t.Errorf("Incorrect published names %+v (%v, %v)", names, ok, err)
+	}
+	checkFile(t, etc, "hostname", "test-id\n")
+	checkFile(t, etc, "hosts", "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n127.0.1.1\ttest-id\n")
+}
+
+func TestInitHostRoot(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// The files of the host's /etc are not written.
+	rCtx := createContext(t, "/", utsns.Names{Hostname: "test-host"})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	if err := utsns.New(mockSyscallNS).Init(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestInitPrototype(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// Without a generated root filesystem, the files of the prototype are not written.
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	etc := test_support.CreateDir(root, "etc")
+	writeFile(t, etc, "hostname", "prototype\n")
+	writeFile(t, etc, "hosts", "127.0.0.1 localhost\n")
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	if err := utsns.New(mockSyscallNS).Init(createContext(t, root, utsns.Names{Hostname: "test-host"})); err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	checkFile(t, etc, "hostname", "prototype\n")
+	checkFile(t, etc, "hosts", "127.0.0.1 localhost\n")
+}
+
+func TestInitInvalidRootFSConfig(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := createContext(t, "/", utsns.Names{})
+	if err := rCtx.SetConfig(mountns.RootKey, 1); err != nil {
+		t.Fatalf("%s", err)
+	}
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	err := utsns.New(mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrRootFS) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitInvalidName(t *testing.T) {
+	for _, names := range []utsns.Names{
+		{Hostname: "under_score"},
+		{Hostname: "-leading"},
+		{Hostname: "trailing-"},
+		{Hostname: "empty..label"},
+		{Hostname: strings.Repeat("a", 65)},
+		{Hostname: "test-host", Domainname: strings.Repeat("a", 65)},
+		{Hostname: "test-host", Domainname: "new\nline"},
+	} {
+		mockCtrl, mockSyscallNS := setupMocks(t)
+		err := utsns.New(mockSyscallNS).Init(createContext(t, "/", names))
+		if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrInvalidName) {
+			t.Errorf("Incorrect error %v for names %+v", err, names)
+		}
+		mockCtrl.Finish()
+	}
+}
+
+func TestInitInvalidNamesConfig(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := kernel.CreateResourceContext("/")
+	if err := rCtx.SetConfig(utsns.NamesKey, "test-host"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := utsns.New(mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrNames) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := createContext(t, "/", utsns.Names{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return("", errors.New("an error"))
+	err := utsns.New(mockSyscallNS).Init(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	if ns := rCtx.GetProcess().Namespaces; ns != 0 {
+		t.Errorf("Incorrect namespaces %s", ns)
+	}
+}
+
+func TestInitWriteHostnameFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	// /etc/hostname is not a regular file.
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	etc := test_support.CreateDir(root, "etc")
+	test_support.CreateDir(etc, "hostname")
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	err := utsns.New(mockSyscallNS).Init(createGeneratedContext(t, root, utsns.Names{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrWriteHostname) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitWriteHostsFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root)
+	etc := test_support.CreateDir(root, "etc")
+	test_support.CreateDir(etc, "hosts")
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	err := utsns.New(mockSyscallNS).Init(createGeneratedContext(t, root, utsns.Names{}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrWriteHosts) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestInitHostnameSymlink(t *testing.T) {
+	testInitSymlink(t, "hostname", utsns.ErrWriteHostname)
+}
+
+func TestInitHostsSymlink(t *testing.T) {
+	testInitSymlink(t, "hosts", utsns.ErrWriteHosts)
+}
+
+// testInitSymlink checks that Init does not write a file outside the root filesystem through a symbolic link.
+func testInitSymlink(t *testing.T, name string, expected utsns.ErrorId) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	outside := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root, outside)
+	etc := test_support.CreateDir(root, "etc")
+	writeFile(t, outside, "target", "host\n")
+	if err := os.Symlink(filepath.Join(outside, "target"), filepath.Join(etc, name)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	err := utsns.New(mockSyscallNS).Init(createGeneratedContext(t, root, utsns.Names{Hostname: "test-host"}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(expected) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	checkFile(t, outside, "target", "host\n")
+}
+
+func TestInitEtcSymlink(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	root := test_support.CreateTempDir()
+	outside := test_support.CreateTempDir()
+	defer test_support.CleanupDirs(t, root, outside)
+	writeFile(t, outside, "hostname", "host\n")
+	if err := os.Symlink(outside, filepath.Join(root, "etc")); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	err := utsns.New(mockSyscallNS).Init(createGeneratedContext(t, root, utsns.Names{Hostname: "test-host"}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrUnsafeEtc) {
+		t.Errorf("Incorrect error %v", err)
+	}
+	checkFile(t, outside, "hostname", "host\n")
+}
+
+func TestEnter(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, utsns.Names{Hostname: "test-host", Domainname: "example.com"})
+	gomock.InOrder(
+		mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(containerNS, nil),
+		mockSyscallNS.EXPECT().Sethostname("test-host"),
+		mockSyscallNS.EXPECT().Setdomainname("example.com"),
+	)
+	// Enter is called on a new controller, as in the container's process.
+	if err := utsns.New(mockSyscallNS).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestEnterNoDomainname(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, utsns.Names{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(containerNS, nil)
+	mockSyscallNS.EXPECT().Sethostname(rCtx.GetId())
+	if err := utsns.New(mockSyscallNS).Enter(rCtx); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestEnterNotInitialised(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	err := utsns.New(mockSyscallNS).Enter(createContext(t, "/", utsns.Names{Hostname: "test-host"}))
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrNotInitialised) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNotIsolated(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, utsns.Names{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	err := utsns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrNotIsolated) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterNamespaceIdFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, utsns.Names{})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return("", errors.New("an error"))
+	err := utsns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrNamespaceId) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterSethostnameFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, utsns.Names{Hostname: "test-host", Domainname: "example.com"})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(containerNS, nil)
+	mockSyscallNS.EXPECT().Sethostname("test-host").Return(errors.New("an error"))
+	err := utsns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrSethostname) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func TestEnterSetdomainnameFailure(t *testing.T) {
+	mockCtrl, mockSyscallNS := setupMocks(t)
+	defer mockCtrl.Finish()
+
+	rCtx := initController(t, mockSyscallNS, utsns.Names{Hostname: "test-host", Domainname: "example.com"})
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(containerNS, nil)
+	mockSyscallNS.EXPECT().Sethostname("test-host")
+	mockSyscallNS.EXPECT().Setdomainname("example.com").Return(errors.New("an error"))
+	err := utsns.New(mockSyscallNS).Enter(rCtx)
+	if err == nil || !err.(gerror.Gerror).EqualTag(utsns.ErrSetdomainname) {
+		t.Errorf("Incorrect error %v", err)
+	}
+}
+
+func initController(t *testing.T, mockSyscallNS *mock_syscall.MockSyscallNS, names utsns.Names) kernel.ResourceContext {
+	rCtx := createContext(t, "/", names)
+	mockSyscallNS.EXPECT().NamespaceId(0, syscall.UTSNS).Return(hostNS, nil)
+	if err := utsns.New(mockSyscallNS).Init(rCtx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func createContext(t *testing.T, root string, names utsns.Names) kernel.ResourceContext {
+	rCtx := kernel.CreateResourceContext(root)
+	if err := rCtx.SetConfig(utsns.NamesKey, names); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+// createGeneratedContext creates a resource context whose root filesystem was generated by a mountns.Controller.
+func createGeneratedContext(t *testing.T, root string, names utsns.Names) kernel.ResourceContext {
+	rCtx := createContext(t, root, names)
+	if err := rCtx.SetConfig(mountns.RootKey, root); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return rCtx
+}
+
+func writeFile(t *testing.T, dir string, name string, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func checkFile(t *testing.T, dir string, name string, expected string) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if string(content) != expected {
+		t.Errorf("%s contained %q, expected %q", filepath.Join(dir, name), content, expected)
+	}
+}
+
+func setupMocks(t *testing.T) (*gomock.Controller, *mock_syscall.MockSyscallNS) {
+	mockCtrl := gomock.NewController(t)
+	return mockCtrl, mock_syscall.NewMockSyscallNS(mockCtrl)
+}
@@ -0,0 +1,241 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runner
+
+import (
+	"encoding/json"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	trueSyscall "syscall"
+)
+
+// childEnv is the environment variable which passes the specification of a container's process to the process.
+const childEnv = "GUARDIAN_RUNNER_CHILD"
+
+// errPipeFd is the file descriptor of the pipe on which the container's process reports setup failures.
+const errPipeFd = 3
+
+// startPipeFd is the file descriptor of the pipe which the runner closes when the container's process may set itself up.
+const startPipeFd = 4
+
+// childSpec specifies a container's process.
+type childSpec struct {
+	Context json.RawMessage // the encoded resource context
+	Path    string          // the path of the command, relative to the root file system
+}
+
+// childError reports a setup failure of the container's process to the runner.
+type childError struct {
+	Id      ErrorId
+	Message string
+}
+
+/*
+	Child must be called at the start of the main function of any program which uses BuildContainer with
+	resource controllers that implement kernel.ResourceEnterer. Test programs may call Child from an init
+	function.
+
+	The runner runs the command of such a container in a new instance of the program. In that instance,
+	Child decodes the resource context, as initialised by the resource controllers, waits until the runner
+	has called Started on the resource controllers which implement kernel.ResourceStarter, calls the given
+	function to create the resource controllers, and calls Enter on those which implement
+	kernel.ResourceEnterer in the order in which BuildContainer initialises them. It then changes the
+	root directory to the root file system of the resource context, unless this is "/", applies the
+	process specification of the resource context, and executes the command, so Child does not return.
+	Any failure is reported to the runner, which returns it from the container.
+
+	If the process specification includes a PID namespace, the instance is the init process of the
+	namespace. Rather than executing the command, it runs the command in a child process, reaps orphaned
+	processes, forwards SIGTERM, SIGINT, and SIGHUP to the command, and reports the command's exit status
+	to the runner when the command exits.
+
+	In any other instance of the program, Child returns immediately.
+*/
+func Child(rcs func(rCtx kernel.ResourceContext) []kernel.ResourceController) {
+	spec := os.Getenv(childEnv)
+	if spec == "" {
+		return
+	}
+	gerr := child(spec, rcs)
+	errPipe := os.NewFile(errPipeFd, "error pipe")
+	json.NewEncoder(errPipe).Encode(childError{gerr.Tag().(ErrorId), gerr.Error()})
+	os.Exit(1)
+}
+
+// child sets up the container's process and executes the command. It returns only on failure.
+func child(spec string, rcs func(rCtx kernel.ResourceContext) []kernel.ResourceController) gerror.Gerror {
+	var cs childSpec
+	if err := json.Unmarshal([]byte(spec), &cs); err != nil {
+		return gerror.NewFromError(ErrChildSpec, err)
+	}
+	if err := os.Unsetenv(childEnv); err != nil {
+		return gerror.NewFromError(ErrChildSpec, err)
+	}
+
+	rCtx, gerr := kernel.DecodeResourceContext(cs.Context)
+	if gerr != nil {
+		return gerror.NewFromError(ErrChildSpec, gerr)
+	}
+	startPipe := os.NewFile(startPipeFd, "start pipe")
+	_, err := ioutil.ReadAll(startPipe)
+	startPipe.Close()
+	if err != nil {
+		return gerror.NewFromError(ErrStartController, err)
+	}
+	ordered, _, gerr := orderControllers(rcs(rCtx))
+	if gerr != nil {
+		return gerror.NewFromError(ErrEnterController, gerr)
+	}
+	for _, rc := range ordered {
+		if re, ok := rc.(kernel.ResourceEnterer); ok {
+			if glog.V(2) {
+				glog.Infof("Entering resource controller %v", rc)
+			}
+			if err := re.Enter(rCtx); err != nil {
+				return gerror.NewFromError(ErrEnterController, err)
+			}
+		}
+	}
+
+	if root := rCtx.GetRootFS(); root != "/" {
+		if err := trueSyscall.Chroot(root); err != nil {
+			return gerror.NewFromError(ErrExecCommand, err)
+		}
+	}
+	process := rCtx.GetProcess()
+	if err := trueSyscall.Chdir(workingDir(process)); err != nil {
+		return gerror.NewFromError(ErrExecCommand, err)
+	}
+	env := process.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	if process.Namespaces&syscall.PIDNS != 0 {
+		return runInit(cs.Path, process, env)
+	}
+	if cred := process.Credential; cred != nil {
+		if gerr := setCredential(cred); gerr != nil {
+			return gerr
+		}
+	}
+	trueSyscall.CloseOnExec(errPipeFd)
+	return gerror.NewFromError(ErrExecCommand, trueSyscall.Exec(cs.Path, process.Args, env))
+}
+
+// setCredential sets the user and groups of the container's process.
+func setCredential(cred *kernel.Credential) gerror.Gerror {
+	groups := make([]int, len(cred.Groups))
+	for i, g := range cred.Groups {
+		groups[i] = int(g)
+	}
+	if err := trueSyscall.Setgroups(groups); err != nil {
+		return gerror.NewFromError(ErrExecCommand, err)
+	}
+	if err := trueSyscall.Setgid(int(cred.Gid)); err != nil {
+		return gerror.NewFromError(ErrExecCommand, err)
+	}
+	if err := trueSyscall.Setuid(int(cred.Uid)); err != nil {
+		return gerror.NewFromError(ErrExecCommand, err)
+	}
+	return nil
+}
+
+// childPipes are the runner's ends of the pipes to a container's process.
+type childPipes struct {
+	errors *os.File // the read end of the pipe on which the process reports setup failures
+	start  *os.File // the write end of the pipe which is closed when the process may set itself up
+	status *os.File // the read end of the pipe on which the process reports the command's exit status, or nil
+}
+
+/*
+	childCommand returns a command which runs the command of the given resource context's process
+	specification in a new instance of the program, together with the runner's ends of pipes to the new
+	instance. The status pipe is created only if the process specification includes a PID namespace. The
+	other ends of the pipes are the command's extra files.
+*/
+func childCommand(rCtx kernel.ResourceContext, path string) (*exec.Cmd, *childPipes, gerror.Gerror) {
+	context, gerr := kernel.EncodeResourceContext(rCtx)
+	if gerr != nil {
+		return nil, nil, gerror.NewFromError(ErrStartCommand, gerr)
+	}
+	spec, err := json.Marshal(childSpec{context, path})
+	if err != nil {
+		return nil, nil, gerror.NewFromError(ErrStartCommand, err)
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, gerror.NewFromError(ErrCreatePipe, err)
+	}
+	startR, startW, err := os.Pipe()
+	if err != nil {
+		errR.Close()
+		errW.Close()
+		return nil, nil, gerror.NewFromError(ErrCreatePipe, err)
+	}
+	pipes := &childPipes{errors: errR, start: startW}
+	cmd := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       rCtx.GetProcess().Args,
+		Env:        append(os.Environ(), childEnv+"="+string(spec)),
+		ExtraFiles: []*os.File{errW, startR},
+	}
+	if rCtx.GetProcess().Namespaces&syscall.PIDNS == 0 {
+		return cmd, pipes, nil
+	}
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		pipes.close()
+		errW.Close()
+		startR.Close()
+		return nil, nil, gerror.NewFromError(ErrCreatePipe, err)
+	}
+	pipes.status = statusR
+	cmd.ExtraFiles = append(cmd.ExtraFiles, statusW)
+	return cmd, pipes, nil
+}
+
+// close closes the runner's ends of the pipes other than the status pipe, which is closed once the exit status has been read.
+func (p *childPipes) close() {
+	p.errors.Close()
+	p.start.Close()
+}
+
+/*
+	awaitChild waits until the container's process has executed the command or has failed to set itself up,
+	in which case it returns an error with the tag of the failure. The pipe's write end is closed so that
+	reading reaches end of file once the container's process has executed the command.
+*/
+func awaitChild(cmd *exec.Cmd, errPipe *os.File) gerror.Gerror {
+	cmd.ExtraFiles[0].Close()
+	msg, err := ioutil.ReadAll(errPipe)
+	if err != nil {
+		return gerror.NewFromError(ErrEnterController, err)
+	}
+	if len(msg) == 0 {
+		return nil
+	}
+	var ce childError
+	if err := json.Unmarshal(msg, &ce); err != nil {
+		return gerror.Newf(ErrEnterController, "Container process failed: %s", msg)
+	}
+	return gerror.Newf(ce.Id, "Container process failed: %s", ce.Message)
+}
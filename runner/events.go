@@ -0,0 +1,85 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runner
+
+import (
+	"github.com/cf-guardian/guardian/container"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/golang/glog"
+	"sync"
+)
+
+// An eventLog counts the events reported by the resource controllers of a container while its command runs.
+type eventLog struct {
+	mu     sync.Mutex
+	counts map[kernel.EventType]uint64
+	wg     sync.WaitGroup
+}
+
+/*
+	subscribe subscribes to the events of those of the given resource controllers which implement
+	kernel.ResourceNotifier and returns a log of the events.
+*/
+func subscribe(rCtx kernel.ResourceContext, rcs []kernel.ResourceController) (*eventLog, gerror.Gerror) {
+	l := &eventLog{counts: make(map[kernel.EventType]uint64)}
+	for _, rc := range rcs {
+		if rn, ok := rc.(kernel.ResourceNotifier); ok {
+			events, err := rn.Subscribe(rCtx)
+			if err != nil {
+				glog.Errorf("Subscribing to the events of resource controller %v failed: %s", rc, err)
+				return nil, gerror.NewFromError(ErrSubscribe, err)
+			}
+			l.wg.Add(1)
+			go l.record(events)
+		}
+	}
+	return l, nil
+}
+
+// record counts the events received on the given channel until it is closed.
+func (l *eventLog) record(events <-chan kernel.ResourceEvent) {
+	defer l.wg.Done()
+	for e := range events {
+		if glog.V(1) {
+			glog.Infof("Resource event %+v", e)
+		}
+		l.mu.Lock()
+		l.counts[e.Type] += e.Count
+		l.mu.Unlock()
+	}
+}
+
+/*
+	exit waits until the resource controllers have closed their event channels, which they do when they are
+	torn down, and returns the given return code annotated with the cause suggested by the events. An OOM
+	kill takes precedence over reaching the pids limit.
+*/
+func (l *eventLog) exit(code int) container.Exit {
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case code == 0:
+		return container.Exit{Code: code}
+	case l.counts[kernel.OOMKillEvent] > 0:
+		return container.Exit{Code: code, Cause: container.ExitOOMKilled}
+	case l.counts[kernel.PidsLimitEvent] > 0:
+		return container.Exit{Code: code, Cause: container.ExitPidsLimit}
+	}
+	return container.Exit{Code: code}
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runner
+
+import (
+	"encoding/json"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/golang/glog"
+	"os"
+	"os/exec"
+	"os/signal"
+	trueSyscall "syscall"
+)
+
+// statusPipeFd is the file descriptor of the pipe on which the init process reports the command's exit status.
+const statusPipeFd = 5
+
+// pr_set_child_subreaper is the prctl option which makes a process the reaper of its orphaned descendants.
+const pr_set_child_subreaper = 36
+
+// forwardedSignals are the signals which the init process forwards to the command.
+var forwardedSignals = []os.Signal{trueSyscall.SIGTERM, trueSyscall.SIGINT, trueSyscall.SIGHUP}
+
+// childExit reports the exit status of the command to the runner.
+type childExit struct {
+	Code int
+}
+
+/*
+	runInit runs the command at the given path, which is relative to the root directory, with the given
+	process specification and environment in a child process and acts as the init process of the container's
+	PID namespace until the command exits. It then reports the command's exit status to the runner and exits
+	with the same status. runInit returns only if the command cannot be started.
+
+	The init process is made a child subreaper so that it reaps orphaned processes even if, as in some tests,
+	it is not PID 1.
+*/
+func runInit(path string, process *kernel.ProcessSpec, env []string) gerror.Gerror {
+	if _, _, errno := trueSyscall.RawSyscall(trueSyscall.SYS_PRCTL, pr_set_child_subreaper, 1, 0); errno != 0 {
+		return gerror.NewFromError(ErrExecCommand, errno)
+	}
+	// Signals are received from before the command starts so that the command's exit is not missed.
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, append(forwardedSignals, trueSyscall.SIGCHLD)...)
+
+	trueSyscall.CloseOnExec(errPipeFd)
+	trueSyscall.CloseOnExec(statusPipeFd)
+	cmd := &exec.Cmd{Path: path, Args: process.Args, Env: env, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	if cred := process.Credential; cred != nil {
+		cmd.SysProcAttr = &trueSyscall.SysProcAttr{
+			Credential: &trueSyscall.Credential{Uid: cred.Uid, Gid: cred.Gid, Groups: cred.Groups},
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return gerror.NewFromError(ErrExecCommand, err)
+	}
+	// Closing the error pipe tells the runner that the command has started.
+	os.NewFile(errPipeFd, "error pipe").Close()
+
+	code := supervise(cmd.Process.Pid, signals)
+	if glog.V(1) {
+		glog.Infof("Init: command exited with status %d", code)
+	}
+	statusPipe := os.NewFile(statusPipeFd, "status pipe")
+	if err := json.NewEncoder(statusPipe).Encode(childExit{code}); err != nil {
+		glog.Errorf("Init: failed to report exit status %d: %s", code, err)
+	}
+	os.Exit(code)
+	return nil
+}
+
+/*
+	supervise forwards signals to the process with the given process id and reaps child processes until that
+	process exits, when it returns the process's exit status.
+*/
+func supervise(pid int, signals <-chan os.Signal) int {
+	for sig := range signals {
+		if sig != trueSyscall.SIGCHLD {
+			if glog.V(2) {
+				glog.Infof("Init: forwarding %s to process %d", sig, pid)
+			}
+			if err := trueSyscall.Kill(pid, sig.(trueSyscall.Signal)); err != nil {
+				glog.Warningf("Init: failed to forward %s to process %d: %s", sig, pid, err)
+			}
+			continue
+		}
+		for {
+			var ws trueSyscall.WaitStatus
+			reaped, err := trueSyscall.Wait4(-1, &ws, trueSyscall.WNOHANG, nil)
+			if err == trueSyscall.EINTR {
+				continue
+			}
+			if err != nil || reaped <= 0 {
+				break
+			}
+			if reaped == pid {
+				return exitCode(ws)
+			}
+		}
+	}
+	return -1
+}
+
+/*
+	readExit returns the exit status of the command reported by the init process on the given pipe or, if the
+	init process did not report one, for example because it was killed, the given exit status of the init
+	process.
+*/
+func readExit(statusPipe *os.File, code int) int {
+	var ce childExit
+	if err := json.NewDecoder(statusPipe).Decode(&ce); err != nil {
+		glog.Warningf("Init process did not report the exit status of the command: %s", err)
+		return code
+	}
+	return ce.Code
+}
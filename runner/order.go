@@ -0,0 +1,122 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runner
+
+import (
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+)
+
+/*
+	orderControllers returns the given resource controllers in an order in which each resource controller
+	which implements kernel.ResourceDependent follows the resource controllers providing the capabilities it
+	requires or follows. Resource controllers which do not depend on each other remain in the given order.
+
+	The level of each ordered resource controller is also returned. A resource controller has level 0 if it
+	depends on no other resource controller and otherwise has a level one greater than the highest level of
+	the resource controllers it depends on. So resource controllers with the same level are independent.
+*/
+func orderControllers(rcs []kernel.ResourceController) ([]kernel.ResourceController, []int, gerror.Gerror) {
+	deps, gerr := dependencies(rcs)
+	if gerr != nil {
+		return nil, nil, gerr
+	}
+
+	ordered := make([]kernel.ResourceController, 0, len(rcs))
+	levels := make([]int, 0, len(rcs))
+	level := make([]int, len(rcs)) // the level of each placed resource controller
+	placed := make([]bool, len(rcs))
+	for len(ordered) < len(rcs) {
+		next := -1
+		for i := range rcs {
+			if !placed[i] && allPlaced(deps[i], placed) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []kernel.ResourceController
+			for i, rc := range rcs {
+				if !placed[i] {
+					cycle = append(cycle, rc)
+				}
+			}
+			return nil, nil, gerror.Newf(ErrDependencyCycle, "Resource controllers %v depend on each other", cycle)
+		}
+		for _, d := range deps[next] {
+			if level[d]+1 > level[next] {
+				level[next] = level[d] + 1
+			}
+		}
+		placed[next] = true
+		ordered = append(ordered, rcs[next])
+		levels = append(levels, level[next])
+	}
+	return ordered, levels, nil
+}
+
+/*
+	dependencies returns, for each of the given resource controllers, the indices of the resource controllers
+	it depends on.
+*/
+func dependencies(rcs []kernel.ResourceController) ([][]int, gerror.Gerror) {
+	providers := make(map[kernel.Capability]int)
+	for i, rc := range rcs {
+		if rd, ok := rc.(kernel.ResourceDependent); ok {
+			for _, capability := range rd.Provides() {
+				if p, ok := providers[capability]; ok {
+					return nil, gerror.Newf(ErrDuplicateCapability, "Resource controllers %v and %v both provide %q", rcs[p], rc, capability)
+				}
+				providers[capability] = i
+			}
+		}
+	}
+
+	deps := make([][]int, len(rcs))
+	for i, rc := range rcs {
+		rd, ok := rc.(kernel.ResourceDependent)
+		if !ok {
+			continue
+		}
+		for _, capability := range rd.Requires() {
+			p, ok := providers[capability]
+			if !ok {
+				return nil, gerror.Newf(ErrMissingDependency, "Resource controller %v requires %q which no resource controller provides", rc, capability)
+			}
+			if p == i {
+				return nil, gerror.Newf(ErrDependencyCycle, "Resource controller %v requires %q which it provides", rc, capability)
+			}
+			deps[i] = append(deps[i], p)
+		}
+		for _, capability := range rd.Follows() {
+			if p, ok := providers[capability]; ok && p != i {
+				deps[i] = append(deps[i], p)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// allPlaced returns true if and only if all the given indices have been placed.
+func allPlaced(indices []int, placed []bool) bool {
+	for _, i := range indices {
+		if !placed[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -24,11 +24,507 @@
 package runner
 
 import (
+	"bufio"
 	"github.com/cf-guardian/guardian/container"
+	"github.com/cf-guardian/guardian/gerror"
 	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/syscall_linux"
+	"github.com/golang/glog"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	trueSyscall "syscall"
+	"time"
 )
 
-func BuildContainer(rCtx kernel.ResourceContext, rcs []kernel.ResourceController) container.Container {
+// ErrorId is used for error ids relating to the runner package.
+type ErrorId int
 
+const (
+	ErrInitController      ErrorId = iota // a resource controller failed to initialise
+	ErrEmptyCommand                       // the command to be run was empty
+	ErrContainerUsed                      // the container has already run a command
+	ErrCommandNotFound                    // the command was not found in the root file system
+	ErrCreatePipe                         // a pipe to the command could not be created
+	ErrStartCommand                       // the command could not be started
+	ErrEnterController                    // a resource controller failed to enter the container's process
+	ErrExecCommand                        // the command could not be executed in the container's process
+	ErrChildSpec                          // the container's process could not decode its specification
+	ErrStats                              // a resource controller failed to report its resource usage
+	ErrMissingDependency                  // a resource controller requires a capability which no resource controller provides
+	ErrDependencyCycle                    // resource controllers depend on each other
+	ErrDuplicateCapability                // more than one resource controller provides the same capability
+	ErrSubscribe                          // a resource controller failed to report events
+	ErrStartController                    // a resource controller failed to act on the container's process once it was created
+)
+
+// searchPath is the list of directories, relative to the root file system, searched for commands.
+var searchPath []string = []string{`/usr/local/sbin`, `/usr/local/bin`, `/usr/sbin`, `/usr/bin`, `/sbin`, `/bin`}
+
+// Options modify the behaviour of BuildContainerWithOptions. The zero value gives the behaviour of BuildContainer.
+type Options struct {
+	// Parallel causes resource controllers which do not depend on each other to be initialised in parallel.
+	Parallel bool
+
+	// SyscallNS starts the container's process in the namespaces of its process specification. If nil,
+	// syscall_linux.NewNS() is used.
+	SyscallNS syscall.SyscallNS
+}
+
+/*
+	BuildContainer initialises the given resource controllers using the given resource context and returns
+	a container which runs commands in the resultant isolated environment.
+
+	The resource controllers are initialised in order except that a resource controller which implements
+	kernel.ResourceDependent is initialised after the resource controllers which provide the capabilities
+	it requires or follows. If a required capability is not provided, if resource controllers depend on
+	each other, or if more than one resource controller provides the same capability, no resource
+	controllers are initialised and an error with tag ErrMissingDependency, ErrDependencyCycle, or
+	ErrDuplicateCapability, respectively, is returned.
+
+	If a resource controller fails to initialise, any resource controllers which were already
+	initialised and which implement kernel.ResourceReleaser are torn down in reverse order and an
+	error with tag ErrInitController is returned.
+
+	The returned container runs at most one command. If any of the resource controllers implement
+	kernel.ResourceEnterer or kernel.ResourceStarter, or if the process specification includes a PID
+	namespace, the command is run in a new instance of the program which calls their Enter methods before
+	executing the command (see Child). The new instance waits until the Started methods of the resource
+	controllers which implement kernel.ResourceStarter have been called with its process id. If any of
+	this fails, the resource controllers are torn down in reverse order and an error with tag
+	ErrStartController, ErrEnterController, or ErrExecCommand is returned. Similarly, the resource controllers
+	are torn down if the command cannot be found or started. The container's process is created in the
+	namespaces of the process specification. When the command has finished, the resource controllers are torn down in reverse order
+	before the exit status is made available.
+
+	The events reported by those resource controllers which implement kernel.ResourceNotifier while the
+	command runs are used to annotate a non-zero exit status with its cause, such as an OOM kill. If
+	subscribing to the events fails, the resource controllers are torn down and an error with tag ErrSubscribe
+	is returned.
+*/
+func BuildContainer(rCtx kernel.ResourceContext, rcs []kernel.ResourceController) (container.Container, gerror.Gerror) {
+	return BuildContainerWithOptions(rCtx, rcs, Options{})
+}
+
+// BuildContainerWithOptions builds a container as for BuildContainer but with the given options.
+func BuildContainerWithOptions(rCtx kernel.ResourceContext, rcs []kernel.ResourceController, opts Options) (container.Container, gerror.Gerror) {
+	if glog.V(1) {
+		glog.Infof("BuildContainer(%v, %v, %+v)", rCtx, rcs, opts)
+	}
+	ordered, levels, gerr := orderControllers(rcs)
+	if gerr != nil {
+		glog.Errorf("Ordering resource controllers %v failed: %s", rcs, gerr)
+		return nil, gerr
+	}
+	if opts.Parallel {
+		gerr = initParallel(rCtx, ordered, levels)
+	} else {
+		gerr = initSequential(rCtx, ordered)
+	}
+	if gerr != nil {
+		return nil, gerr
+	}
+	ns := opts.SyscallNS
+	if ns == nil {
+		ns = syscall_linux.NewNS()
+	}
+	c := &cont{rCtx: rCtx, rcs: ordered, ns: ns}
+	return c.run, nil
+}
+
+// initSequential initialises the given resource controllers in order and tears them down if any fail to initialise.
+func initSequential(rCtx kernel.ResourceContext, rcs []kernel.ResourceController) gerror.Gerror {
+	for i, rc := range rcs {
+		if err := rc.Init(rCtx); err != nil {
+			glog.Errorf("Initialising resource controller %v failed: %s", rc, err)
+			teardown(rCtx, rcs[:i])
+			return gerror.NewFromError(ErrInitController, err)
+		}
+	}
 	return nil
 }
+
+/*
+	initParallel initialises the given resource controllers, which have the given levels, a level at a time in
+	increasing order of level. The resource controllers with the same level are initialised in parallel. If any
+	fail to initialise, the resource controllers which were initialised are torn down in reverse order and the
+	failure of the first, in order, to fail is returned.
+*/
+func initParallel(rCtx kernel.ResourceContext, rcs []kernel.ResourceController, levels []int) gerror.Gerror {
+	errs := make([]error, len(rcs))
+	for level := 0; ; level++ {
+		var wg sync.WaitGroup
+		for i, rc := range rcs {
+			if levels[i] == level {
+				wg.Add(1)
+				go func(i int, rc kernel.ResourceController) {
+					defer wg.Done()
+					errs[i] = rc.Init(rCtx)
+				}(i, rc)
+			}
+		}
+		wg.Wait()
+
+		var initialised []kernel.ResourceController
+		var failure error
+		more := false
+		for i, rc := range rcs {
+			switch {
+			case levels[i] > level:
+				more = true
+			case errs[i] != nil:
+				glog.Errorf("Initialising resource controller %v failed: %s", rc, errs[i])
+				if failure == nil {
+					failure = errs[i]
+				}
+			default:
+				initialised = append(initialised, rc)
+			}
+		}
+		if failure != nil {
+			teardown(rCtx, initialised)
+			return gerror.NewFromError(ErrInitController, failure)
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// teardown tears down the given resource controllers in reverse order, logging any failures.
+func teardown(rCtx kernel.ResourceContext, rcs []kernel.ResourceController) {
+	for i := len(rcs) - 1; i >= 0; i-- {
+		if rr, ok := rcs[i].(kernel.ResourceReleaser); ok {
+			if glog.V(2) {
+				glog.Infof("Tearing down resource controller %v", rcs[i])
+			}
+			if err := rr.Teardown(rCtx); err != nil {
+				glog.Warningf("Encountered %q while tearing down resource controller %v", err, rcs[i])
+			}
+		}
+	}
+}
+
+type cont struct {
+	rCtx kernel.ResourceContext
+	rcs  []kernel.ResourceController
+	ns   syscall.SyscallNS
+	mu   sync.Mutex
+	used bool
+}
+
+/*
+	run implements container.Container. The command is split into arguments at white space and is not
+	interpreted by a shell. The command is run with its root directory set to the root file system of
+	the resource context and with the environment, working directory, and credential given by the
+	process specification of the resource context. The arguments are stored in the process specification.
+*/
+func (c *cont) run(command string, input container.InputStream) (container.OutputStream, container.OutputStream, container.ExitStatus, error) {
+	if glog.V(1) {
+		glog.Infof("run(%q)", command)
+	}
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, nil, nil, gerror.New(ErrEmptyCommand, "Empty command")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used {
+		return nil, nil, nil, gerror.New(ErrContainerUsed, "Container has already run a command")
+	}
+	c.used = true
+	started := false
+	defer func() {
+		// If the command fails to start, the resource controllers are torn down.
+		if !started {
+			teardown(c.rCtx, c.rcs)
+		}
+	}()
+
+	root := c.rCtx.GetRootFS()
+	path, gerr := lookPath(root, args[0])
+	if gerr != nil {
+		return nil, nil, nil, gerr
+	}
+	process := c.rCtx.GetProcess()
+	process.Args = args
+	var cmd *exec.Cmd
+	var pipes *childPipes
+	if hasEnterer(c.rcs) || hasStarter(c.rcs) || process.Namespaces&syscall.PIDNS != 0 {
+		cmd, pipes, gerr = childCommand(c.rCtx, path)
+		if gerr != nil {
+			return nil, nil, nil, gerr
+		}
+		defer func() {
+			pipes.close()
+			for _, f := range cmd.ExtraFiles {
+				f.Close()
+			}
+			// Once the command has started, the status pipe is closed when the exit status has been read.
+			if pipes.status != nil && !started {
+				pipes.status.Close()
+			}
+		}()
+	} else {
+		cmd = &exec.Cmd{Path: path, Args: args, Env: process.Env, Dir: workingDir(process)}
+		cmd.SysProcAttr = &trueSyscall.SysProcAttr{}
+		if root != "/" {
+			cmd.SysProcAttr.Chroot = root
+		}
+		if cred := process.Credential; cred != nil {
+			cmd.SysProcAttr.Credential = &trueSyscall.Credential{Uid: cred.Uid, Gid: cred.Gid, Groups: cred.Groups}
+		}
+	}
+
+	// The resource controllers close their event channels when they are torn down, including when the command
+	// fails to start, which ends the recording of events.
+	events, gerr := subscribe(c.rCtx, c.rcs)
+	if gerr != nil {
+		return nil, nil, nil, gerr
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, gerror.NewFromError(ErrCreatePipe, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, gerror.NewFromError(ErrCreatePipe, err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, gerror.NewFromError(ErrCreatePipe, err)
+	}
+	if process.Namespaces != 0 {
+		err = c.ns.StartInNamespaces(cmd, process.Namespaces)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
+		glog.Errorf("Starting %q failed: %s", command, err)
+		return nil, nil, nil, gerror.NewFromError(ErrStartCommand, err)
+	}
+	if pipes != nil {
+		if gerr := startControllers(c.rCtx, c.rcs, cmd.Process.Pid); gerr != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, nil, nil, gerr
+		}
+		// Closing the start pipe allows the container's process to set itself up.
+		pipes.start.Close()
+		if gerr := awaitChild(cmd, pipes.errors); gerr != nil {
+			glog.Errorf("Setting up the process for %q failed: %s", command, gerr)
+			cmd.Wait()
+			return nil, nil, nil, gerr
+		}
+	}
+	started = true
+
+	output := make(container.OutputStream)
+	errOutput := make(container.OutputStream)
+	status := make(container.ExitStatus, 1)
+
+	go copyInput(stdin, input)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go copyOutput(output, stdout, &wg)
+	go copyOutput(errOutput, stderr, &wg)
+
+	go func() {
+		// The output pipes must be drained before waiting for the command.
+		wg.Wait()
+		code := waitStatus(cmd.Wait())
+		if pipes != nil && pipes.status != nil {
+			code = readExit(pipes.status, code)
+			pipes.status.Close()
+		}
+		teardown(c.rCtx, c.rcs)
+		exit := events.exit(code)
+		if glog.V(1) {
+			glog.Infof("%q exited with status %+v", command, exit)
+		}
+		status <- exit
+	}()
+
+	return output, errOutput, status, nil
+}
+
+// workingDir returns the working directory of the given process specification.
+func workingDir(process *kernel.ProcessSpec) string {
+	if process.Dir == "" {
+		return "/"
+	}
+	return process.Dir
+}
+
+// hasEnterer returns true if and only if any of the given resource controllers implement kernel.ResourceEnterer.
+func hasEnterer(rcs []kernel.ResourceController) bool {
+	for _, rc := range rcs {
+		if _, ok := rc.(kernel.ResourceEnterer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStarter returns true if and only if any of the given resource controllers implement kernel.ResourceStarter.
+func hasStarter(rcs []kernel.ResourceController) bool {
+	for _, rc := range rcs {
+		if _, ok := rc.(kernel.ResourceStarter); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// startControllers calls Started, in order, on those of the given resource controllers which implement kernel.ResourceStarter.
+func startControllers(rCtx kernel.ResourceContext, rcs []kernel.ResourceController, pid int) gerror.Gerror {
+	for _, rc := range rcs {
+		if rs, ok := rc.(kernel.ResourceStarter); ok {
+			if glog.V(2) {
+				glog.Infof("Starting resource controller %v for process %d", rc, pid)
+			}
+			if err := rs.Started(rCtx, pid); err != nil {
+				glog.Errorf("Resource controller %v failed to act on process %d: %s", rc, pid, err)
+				return gerror.NewFromError(ErrStartController, err)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+	Stats returns the combined resource usage reported by those of the given resource controllers which
+	implement kernel.ResourceReporter. If more than one resource controller reports a statistic of the
+	same name, the value reported by the later resource controller is returned.
+*/
+func Stats(rCtx kernel.ResourceContext, rcs []kernel.ResourceController) (kernel.ResourceStats, gerror.Gerror) {
+	stats := make(kernel.ResourceStats)
+	for _, rc := range rcs {
+		if rr, ok := rc.(kernel.ResourceReporter); ok {
+			s, err := rr.Stats(rCtx)
+			if err != nil {
+				glog.Errorf("Resource controller %v failed to report resource usage: %s", rc, err)
+				return nil, gerror.NewFromError(ErrStats, err)
+			}
+			for name, value := range s {
+				stats[name] = value
+			}
+		}
+	}
+	return stats, nil
+}
+
+// A StatsSample is the resource usage reported at a particular time, or the error which prevented it being reported.
+type StatsSample struct {
+	Time  time.Time
+	Stats kernel.ResourceStats
+	Err   gerror.Gerror
+}
+
+/*
+	StreamStats returns a channel on which the resource usage reported by Stats is sent immediately and then
+	at the given interval until the done channel is closed, when the returned channel is closed. A sample
+	which cannot be reported carries the error and streaming continues.
+*/
+func StreamStats(rCtx kernel.ResourceContext, rcs []kernel.ResourceController, interval time.Duration,
+	done <-chan struct{}) <-chan StatsSample {
+	samples := make(chan StatsSample)
+	go func() {
+		defer close(samples)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			stats, gerr := Stats(rCtx, rcs)
+			select {
+			case samples <- StatsSample{time.Now(), stats, gerr}:
+			case <-done:
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return samples
+}
+
+/*
+	lookPath returns the path, relative to the given root file system, of the given command. A command
+	containing a slash is used as is. Otherwise the command is searched for in searchPath.
+*/
+func lookPath(root string, name string) (string, gerror.Gerror) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	for _, dir := range searchPath {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(filepath.Join(root, path)); err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", gerror.Newf(ErrCommandNotFound, "Command %q not found in root file system %q", name, root)
+}
+
+// copyInput writes the strings received on the input stream to the command's standard input.
+func copyInput(stdin io.WriteCloser, input container.InputStream) {
+	defer stdin.Close()
+	if input == nil {
+		return
+	}
+	for s := range input {
+		if _, err := io.WriteString(stdin, s); err != nil {
+			glog.Warningf("Failed to write to standard input of command: %s", err)
+			return
+		}
+	}
+}
+
+// copyOutput sends the lines read from the given reader on the given output stream and then closes the stream.
+func copyOutput(output container.OutputStream, r io.Reader, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(output)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			output <- line
+		}
+		if err != nil {
+			if err != io.EOF {
+				glog.Warningf("Failed to read output of command: %s", err)
+			}
+			return
+		}
+	}
+}
+
+// waitStatus converts the result of waiting for a command into an exit status.
+func waitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(trueSyscall.WaitStatus); ok {
+			return exitCode(ws)
+		}
+	}
+	glog.Errorf("Failed to wait for command: %s", err)
+	return -1
+}
+
+// exitCode converts the status of a process which has terminated into an exit status, as a shell would.
+func exitCode(ws trueSyscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
@@ -0,0 +1,819 @@
+/*
+   Copyright 2014 GoPivotal (UK) Limited.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runner_test
+
+import (
+	"code.google.com/p/gomock/gomock"
+	"errors"
+	"fmt"
+	"github.com/cf-guardian/guardian/container"
+	"github.com/cf-guardian/guardian/gerror"
+	"github.com/cf-guardian/guardian/kernel"
+	"github.com/cf-guardian/guardian/kernel/syscall"
+	"github.com/cf-guardian/guardian/kernel/syscall/mock_syscall"
+	"github.com/cf-guardian/guardian/runner"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	trueSyscall "syscall"
+	"testing"
+	"time"
+)
+
+// logMutex serialises writes to the logs of resource controllers which are initialised in parallel.
+var logMutex sync.Mutex
+
+// testResourceController records the calls made to it in a shared log.
+type testResourceController struct {
+	name    string
+	log     *[]string
+	initErr error
+}
+
+func (rc *testResourceController) Init(rCtx kernel.ResourceContext) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	*rc.log = append(*rc.log, "Init "+rc.name)
+	return rc.initErr
+}
+
+func (rc *testResourceController) Teardown(rCtx kernel.ResourceContext) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	*rc.log = append(*rc.log, "Teardown "+rc.name)
+	return nil
+}
+
+// testDependent is a testResourceController with the given dependencies.
+type testDependent struct {
+	testResourceController
+	provides []kernel.Capability
+	requires []kernel.Capability
+	follows  []kernel.Capability
+}
+
+func (rc *testDependent) Provides() []kernel.Capability {
+	return rc.provides
+}
+
+func (rc *testDependent) Requires() []kernel.Capability {
+	return rc.requires
+}
+
+func (rc *testDependent) Follows() []kernel.Capability {
+	return rc.follows
+}
+
+// testBarrier is a testDependent whose initialisation waits until a given number of testBarriers are initialising.
+type testBarrier struct {
+	testDependent
+	wg *sync.WaitGroup
+}
+
+func (rc *testBarrier) Init(rCtx kernel.ResourceContext) error {
+	rc.wg.Done()
+	done := make(chan struct{})
+	go func() {
+		rc.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		return errors.New("resource controllers were not initialised in parallel")
+	}
+	return rc.testDependent.Init(rCtx)
+}
+
+// enterFailEnv causes testEnterer.Enter to fail when set in the environment.
+const enterFailEnv = "RUNNER_TEST_ENTER_FAIL"
+
+// signalHelperEnv causes the test program to run signalHelper when set in the environment.
+const signalHelperEnv = "RUNNER_TEST_SIGNAL_HELPER"
+
+func init() {
+	runner.Child(func(rCtx kernel.ResourceContext) []kernel.ResourceController {
+		return []kernel.ResourceController{&testEnterer{}}
+	})
+	if os.Getenv(signalHelperEnv) != "" {
+		os.Exit(signalHelper())
+	}
+}
+
+/*
+	signalHelper runs as a container's command under the init process. It sends SIGTERM to the init process
+	and exits with status 7 once the init process has forwarded the signal.
+*/
+func signalHelper() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, trueSyscall.SIGTERM)
+	if err := trueSyscall.Kill(os.Getppid(), trueSyscall.SIGTERM); err != nil {
+		return 1
+	}
+	select {
+	case <-signals:
+		return 7
+	case <-time.After(5 * time.Second):
+		return 2
+	}
+}
+
+// testNamespaces adds the given namespaces to the process specification.
+type testNamespaces struct {
+	namespaces syscall.Namespaces
+}
+
+func (rc *testNamespaces) Init(rCtx kernel.ResourceContext) error {
+	rCtx.AddNamespaces(rc.namespaces)
+	return nil
+}
+
+// testEnterer marks the environment of the container's process when it is entered.
+type testEnterer struct{}
+
+func (rc *testEnterer) Init(rCtx kernel.ResourceContext) error {
+	return nil
+}
+
+func (rc *testEnterer) Enter(rCtx kernel.ResourceContext) error {
+	if os.Getenv(enterFailEnv) != "" {
+		return errors.New("an error")
+	}
+	var entered string
+	if _, err := rCtx.GetConfig("runner.test", &entered); err != nil {
+		return err
+	}
+	return os.Setenv("RUNNER_TEST_ENTERED", entered)
+}
+
+/*
+	testStarter records the process id passed to Started and checks that the container's process is still
+	the test program rather than the container's command.
+*/
+type testStarter struct {
+	pid int
+	err error
+}
+
+func (rc *testStarter) Init(rCtx kernel.ResourceContext) error {
+	return nil
+}
+
+func (rc *testStarter) Started(rCtx kernel.ResourceContext, pid int) error {
+	rc.pid = pid
+	if rc.err != nil {
+		return rc.err
+	}
+	// Give the process time to execute the command if it did not wait to be started.
+	time.Sleep(50 * time.Millisecond)
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return err
+	}
+	if self, _ := os.Readlink("/proc/self/exe"); exe != self {
+		return fmt.Errorf("Process is running %q", exe)
+	}
+	return nil
+}
+
+// testResourceReporter reports the given statistics.
+type testResourceReporter struct {
+	stats kernel.ResourceStats
+	err   error
+}
+
+func (rc *testResourceReporter) Init(rCtx kernel.ResourceContext) error {
+	return nil
+}
+
+func (rc *testResourceReporter) Stats(rCtx kernel.ResourceContext) (kernel.ResourceStats, error) {
+	return rc.stats, rc.err
+}
+
+/*
+	testNotifier sends the given events to its subscriber when it is torn down and then closes the
+	subscriber's channel.
+*/
+type testNotifier struct {
+	events       []kernel.ResourceEvent
+	err          error
+	subscription chan kernel.ResourceEvent
+	tornDown     bool
+}
+
+func (rc *testNotifier) Init(rCtx kernel.ResourceContext) error {
+	return nil
+}
+
+func (rc *testNotifier) Subscribe(rCtx kernel.ResourceContext) (<-chan kernel.ResourceEvent, error) {
+	if rc.err != nil {
+		return nil, rc.err
+	}
+	rc.subscription = make(chan kernel.ResourceEvent)
+	return rc.subscription, nil
+}
+
+func (rc *testNotifier) Teardown(rCtx kernel.ResourceContext) error {
+	rc.tornDown = true
+	if rc.subscription == nil {
+		return nil
+	}
+	for _, e := range rc.events {
+		rc.subscription <- e
+	}
+	close(rc.subscription)
+	return nil
+}
+
+func TestBuildContainerInitOrder(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testResourceController{name: "a", log: &log},
+		&testResourceController{name: "b", log: &log},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c == nil || gerr != nil {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+		return
+	}
+	expected := []string{"Init a", "Init b"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestBuildContainerRollback(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testResourceController{name: "a", log: &log},
+		&testResourceController{name: "b", log: &log},
+		&testResourceController{name: "c", log: &log, initErr: errors.New("an error")},
+		&testResourceController{name: "d", log: &log},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c != nil || gerr == nil || !gerr.EqualTag(runner.ErrInitController) {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+		return
+	}
+	expected := []string{"Init a", "Init b", "Init c", "Teardown b", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestBuildContainerDependencyOrder(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testDependent{testResourceController{name: "a", log: &log}, nil, []kernel.Capability{"y"}, nil},
+		&testDependent{testResourceController{name: "b", log: &log}, nil, nil, []kernel.Capability{"x", "z"}},
+		&testResourceController{name: "c", log: &log},
+		&testDependent{testResourceController{name: "d", log: &log}, []kernel.Capability{"x"}, nil, nil},
+		&testDependent{testResourceController{name: "e", log: &log}, []kernel.Capability{"y"}, []kernel.Capability{"x"}, nil},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c == nil || gerr != nil {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+		return
+	}
+	expected := []string{"Init c", "Init d", "Init b", "Init e", "Init a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestBuildContainerMissingDependency(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testResourceController{name: "a", log: &log},
+		&testDependent{testResourceController{name: "b", log: &log}, nil, []kernel.Capability{"x"}, nil},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c != nil || gerr == nil || !gerr.EqualTag(runner.ErrMissingDependency) {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+	}
+	if len(log) != 0 {
+		t.Errorf("Unexpected calls %v", log)
+	}
+}
+
+func TestBuildContainerDependencyCycle(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testDependent{testResourceController{name: "a", log: &log}, []kernel.Capability{"x"}, []kernel.Capability{"z"}, nil},
+		&testDependent{testResourceController{name: "b", log: &log}, []kernel.Capability{"y"}, []kernel.Capability{"x"}, nil},
+		&testDependent{testResourceController{name: "c", log: &log}, []kernel.Capability{"z"}, nil, []kernel.Capability{"y"}},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c != nil || gerr == nil || !gerr.EqualTag(runner.ErrDependencyCycle) {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+	}
+	if len(log) != 0 {
+		t.Errorf("Unexpected calls %v", log)
+	}
+}
+
+func TestBuildContainerSelfDependency(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testDependent{testResourceController{name: "a", log: &log}, []kernel.Capability{"x"}, []kernel.Capability{"x"}, nil},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c != nil || gerr == nil || !gerr.EqualTag(runner.ErrDependencyCycle) {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+	}
+}
+
+func TestBuildContainerDuplicateCapability(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testDependent{testResourceController{name: "a", log: &log}, []kernel.Capability{"x"}, nil, nil},
+		&testDependent{testResourceController{name: "b", log: &log}, []kernel.Capability{"x"}, nil, nil},
+	}
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if c != nil || gerr == nil || !gerr.EqualTag(runner.ErrDuplicateCapability) {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+	}
+}
+
+func TestBuildContainerParallel(t *testing.T) {
+	var log []string
+	var wg sync.WaitGroup
+	wg.Add(2)
+	rcs := []kernel.ResourceController{
+		&testDependent{testResourceController{name: "c", log: &log}, nil, []kernel.Capability{"x", "y"}, nil},
+		&testBarrier{testDependent{testResourceController{name: "a", log: &log}, []kernel.Capability{"x"}, nil, nil}, &wg},
+		&testBarrier{testDependent{testResourceController{name: "b", log: &log}, []kernel.Capability{"y"}, nil, nil}, &wg},
+	}
+	c, gerr := runner.BuildContainerWithOptions(kernel.CreateResourceContext("/"), rcs, runner.Options{Parallel: true})
+	if c == nil || gerr != nil {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+		return
+	}
+	if len(log) != 3 || log[2] != "Init c" {
+		t.Errorf("Incorrect calls %v", log)
+	}
+}
+
+func TestBuildContainerParallelRollback(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{
+		&testDependent{testResourceController{name: "a", log: &log}, []kernel.Capability{"x"}, nil, nil},
+		&testResourceController{name: "b", log: &log, initErr: errors.New("an error")},
+		&testDependent{testResourceController{name: "c", log: &log}, nil, []kernel.Capability{"x"}, nil},
+	}
+	c, gerr := runner.BuildContainerWithOptions(kernel.CreateResourceContext("/"), rcs, runner.Options{Parallel: true})
+	if c != nil || gerr == nil || !gerr.EqualTag(runner.ErrInitController) {
+		t.Errorf("Incorrect return values (%v, %s)", c, gerr)
+		return
+	}
+	if len(log) != 3 || log[2] != "Teardown a" {
+		t.Errorf("Incorrect calls %v", log)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{&testResourceController{name: "a", log: &log}}
+	c := buildContainer(t, rcs)
+
+	output, errOutput, status, err := c("echo hello world", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	out, errOut := drain(output, errOutput)
+	if out != "hello world\n" || errOut != "" {
+		t.Errorf("Incorrect output %q and error output %q", out, errOut)
+	}
+	if s := <-status; s.Code != 0 {
+		t.Errorf("Incorrect exit status %+v", s)
+	}
+	expected := []string{"Init a", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestRunCommandWithInput(t *testing.T) {
+	c := buildContainer(t, nil)
+
+	input := make(container.InputStream)
+	output, errOutput, status, err := c("cat", input)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	go func() {
+		input <- "line 1\n"
+		input <- "line 2\n"
+		close(input)
+	}()
+	out, _ := drain(output, errOutput)
+	if out != "line 1\nline 2\n" {
+		t.Errorf("Incorrect output %q", out)
+	}
+	if s := <-status; s.Code != 0 {
+		t.Errorf("Incorrect exit status %+v", s)
+	}
+}
+
+func TestRunCommandExitStatus(t *testing.T) {
+	c := buildContainer(t, nil)
+
+	output, errOutput, status, err := c("false", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	drain(output, errOutput)
+	if s := <-status; s.Code != 1 {
+		t.Errorf("Incorrect exit status %+v", s)
+	}
+}
+
+func TestRunCommandExitCause(t *testing.T) {
+	testExitCause(t, "false", []kernel.ResourceEvent{{Type: kernel.OOMEvent, Count: 1}}, container.Exit{Code: 1, Cause: container.ExitNormal})
+	testExitCause(t, "false", []kernel.ResourceEvent{
+		{Type: kernel.PidsLimitEvent, Count: 1},
+		{Type: kernel.OOMKillEvent, Count: 1},
+	}, container.Exit{Code: 1, Cause: container.ExitOOMKilled})
+	testExitCause(t, "false", []kernel.ResourceEvent{{Type: kernel.PidsLimitEvent, Count: 2}}, container.Exit{Code: 1, Cause: container.ExitPidsLimit})
+	testExitCause(t, "true", []kernel.ResourceEvent{{Type: kernel.OOMKillEvent, Count: 1}}, container.Exit{Code: 0, Cause: container.ExitNormal})
+}
+
+func testExitCause(t *testing.T, command string, events []kernel.ResourceEvent, expected container.Exit) {
+	c := buildContainer(t, []kernel.ResourceController{&testNotifier{events: events}})
+
+	output, errOutput, status, err := c(command, nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	drain(output, errOutput)
+	if s := <-status; s != expected {
+		t.Errorf("Exit status of %q with events %v was %+v, expected %+v", command, events, s, expected)
+	}
+}
+
+func TestRunSubscribeFailure(t *testing.T) {
+	c := buildContainer(t, []kernel.ResourceController{&testNotifier{err: errors.New("an error")}})
+
+	_, _, _, err := c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrSubscribe) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestRunSubscribeStartFailure(t *testing.T) {
+	notifier := &testNotifier{}
+	c := buildContainer(t, []kernel.ResourceController{notifier, &testStarter{err: errors.New("an error")}})
+
+	_, _, _, err := c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrStartController) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	if !notifier.tornDown {
+		t.Errorf("Resource controller was not torn down")
+	}
+	select {
+	case _, ok := <-notifier.subscription:
+		if ok {
+			t.Errorf("Unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Subscription was not closed")
+	}
+}
+
+func TestRunEmptyCommand(t *testing.T) {
+	c := buildContainer(t, nil)
+
+	_, _, _, err := c(" ", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrEmptyCommand) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestRunCommandNotFound(t *testing.T) {
+	var log []string
+	c := buildContainer(t, []kernel.ResourceController{&testResourceController{name: "a", log: &log}})
+
+	_, _, _, err := c("no-such-command", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrCommandNotFound) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	expected := []string{"Init a", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+
+	_, _, _, err = c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrContainerUsed) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestRunTwice(t *testing.T) {
+	c := buildContainer(t, nil)
+
+	output, errOutput, status, err := c("true", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	drain(output, errOutput)
+	<-status
+
+	_, _, _, err = c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrContainerUsed) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestRunCommandProcessSpec(t *testing.T) {
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{
+		Process: kernel.ProcessSpec{Env: []string{"A=b"}, Dir: "/tmp"},
+	})
+	c, gerr := runner.BuildContainer(rCtx, nil)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	output, errOutput, status, err := c("env", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	out, _ := drain(output, errOutput)
+	if out != "A=b\n" {
+		t.Errorf("Incorrect environment %q", out)
+	}
+	<-status
+	if args := rCtx.GetProcess().Args; !reflect.DeepEqual(args, []string{"env"}) {
+		t.Errorf("Incorrect arguments %v in process specification", args)
+	}
+}
+
+func TestRunCommandWorkingDir(t *testing.T) {
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{Process: kernel.ProcessSpec{Dir: "/tmp"}})
+	c, gerr := runner.BuildContainer(rCtx, []kernel.ResourceController{&testEnterer{}})
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	output, errOutput, status, err := c("pwd", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	if out, _ := drain(output, errOutput); out != "/tmp\n" {
+		t.Errorf("Incorrect working directory %q", out)
+	}
+	<-status
+}
+
+func TestRunCommandEnter(t *testing.T) {
+	var log []string
+	rCtx := kernel.CreateResourceContext("/")
+	rCtx.SetConfig("runner.test", "true")
+	rcs := []kernel.ResourceController{&testResourceController{name: "a", log: &log}, &testEnterer{}}
+	c, gerr := runner.BuildContainer(rCtx, rcs)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+
+	output, errOutput, status, err := c("env", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	out, _ := drain(output, errOutput)
+	if !strings.Contains(out, "RUNNER_TEST_ENTERED=true\n") {
+		t.Errorf("Resource controller was not entered: %q", out)
+	}
+	if strings.Contains(out, "GUARDIAN_RUNNER_CHILD") {
+		t.Errorf("Process specification was passed to the command: %q", out)
+	}
+	if s := <-status; s.Code != 0 {
+		t.Errorf("Incorrect exit status %+v", s)
+	}
+	expected := []string{"Init a", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestRunCommandEnterFailure(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{&testResourceController{name: "a", log: &log}, &testEnterer{}}
+	c := buildContainer(t, rcs)
+
+	os.Setenv(enterFailEnv, "true")
+	defer os.Unsetenv(enterFailEnv)
+	_, _, _, err := c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrEnterController) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	expected := []string{"Init a", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+
+	_, _, _, err = c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrContainerUsed) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestRunCommandEnterNotFound(t *testing.T) {
+	c := buildContainer(t, []kernel.ResourceController{&testEnterer{}})
+
+	_, _, _, err := c("/no-such-command", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrExecCommand) {
+		t.Errorf("Incorrect error %s", err)
+	}
+}
+
+func TestRunCommandStarted(t *testing.T) {
+	var log []string
+	starter := &testStarter{}
+	c := buildContainer(t, []kernel.ResourceController{&testResourceController{name: "a", log: &log}, starter})
+
+	output, errOutput, status, err := c("true", nil)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	drain(output, errOutput)
+	if s := <-status; s.Code != 0 {
+		t.Errorf("Incorrect exit status %+v", s)
+	}
+	if starter.pid <= 0 {
+		t.Errorf("Incorrect process id %d", starter.pid)
+	}
+	expected := []string{"Init a", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestRunCommandStartedFailure(t *testing.T) {
+	var log []string
+	rcs := []kernel.ResourceController{&testResourceController{name: "a", log: &log}, &testStarter{err: errors.New("an error")}}
+	c := buildContainer(t, rcs)
+
+	_, _, _, err := c("true", nil)
+	if err == nil || !err.(gerror.Gerror).EqualTag(runner.ErrStartController) {
+		t.Errorf("Incorrect error %s", err)
+	}
+	expected := []string{"Init a", "Teardown a"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Calls were %v, expected %v", log, expected)
+	}
+}
+
+func TestStats(t *testing.T) {
+	rcs := []kernel.ResourceController{
+		&testResourceReporter{stats: kernel.ResourceStats{"a": 1, "b": 2}},
+		&testEnterer{},
+		&testResourceReporter{stats: kernel.ResourceStats{"b": 3, "c": 4}},
+	}
+	stats, gerr := runner.Stats(kernel.CreateResourceContext("/"), rcs)
+	if gerr != nil {
+		t.Errorf("%s", gerr)
+		return
+	}
+	expected := kernel.ResourceStats{"a": 1, "b": 3, "c": 4}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Stats were %v, expected %v", stats, expected)
+	}
+}
+
+func TestStatsFailure(t *testing.T) {
+	rcs := []kernel.ResourceController{&testResourceReporter{err: errors.New("an error")}}
+	_, gerr := runner.Stats(kernel.CreateResourceContext("/"), rcs)
+	if gerr == nil || !gerr.EqualTag(runner.ErrStats) {
+		t.Errorf("Incorrect error %s", gerr)
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	rcs := []kernel.ResourceController{&testResourceReporter{stats: kernel.ResourceStats{"a": 1}}}
+	done := make(chan struct{})
+	samples := runner.StreamStats(kernel.CreateResourceContext("/"), rcs, time.Millisecond, done)
+	for i := 0; i < 3; i++ {
+		sample := <-samples
+		if sample.Err != nil || sample.Stats["a"] != 1 || sample.Time.IsZero() {
+			t.Errorf("Incorrect sample %v", sample)
+		}
+	}
+	close(done)
+	for _ = range samples {
+	}
+}
+
+func TestStreamStatsFailure(t *testing.T) {
+	rcs := []kernel.ResourceController{&testResourceReporter{err: errors.New("an error")}}
+	done := make(chan struct{})
+	defer close(done)
+	samples := runner.StreamStats(kernel.CreateResourceContext("/"), rcs, time.Millisecond, done)
+	if sample := <-samples; sample.Err == nil || !sample.Err.EqualTag(runner.ErrStats) {
+		t.Errorf("Incorrect error %s", sample.Err)
+	}
+}
+
+func TestRunInit(t *testing.T) {
+	testRunInit(t, "false", nil, 1)
+	testRunInit(t, "true", nil, 0)
+}
+
+func TestRunInitForwardsSignals(t *testing.T) {
+	executable, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	testRunInit(t, executable, []string{signalHelperEnv + "=1"}, 7)
+}
+
+/*
+	testRunInit runs the given command in a container with a PID namespace and checks its exit status. The
+	namespace is not created, so the tests do not need privileges, but the init process is run.
+*/
+func testRunInit(t *testing.T, command string, env []string, expected int) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockSyscallNS := mock_syscall.NewMockSyscallNS(mockCtrl)
+	var startErr error
+	mockSyscallNS.EXPECT().StartInNamespaces(gomock.Any(), syscall.PIDNS).Do(func(cmd *exec.Cmd, namespaces syscall.Namespaces) {
+		startErr = cmd.Start()
+	})
+
+	rCtx := kernel.CreateResourceContextWithOptions("/", kernel.ContextOptions{Process: kernel.ProcessSpec{Env: env}})
+	rcs := []kernel.ResourceController{&testNamespaces{syscall.PIDNS}}
+	c, gerr := runner.BuildContainerWithOptions(rCtx, rcs, runner.Options{SyscallNS: mockSyscallNS})
+	if gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	output, errOutput, status, err := c(command, nil)
+	if startErr != nil {
+		t.Fatalf("%s", startErr)
+	}
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	_, errOut := drain(output, errOutput)
+	if s := <-status; s.Code != expected {
+		t.Errorf("Exit status of %q was %+v, expected %d: %q", command, s, expected, errOut)
+	}
+}
+
+func buildContainer(t *testing.T, rcs []kernel.ResourceController) container.Container {
+	c, gerr := runner.BuildContainer(kernel.CreateResourceContext("/"), rcs)
+	if gerr != nil {
+		t.Fatalf("%s", gerr)
+	}
+	return c
+}
+
+// drain reads the given output and error streams until they are closed and returns their contents.
+func drain(output container.OutputStream, errOutput container.OutputStream) (string, string) {
+	var out, errOut []string
+	for output != nil || errOutput != nil {
+		select {
+		case s, ok := <-output:
+			if !ok {
+				output = nil
+			} else {
+				out = append(out, s)
+			}
+		case s, ok := <-errOutput:
+			if !ok {
+				errOutput = nil
+			} else {
+				errOut = append(errOut, s)
+			}
+		}
+	}
+	return strings.Join(out, ""), strings.Join(errOut, "")
+}
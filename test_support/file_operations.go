@@ -17,6 +17,7 @@
 package test_support
 
 import (
+	"github.com/cf-guardian/guardian/kernel/rootfs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -63,7 +64,6 @@ func TestCreateFileWithMode(t *testing.T, td string, fileName string, mode os.Fi
 	return CreateFileWithMode(td, fileName, mode), nil
 }
 
-
 func CreateDir(path string, dirName string) string {
 	return CreateDirWithMode(path, dirName, os.FileMode(0755))
 }
@@ -75,8 +75,13 @@ func CreateDirWithMode(path string, dirName string, mode os.FileMode) string {
 	return fp
 }
 
+// RootFSDirs returns the paths of the default read-write directories of a generated root filesystem.
 func RootFSDirs() []string {
-	return []string{`proc`, `dev`, `etc`, `home`, `sbin`, `var`, `tmp`}
+	dirs := []string{}
+	for _, dir := range rootfs.DefaultDirs() {
+		dirs = append(dirs, dir.Path)
+	}
+	return dirs
 }
 
 func CreatePrototype(baseDir string) string {
@@ -114,7 +119,7 @@ func FileExists(path string) bool {
 	return true
 }
 
-func CleanupDirs(t *testing.T, paths... string) {
+func CleanupDirs(t *testing.T, paths ...string) {
 	for _, path := range paths {
 		if err := os.RemoveAll(path); err != nil {
 			t.Errorf("Could not delete %s", path)